@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/ccamel/kynaptik/pkg/action/amqpaction"    // registers the amqp actions
+	_ "github.com/ccamel/kynaptik/pkg/action/graphqlaction" // registers the graphql actions
+	_ "github.com/ccamel/kynaptik/pkg/action/httpaction"    // registers the http actions
+	_ "github.com/ccamel/kynaptik/pkg/action/kafkaaction"   // registers the kafka actions
+	_ "github.com/ccamel/kynaptik/pkg/action/natsaction"    // registers the nats actions
+	_ "github.com/ccamel/kynaptik/pkg/action/smtpaction"    // registers the smtp actions
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/afero"
+)
+
+// DefaultNamespace specifies the namespace used when a route doesn't specify one.
+const DefaultNamespace = "default"
+
+// kind is a kind of action which can be served.
+type kind struct {
+	configFactory kynaptik.ConfigFactory
+	actionFactory kynaptik.ActionFactory
+}
+
+// KindAll is the kind of the routes handling all the kinds of action, selected according to the scheme of their uri.
+const KindAll = "all"
+
+// kinds contains the kinds of action available, by name: the registered ones along with the one handling all of them.
+var kinds = func() map[string]kind {
+	kinds := map[string]kind{
+		KindAll: {configFactory: kynaptik.DefaultConfig},
+	}
+
+	for _, k := range kynaptik.ActionKinds() {
+		kinds[k.Name] = kind{configFactory: k.ConfigFactory, actionFactory: k.ActionFactory}
+	}
+
+	return kinds
+}()
+
+// route binds an HTTP path to a kind of action, whose configuration and secret are looked up in the given namespace.
+type route struct {
+	path      string
+	kind      string
+	namespace string
+}
+
+// parseRoute parses a route of the form `path=kind[@namespace]`, e.g. `/hook=http@default`.
+func parseRoute(s string) (route, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return route{}, fmt.Errorf("malformed route '%s', expected 'path=kind[@namespace]'", s)
+	}
+
+	r := route{path: parts[0], kind: parts[1], namespace: DefaultNamespace}
+
+	if i := strings.Index(r.kind, "@"); i >= 0 {
+		r.kind, r.namespace = r.kind[:i], r.kind[i+1:]
+	}
+
+	if !strings.HasPrefix(r.path, "/") {
+		return route{}, fmt.Errorf("malformed route '%s', path shall start with '/'", s)
+	}
+
+	if _, ok := kinds[r.kind]; !ok {
+		return route{}, fmt.Errorf("unsupported kind '%s' for route '%s', expected one of: %s", r.kind, s, kindNames())
+	}
+
+	if r.namespace == "" {
+		return route{}, fmt.Errorf("malformed route '%s', empty namespace", s)
+	}
+
+	return r, nil
+}
+
+// routes is a flag.Value accumulating routes.
+type routes []route
+
+func (rs *routes) String() string {
+	elems := make([]string, 0, len(*rs))
+	for _, r := range *rs {
+		elems = append(elems, fmt.Sprintf("%s=%s@%s", r.path, r.kind, r.namespace))
+	}
+
+	return strings.Join(elems, ",")
+}
+
+func (rs *routes) Set(s string) error {
+	r, err := parseRoute(s)
+	if err != nil {
+		return err
+	}
+
+	*rs = append(*rs, r)
+
+	return nil
+}
+
+func kindNames() string {
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// newHandler returns the handler serving the given routes, the configurations and secrets being loaded from the
+// given filesystem, following the same layout than the one used by Fission, i.e.:
+//
+//	/configs/<namespace>/.../function-spec.yml
+//	/secrets/<namespace>/.../function-secret.yml
+func newHandler(fs afero.Fs, logger zerolog.Logger, rs []route) (http.Handler, error) {
+	if len(rs) == 0 {
+		return nil, errors.New("no route specified")
+	}
+
+	mux := http.NewServeMux()
+
+	for _, r := range rs {
+		k := kinds[r.kind]
+		namespace := r.namespace
+		name := r.path
+
+		mux.Handle(r.path, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// the route identifies the function, e.g. for its limits not to be shared with the other routes
+			req.Header.Set("X-Fission-Function-Namespace", namespace)
+			req.Header.Set("X-Fission-Function-Name", name)
+
+			kynaptik.Invokeλ(w, req, fs, k.configFactory, k.actionFactory)
+		}))
+	}
+
+	return hlog.NewHandler(logger)(mux), nil
+}
+
+// serve serves the handler on the given address until the context is done, then shuts down the server gracefully,
+// waiting for the in-flight requests to complete for at most the given timeout.
+func serve(ctx context.Context, addr string, handler http.Handler, shutdownTimeout time.Duration) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	log.Info().Str("addr", addr).Msg("🚀 server started")
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info().Dur("timeout", shutdownTimeout).Msg("🛑 shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+
+	// no more request is accepted, wait for the invocations still running in the background
+	if err := kynaptik.Drain(shutdownCtx); err != nil {
+		return fmt.Errorf("background invocations not drained: %w", err)
+	}
+
+	log.Info().Msg("🏁️ server stopped")
+
+	return nil
+}
+
+func main() {
+	var rs routes
+
+	addr := flag.String("addr", ":8080", "the address to listen on")
+	dir := flag.String("dir", ".", "the directory containing the configs/ and secrets/ folders")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "the maximum duration to wait for in-flight requests (and background invocations) on shutdown")
+
+	flag.Var(&rs, "route", fmt.Sprintf("a route of the form 'path=kind[@namespace]' (repeatable), kind being one of: %s", kindNames()))
+	flag.Parse()
+
+	handler, err := newHandler(afero.NewBasePathFs(afero.NewOsFs(), *dir), log.Logger, rs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid arguments")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		s := <-signals
+		log.Info().Str("signal", s.String()).Msg("signal received")
+		cancel()
+	}()
+
+	if err := serve(ctx, *addr, handler, *shutdownTimeout); err != nil {
+		log.Fatal().Err(err).Msg("server failure")
+	}
+}
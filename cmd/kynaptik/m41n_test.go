@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phayes/freeport"
+	"github.com/rs/zerolog/log"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestParseRoute(t *testing.T) {
+	Convey("Considering the parsing of routes", t, func(c C) {
+		cases := []struct {
+			in       string
+			expected route
+			err      string
+		}{
+			{in: "/hook=http", expected: route{path: "/hook", kind: "http", namespace: "default"}},
+			{in: "/hook=graphql@my-namespace", expected: route{path: "/hook", kind: "graphql", namespace: "my-namespace"}},
+			{in: "/hook=all", expected: route{path: "/hook", kind: "all", namespace: "default"}},
+			{in: "/a/b=http@my-namespace/my-function", expected: route{path: "/a/b", kind: "http", namespace: "my-namespace/my-function"}},
+			{in: "/hook", err: "malformed route '/hook', expected 'path=kind[@namespace]'"},
+			{in: "=http", err: "malformed route '=http', expected 'path=kind[@namespace]'"},
+			{in: "hook=http", err: "malformed route 'hook=http', path shall start with '/'"},
+			{in: "/hook=ftp", err: "unsupported kind 'ftp' for route '/hook=ftp', expected one of: all, amqp, graphql, http, kafka, nats, smtp"},
+			{in: "/hook=http@", err: "malformed route '/hook=http@', empty namespace"},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When parsing '%s'", tc.in), func() {
+				r, err := parseRoute(tc.in)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.err != "" {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					} else {
+						So(err, ShouldBeNil)
+						So(r, ShouldResemble, tc.expected)
+					}
+				})
+			})
+		}
+	})
+}
+
+func TestRoutesFlag(t *testing.T) {
+	Convey("Given a routes flag", t, func(c C) {
+		var rs routes
+
+		Convey("When setting several routes", func() {
+			So(rs.Set("/a=http"), ShouldBeNil)
+			So(rs.Set("/b=graphql@ns"), ShouldBeNil)
+			So(rs.Set("/c"), ShouldNotBeNil)
+
+			Convey("Then routes shall be accumulated", func() {
+				So(rs, ShouldHaveLength, 2)
+				So(rs.String(), ShouldEqual, "/a=http@default,/b=graphql@ns")
+			})
+		})
+	})
+}
+
+func TestServer(t *testing.T) {
+	Convey("Given a server hosting an http action", t, func(c C) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload, _ := ioutil.ReadAll(r.Body)
+			c.So(string(payload), ShouldEqual, "Hello john")
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer target.Close()
+
+		fs := afero.NewMemMapFs()
+		So(afero.WriteFile(fs, "/configs/my-namespace/my-function/function-spec.yml", []byte(fmt.Sprintf(`
+action: |
+  uri: %s
+  method: POST
+  body: Hello {{ .data.name }}
+`, target.URL)), 0644), ShouldBeNil)
+
+		_, err := newHandler(fs, log.Logger, nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "no route specified")
+
+		handler, err := newHandler(fs, log.Logger, []route{
+			{path: "/hook", kind: "http", namespace: "my-namespace"},
+			{path: "/other", kind: "http", namespace: "other-namespace"},
+		})
+		So(err, ShouldBeNil)
+
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+
+		go func() {
+			done <- serve(ctx, addr, handler, 5*time.Second)
+		}()
+
+		So(waitForListener(addr), ShouldBeNil)
+
+		Convey("When posting an event on the route", func() {
+			body, code := post(fmt.Sprintf("http://%s/hook", addr), `{"name":"john"}`)
+
+			Convey("Then the action shall be performed", func() {
+				So(code, ShouldEqual, http.StatusOK)
+				So(body, ShouldContainSubstring, `"status":"success"`)
+			})
+		})
+
+		Convey("When posting an event on a route whose namespace has no configuration", func() {
+			body, code := post(fmt.Sprintf("http://%s/other", addr), `{"name":"john"}`)
+
+			Convey("Then configuration shall not be found", func() {
+				So(code, ShouldEqual, http.StatusServiceUnavailable)
+				So(body, ShouldContainSubstring, "no configuration file function-spec.yml found in /configs/other-namespace")
+			})
+		})
+
+		Convey("When posting an event on an unknown route", func() {
+			_, code := post(fmt.Sprintf("http://%s/unknown", addr), `{"name":"john"}`)
+
+			Convey("Then it shall be not found", func() {
+				So(code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Reset(func() {
+			cancel()
+			So(<-done, ShouldBeNil)
+		})
+	})
+}
+
+func TestServerAsync(t *testing.T) {
+	Convey("Given a server hosting an asynchronous http action", t, func(c C) {
+		var calls int32
+
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			atomic.AddInt32(&calls, 1)
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer target.Close()
+
+		fs := afero.NewMemMapFs()
+		So(afero.WriteFile(fs, "/configs/my-namespace/my-function/function-spec.yml", []byte(fmt.Sprintf(`
+async: true
+action: |
+  uri: %s
+  method: POST
+  body: Hello {{ .data.name }}
+`, target.URL)), 0644), ShouldBeNil)
+
+		handler, err := newHandler(fs, log.Logger, []route{
+			{path: "/hook", kind: "http", namespace: "my-namespace"},
+		})
+		So(err, ShouldBeNil)
+
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+
+		go func() {
+			done <- serve(ctx, addr, handler, 5*time.Second)
+		}()
+
+		So(waitForListener(addr), ShouldBeNil)
+
+		Convey("When posting an event on the route", func() {
+			body, code := post(fmt.Sprintf("http://%s/hook", addr), `{"name":"john"}`)
+
+			Convey("Then the event shall be accepted before the action is performed", func() {
+				So(code, ShouldEqual, http.StatusAccepted)
+				So(body, ShouldContainSubstring, `"stage":"accepted"`)
+				So(body, ShouldContainSubstring, `"requestId":"`)
+				So(atomic.LoadInt32(&calls), ShouldEqual, 0)
+
+				Convey("And the action shall be performed before the server stops", func() {
+					cancel()
+					So(<-done, ShouldBeNil)
+					So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Reset(func() {
+			cancel()
+		})
+	})
+}
+
+func post(url, payload string) (string, int) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(payload)) //nolint:gosec // test url
+	So(err, ShouldBeNil)
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	So(err, ShouldBeNil)
+
+	return string(body), resp.StatusCode
+}
+
+func waitForListener(addr string) error {
+	var err error
+
+	for i := 0; i < 50; i++ {
+		var conn net.Conn
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			return conn.Close()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return err
+}
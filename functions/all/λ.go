@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+
+	_ "github.com/ccamel/kynaptik/pkg/action/amqpaction"    // registers the amqp actions
+	_ "github.com/ccamel/kynaptik/pkg/action/graphqlaction" // registers the graphql actions
+	_ "github.com/ccamel/kynaptik/pkg/action/httpaction"    // registers the http actions
+	_ "github.com/ccamel/kynaptik/pkg/action/kafkaaction"   // registers the kafka actions
+	_ "github.com/ccamel/kynaptik/pkg/action/natsaction"    // registers the nats actions
+	_ "github.com/ccamel/kynaptik/pkg/action/smtpaction"    // registers the smtp actions
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/spf13/afero"
+)
+
+// EntryPoint is the entry point for this Fission function.
+func EntryPoint(w http.ResponseWriter, r *http.Request) {
+	kynaptik.InvokeAnyλ(w, r, afero.NewOsFs())
+}
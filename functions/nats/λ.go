@@ -0,0 +1,14 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ccamel/kynaptik/pkg/action/natsaction"
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/spf13/afero"
+)
+
+// EntryPoint is the entry point for this Fission function.
+func EntryPoint(w http.ResponseWriter, r *http.Request) {
+	kynaptik.Invokeλ(w, r, afero.NewOsFs(), natsaction.ConfigFactory, natsaction.ActionFactory)
+}
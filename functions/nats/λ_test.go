@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNATSEntryPoint(t *testing.T) {
+	Convey("When calling 'EntryPoint' function", t, func(c C) {
+		Convey("Then it shall panic (this is expected)", func() {
+			So(func() {
+				EntryPoint(nil, nil)
+			}, ShouldPanic)
+		})
+	})
+}
@@ -0,0 +1,6 @@
+package main
+
+// not used - make the linter happy.
+func main() {
+	EntryPoint(nil, nil)
+}
@@ -4,17 +4,24 @@ go 1.13
 
 require (
 	github.com/Masterminds/sprig/v3 v3.2.2
-	github.com/antonmedv/expr v1.8.9
+	github.com/Shopify/sarama v1.30.0
+	github.com/antonmedv/expr v1.10.5
 	github.com/flimzy/donewriter v0.0.0-20170510162603-1516ff172a4d
 	github.com/gamegos/jsend v0.0.0-20151011171802-f47e169f3d76
 	github.com/go-playground/validator/v10 v10.5.0
 	github.com/goccy/go-yaml v1.8.9
+	github.com/golang-jwt/jwt/v4 v4.2.0
 	github.com/justinas/alice v1.2.0
 	github.com/motemen/go-loghttp v0.0.0-20170804080138-974ac5ceac27
 	github.com/motemen/go-nuts v0.0.0-20210915132349-615a782f2c69
+	github.com/nats-io/nats-server/v2 v2.7.4
+	github.com/nats-io/nats.go v1.14.0
+	github.com/nats-io/nkeys v0.3.0
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
+	github.com/rabbitmq/amqp091-go v1.3.0
 	github.com/rs/zerolog v1.26.1
 	github.com/smartystreets/goconvey v1.7.2
 	github.com/spf13/afero v1.8.1
 	github.com/tcnksm/go-httpstat v0.2.0
+	github.com/xdg-go/scram v1.0.2
 )
@@ -1,5 +1,119 @@
 package util
 
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
 const (
 	HeaderContentType = "Content-Type"
 )
+
+// DefaultMaxResponseBodySize specifies the default maximum number of bytes read from a response body.
+const DefaultMaxResponseBodySize = 1 << 20
+
+// HTTPRequest is the representation of an incoming HTTP request, as exposed in the evaluation environment.
+type HTTPRequest struct {
+	// ID is the id of the request.
+	ID string
+	// Method is the HTTP method, e.g. "POST".
+	Method string
+	// Path is the path of the requested URL, e.g. "/orders".
+	Path string
+	// Query contains the parameters of the query string of the requested URL.
+	Query url.Values
+	// Header contains the headers of the request.
+	Header http.Header
+	// RemoteAddr is the network address of the client, e.g. "10.0.0.1:42345".
+	RemoteAddr string
+}
+
+// NewHTTPRequest returns a new HTTPRequest from the given request, identified by the given id. The body of the request is
+// not considered.
+func NewHTTPRequest(r *http.Request, id string) *HTTPRequest {
+	return &HTTPRequest{
+		ID:         id,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Header:     r.Header.Clone(),
+		RemoteAddr: r.RemoteAddr,
+	}
+}
+
+// HTTPResponse is the representation of an HTTP response, as exposed in the evaluation environment. The fields are
+// exposed under their (lowercase) expr names, e.g. response.json.status, the Go names being still supported.
+type HTTPResponse struct {
+	// StatusCode is the status code of the response, e.g. 200.
+	StatusCode int `expr:"statusCode"`
+	// Status is the status of the response, e.g. "200 OK".
+	Status string `expr:"status"`
+	// Header contains the headers of the response.
+	Header http.Header `expr:"headers"`
+	// Body is the content of the response body, possibly truncated.
+	Body string `expr:"body"`
+	// JSON is the content of the response body decoded, if of JSON media type (and not truncated).
+	JSON interface{} `expr:"json"`
+	// Truncated tells if the body has been truncated, i.e. if it exceeds the maximum size allowed.
+	Truncated bool `expr:"truncated"`
+}
+
+// NewHTTPResponse returns a new HTTPResponse from the given response, reading the body up to the maximum size
+// specified (a maxBodySize of zero meaning the default size, and -1 no limit). The body of the response is closed.
+func NewHTTPResponse(resp *http.Response, maxBodySize int64) (*HTTPResponse, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxResponseBodySize
+	}
+
+	var reader io.Reader = resp.Body
+	if maxBodySize > 0 {
+		reader = io.LimitReader(resp.Body, maxBodySize+1)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &HTTPResponse{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+	}
+
+	if maxBodySize > 0 && int64(len(body)) > maxBodySize {
+		body = body[:maxBodySize]
+		r.Truncated = true
+	}
+
+	r.Body = string(body)
+
+	if !r.Truncated && len(body) > 0 && IsJSONMediaType(resp.Header.Get(HeaderContentType)) {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			r.JSON = v
+		}
+	}
+
+	return r, nil
+}
+
+// IsJSONMediaType tells if the given content type denotes a JSON media type, i.e. application/json or any media type
+// with the +json suffix.
+func IsJSONMediaType(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return t == MediaTypeApplicationJSON || strings.HasSuffix(t, "+json")
+}
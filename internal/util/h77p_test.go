@@ -0,0 +1,147 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/antonmedv/expr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewHTTPResponse(t *testing.T) {
+	Convey("Considering the NewHTTPResponse() function", t, func(c C) {
+		cases := []struct {
+			contentType       string
+			body              string
+			maxBodySize       int64
+			expectedBody      string
+			expectedJSON      interface{}
+			expectedTruncated bool
+		}{
+			{
+				contentType:  MediaTypeTextPlain,
+				body:         "Hello world!",
+				expectedBody: "Hello world!",
+			},
+			{
+				contentType:  "application/json; charset=utf-8",
+				body:         `{"status":"accepted","count":2}`,
+				expectedBody: `{"status":"accepted","count":2}`,
+				expectedJSON: map[string]interface{}{"status": "accepted", "count": 2.0},
+			},
+			{
+				contentType:  "application/problem+json",
+				body:         `["a","b"]`,
+				expectedBody: `["a","b"]`,
+				expectedJSON: []interface{}{"a", "b"},
+			},
+			{
+				contentType:  MediaTypeApplicationJSON,
+				body:         `{malformed}`,
+				expectedBody: `{malformed}`,
+			},
+			{
+				contentType:       MediaTypeApplicationJSON,
+				body:              `{"status":"accepted"}`,
+				maxBodySize:       5,
+				expectedBody:      `{"sta`,
+				expectedTruncated: true,
+			},
+			{
+				contentType:  MediaTypeTextPlain,
+				body:         "Hello world!",
+				maxBodySize:  -1,
+				expectedBody: "Hello world!",
+			},
+		}
+
+		for n, c := range cases {
+			Convey(fmt.Sprintf("When calling function with a response of type %s (case %d)", c.contentType, n), func() {
+				rr := httptest.NewRecorder()
+				rr.Header().Set(HeaderContentType, c.contentType)
+				rr.WriteHeader(http.StatusAccepted)
+				_, _ = rr.WriteString(c.body)
+
+				resp, err := NewHTTPResponse(rr.Result(), c.maxBodySize)
+
+				Convey("Then response shall be the expected one", func() {
+					So(err, ShouldBeNil)
+					So(resp.StatusCode, ShouldEqual, http.StatusAccepted)
+					So(resp.Status, ShouldEqual, "202 Accepted")
+					So(resp.Header.Get(HeaderContentType), ShouldEqual, c.contentType)
+					So(resp.Body, ShouldEqual, c.expectedBody)
+					So(resp.JSON, ShouldResemble, c.expectedJSON)
+					So(resp.Truncated, ShouldEqual, c.expectedTruncated)
+				})
+			})
+		}
+	})
+}
+
+func TestNewHTTPRequest(t *testing.T) {
+	Convey("Considering the NewHTTPRequest() function", t, func(c C) {
+		r := httptest.NewRequest("POST", "/orders?ref=main&tags=a&tags=b", nil)
+		r.Header.Set("X-GitHub-Event", "push")
+
+		Convey("When calling function", func() {
+			request := NewHTTPRequest(r, "bq5f3ae9mf7c2ktm4ut0")
+
+			Convey("Then the request shall be the expected one", func() {
+				So(request.ID, ShouldEqual, "bq5f3ae9mf7c2ktm4ut0")
+				So(request.Method, ShouldEqual, "POST")
+				So(request.Path, ShouldEqual, "/orders")
+				So(request.Query.Get("ref"), ShouldEqual, "main")
+				So(request.Query["tags"], ShouldResemble, []string{"a", "b"})
+				So(request.Header.Get("x-github-event"), ShouldEqual, "push")
+				So(request.RemoteAddr, ShouldEqual, "192.0.2.1:1234")
+			})
+
+			Convey("And the headers shall not be shared with the incoming request", func() {
+				request.Header.Set("X-GitHub-Event", "ping")
+
+				So(r.Header.Get("X-GitHub-Event"), ShouldEqual, "push")
+			})
+		})
+	})
+}
+
+func TestHTTPResponseEnvironment(t *testing.T) {
+	Convey("Considering an HTTPResponse exposed in the evaluation environment", t, func(c C) {
+		rr := httptest.NewRecorder()
+		rr.Header().Set(HeaderContentType, MediaTypeApplicationJSON)
+		rr.WriteHeader(http.StatusAccepted)
+		_, _ = rr.WriteString(`{"status":"accepted"}`)
+
+		resp, err := NewHTTPResponse(rr.Result(), 0)
+		So(err, ShouldBeNil)
+
+		env := map[string]interface{}{"response": resp}
+
+		cases := []string{
+			`response.json.status == "accepted"`,
+			`response.statusCode == 202`,
+			`response.status == "202 Accepted"`,
+			`response.body == '{"status":"accepted"}'`,
+			`response.headers["Content-Type"][0] == "application/json"`,
+			`response.headers.Get("Content-Type") == "application/json"`,
+			`not response.truncated`,
+			`response.StatusCode == 202 and response.JSON.status == "accepted"`,
+		}
+
+		for _, predicate := range cases {
+			Convey(fmt.Sprintf("When evaluating the predicate %s", predicate), func() {
+				program, err := expr.Compile(predicate)
+				So(err, ShouldBeNil)
+
+				result, err := EvaluatePredicateExpression(program, env)
+
+				Convey("Then the predicate shall be satisfied", func() {
+					So(err, ShouldBeNil)
+					So(result, ShouldBeTrue)
+				})
+			})
+		}
+	})
+}
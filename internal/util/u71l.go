@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -48,21 +49,7 @@ func RenderTemplatedString(name, s string, ctx map[string]interface{}) (io.Reade
 // EvaluatePredicateExpression evaluates the given expression according to the context provided.
 // The expression shall gives a boolean value otherwise an error is returned.
 func EvaluatePredicateExpression(predicate *vm.Program, ctx map[string]interface{}) (bool, error) {
-	env := ctx
-	if env == nil {
-		env = map[string]interface{}{}
-	}
-
-	for name, fn := range FuncMaps() {
-		env[name] = fn
-	}
-
-	for name, fn := range sprig.GenericFuncMap() {
-		env[name] = fn
-	}
-
-	out, err := expr.Run(predicate, env)
-
+	out, err := EvaluateExpression(predicate, ctx)
 	if err != nil {
 		return false, err
 	}
@@ -79,6 +66,52 @@ func EvaluatePredicateExpression(predicate *vm.Program, ctx map[string]interface
 	}
 }
 
+// EvaluateIntegerExpression evaluates the given expression according to the context provided.
+// The expression shall gives an integer value (possibly as a float without fractional part, e.g. when coming from a
+// JSON document) otherwise an error is returned.
+func EvaluateIntegerExpression(program *vm.Program, ctx map[string]interface{}) (int, error) {
+	out, err := EvaluateExpression(program, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := out.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), nil
+		}
+	}
+
+	return 0,
+		fmt.Errorf(
+			"incorrect type %T returned when evaluating expression '%s'. Expected '%s'",
+			out, program.Source.Content(),
+			"integer")
+}
+
+// EvaluateExpression evaluates the given expression according to the context provided, whatever the type of the value
+// it gives.
+func EvaluateExpression(program *vm.Program, ctx map[string]interface{}) (interface{}, error) {
+	env := ctx
+	if env == nil {
+		env = map[string]interface{}{}
+	}
+
+	for name, fn := range FuncMaps() {
+		env[name] = fn
+	}
+
+	for name, fn := range sprig.GenericFuncMap() {
+		env[name] = fn
+	}
+
+	return expr.Run(program, env)
+}
+
 // FindFilename search (recursively) for the given filename in the given root folder, returning the empty string
 // if noy found.
 func FindFilename(fs afero.Fs, root, filename string) string {
@@ -108,11 +141,17 @@ func FindFilename(fs afero.Fs, root, filename string) string {
 	return configPath
 }
 
-// OpenResource opens the resource in the given folder and namespace, returning `nil` if not found.
-func OpenResource(fs afero.Fs, resourceFolder, namespace, resourceName string) (io.ReadCloser, error) {
+// FindResource search for the resource in the given folder and namespace, returning its path, or the empty string
+// if not found.
+func FindResource(fs afero.Fs, resourceFolder, namespace, resourceName string) string {
 	root := fmt.Sprintf("/%s/%s", resourceFolder, namespace)
 
-	configPath := FindFilename(fs, root, resourceName)
+	return FindFilename(fs, root, resourceName)
+}
+
+// OpenResource opens the resource in the given folder and namespace, returning `nil` if not found.
+func OpenResource(fs afero.Fs, resourceFolder, namespace, resourceName string) (io.ReadCloser, error) {
+	configPath := FindResource(fs, resourceFolder, namespace, resourceName)
 
 	if configPath == "" {
 		return nil, nil
@@ -196,6 +196,69 @@ func TestEvaluatePredicateExpression(t *testing.T) {
 	})
 }
 
+func TestEvaluateIntegerExpression(t *testing.T) {
+	Convey("Considering EvaluateIntegerExpression() function", t, func(c C) {
+		cases := []struct {
+			expression     string
+			ctx            map[string]interface{}
+			expectedResult int
+			expectedError  error
+		}{
+			{
+				expression:     "201",
+				ctx:            nil,
+				expectedResult: 201,
+			},
+			{
+				expression: "ok ? 200 : 502",
+				ctx: map[string]interface{}{
+					"ok": false,
+				},
+				expectedResult: 502,
+			},
+			{
+				expression: "data.code",
+				ctx: map[string]interface{}{
+					"data": map[string]interface{}{"code": 404.0},
+				},
+				expectedResult: 404,
+			},
+			{
+				expression: "data.code",
+				ctx: map[string]interface{}{
+					"data": map[string]interface{}{"code": 4.2},
+				},
+				expectedError: fmt.Errorf("incorrect type float64 returned when evaluating expression 'data.code'. Expected 'integer'"),
+			},
+			{
+				expression:    "'foo bar'",
+				ctx:           nil,
+				expectedError: fmt.Errorf("incorrect type string returned when evaluating expression ''foo bar''. Expected 'integer'"),
+			},
+		}
+		for n, c := range cases {
+			Convey(fmt.Sprintf("Given the compiled program from expression %s (case %d)", c.expression, n), func() {
+				program, err := expr.Compile(c.expression)
+				So(err, ShouldBeNil)
+
+				Convey("When calling function", func() {
+					result, err := EvaluateIntegerExpression(program, c.ctx)
+
+					Convey(fmt.Sprintf("Then result should be %v", result), func() {
+						if c.expectedError != nil {
+							So(err, ShouldNotBeNil)
+							So(err.Error(), ShouldEqual, c.expectedError.Error())
+						} else {
+							So(err, ShouldBeNil)
+						}
+						So(result, ShouldEqual, c.expectedResult)
+					})
+				})
+			})
+		}
+	})
+}
+
 func TestFindFilename(t *testing.T) {
 	Convey("Considering the FindFilename function", t, func(c C) {
 		cases := []struct {
@@ -245,6 +308,39 @@ func TestFindFilename(t *testing.T) {
 	})
 }
 
+func TestFindResource(t *testing.T) {
+	Convey("Considering a virtual filesystem containing a resource", t, func(c C) {
+		fs := afero.NewMemMapFs()
+
+		err := afero.WriteFile(fs, "/configs/namespace/function/function-spec.yml", []byte("Hello world!"), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		cases := []struct {
+			namespace    string
+			expectedPath string
+		}{
+			{
+				namespace:    "namespace",
+				expectedPath: "/configs/namespace/function/function-spec.yml",
+			},
+			{
+				namespace:    "unknown",
+				expectedPath: "",
+			},
+		}
+
+		for n, c := range cases {
+			Convey(fmt.Sprintf("When calling function with namespace %s (case %d)", c.namespace, n), func() {
+				result := FindResource(fs, "configs", c.namespace, "function-spec.yml")
+
+				Convey(fmt.Sprintf("Then path '%s' shall be returned", c.expectedPath), func() {
+					So(result, ShouldEqual, c.expectedPath)
+				})
+			})
+		}
+	})
+}
+
 func TestOpenResource(t *testing.T) {
 	const (
 		resourceFolder  = "resource"
@@ -0,0 +1,275 @@
+package amqpaction
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultConnectionName specifies the default name the client identifies itself with to the broker.
+	DefaultConnectionName = "kynaptik"
+	// DefaultTimeout specifies the default timeout of the handshake with the broker (if the context has no deadline).
+	DefaultTimeout = 30 * time.Second
+	// DefaultHeartbeat specifies the default interval of the heartbeats negotiated with the broker.
+	DefaultHeartbeat = 10 * time.Second
+	// DefaultLocale specifies the default locale negotiated with the broker.
+	DefaultLocale = "en_US"
+)
+
+type Action struct {
+	URI         string            `yaml:"uri" validate:"required,uri,scheme=amqp|scheme=amqps"`
+	Exchange    string            `yaml:"exchange"`
+	RoutingKey  string            `yaml:"routingKey"`
+	Headers     map[string]string `yaml:"headers"`
+	ContentType string            `yaml:"contentType"`
+	Persistent  bool              `yaml:"persistent"`
+	Body        string            `yaml:"body"`
+	Options     Options           `yaml:"options"`
+}
+
+type Options struct {
+	Mandatory      bool                  `yaml:"mandatory"`
+	Auth           AuthOptions           `yaml:"auth"`
+	TLS            httpaction.TLSOptions `yaml:"tls"`
+	ConnectionName string                `yaml:"connectionName"`
+}
+
+type AuthOptions struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Response is the outcome of the publication of the message, as exposed in the evaluation environment. The fields are
+// exposed under their (lowercase) expr names, e.g. response.ack, the Go names being still supported.
+type Response struct {
+	// Ack tells if the message has been acknowledged by the broker (publisher confirm), i.e. taken responsibility for.
+	Ack bool `expr:"ack"`
+	// DeliveryTag is the sequence number of the message on the channel, as confirmed by the broker.
+	DeliveryTag uint64 `expr:"deliveryTag"`
+	// Returned tells if the message has been returned by the broker as unroutable (mandatory messages only).
+	Returned bool `expr:"returned"`
+	// ReplyCode is the reason the message has been returned, e.g. 312.
+	ReplyCode uint16 `expr:"replyCode"`
+	// ReplyText is the reason the message has been returned, e.g. "NO_ROUTE".
+	ReplyText string `expr:"replyText"`
+}
+
+func init() {
+	kynaptik.RegisterActionKind(kynaptik.ActionKind{
+		Name:          "amqp",
+		Schemes:       []string{"amqp", "amqps"},
+		ConfigFactory: ConfigFactory,
+		ActionFactory: ActionFactory,
+	})
+}
+
+// ConfigFactory returns the default configuration for the action.
+func ConfigFactory() kynaptik.Config {
+	return kynaptik.Config{
+		// PreCondition specifies the default pre-condition value. Here, we accept everything.
+		PreCondition: "true",
+		// PostCondition specifies the default post-condition to satisfy in order to consider the message published.
+		// Here, we consider a message acknowledged by the broker, and not returned, to be successful.
+		PostCondition: "response.ack and not response.returned",
+	}
+}
+
+// ActionFactory returns a new action with default values.
+func ActionFactory() kynaptik.Action {
+	return &Action{
+		Headers: map[string]string{},
+		Options: Options{
+			ConnectionName: DefaultConnectionName,
+		},
+	}
+}
+
+func (a *Action) GetURI() string {
+	return a.URI
+}
+
+func (a *Action) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("uri", a.URI).
+		Str("exchange", a.Exchange).
+		Str("routingKey", a.RoutingKey).
+		Object("headers", util.MapToLogObjectMarshaller(a.Headers)).
+		Str("body", a.Body)
+}
+
+func (a *Action) DoAction(ctx context.Context) (interface{}, error) {
+	uri, err := amqp.ParseURI(a.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	config, err := a.config(ctx, uri, done)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := amqp.DialConfig(a.URI, config)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, err
+	}
+	defer conn.Close()
+
+	response, err := a.publish(ctx, conn)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return response, err
+}
+
+// config returns the configuration of the connection to the broker specified by the given uri. The (network)
+// connection is closed as soon as the given context is done, interrupting the exchange with the broker (the broker
+// being possibly unresponsive), unless the given channel is closed before.
+func (a *Action) config(ctx context.Context, uri amqp.URI, done <-chan struct{}) (amqp.Config, error) {
+	name := a.Options.ConnectionName
+	if name == "" {
+		name = DefaultConnectionName
+	}
+
+	config := amqp.Config{
+		Heartbeat: DefaultHeartbeat,
+		Locale:    DefaultLocale,
+		Properties: amqp.Table{
+			"product":         "kynaptik",
+			"connection_name": name,
+		},
+		Dial: func(network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			// without deadline, the handshake is bounded by a default timeout (the deadline being cleared once the
+			// handshake completed)
+			if _, ok := ctx.Deadline(); !ok {
+				if err := conn.SetDeadline(time.Now().Add(DefaultTimeout)); err != nil {
+					_ = conn.Close()
+					return nil, err
+				}
+			}
+
+			go func() {
+				select {
+				case <-ctx.Done():
+					_ = conn.Close()
+				case <-done:
+				}
+			}()
+
+			return conn, nil
+		},
+	}
+
+	if uri.Scheme == "amqps" {
+		tlsConfig, err := a.Options.TLS.ToTLSConfig()
+		if err != nil {
+			return amqp.Config{}, err
+		}
+
+		config.TLSClientConfig = tlsConfig
+	}
+
+	// the credentials of the options take precedence over the ones of the uri
+	if a.Options.Auth.Username != "" {
+		config.SASL = []amqp.Authentication{
+			&amqp.PlainAuth{Username: a.Options.Auth.Username, Password: a.Options.Auth.Password},
+		}
+	}
+
+	return config, nil
+}
+
+// publish publishes the message through a new channel of the given connection, waiting for its confirmation by the
+// broker.
+func (a *Action) publish(ctx context.Context, conn *amqp.Connection) (*Response, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		return nil, err
+	}
+
+	// the returns are notified before the confirmations (see https://www.rabbitmq.com/confirms.html)
+	closes := channel.NotifyClose(make(chan *amqp.Error, 1))
+	returns := channel.NotifyReturn(make(chan amqp.Return, 1))
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	deliveryMode := amqp.Transient
+	if a.Persistent {
+		deliveryMode = amqp.Persistent
+	}
+
+	headers := amqp.Table{}
+	for k, v := range a.Headers {
+		headers[k] = v
+	}
+
+	publishing := amqp.Publishing{
+		Headers:      headers,
+		ContentType:  a.ContentType,
+		DeliveryMode: deliveryMode,
+		Timestamp:    time.Now(),
+		Body:         []byte(a.Body),
+	}
+
+	if err := channel.Publish(a.Exchange, a.RoutingKey, a.Options.Mandatory, false, publishing); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case confirmation, ok := <-confirms:
+		if !ok {
+			// the channel has been closed by the broker, e.g. the exchange doesn't exist
+			if err, ok := <-closes; ok && err != nil {
+				return nil, err
+			}
+
+			return nil, errors.New("channel closed before the confirmation of the message")
+		}
+
+		response := &Response{Ack: confirmation.Ack, DeliveryTag: confirmation.DeliveryTag}
+
+		select {
+		case r := <-returns:
+			log.Ctx(ctx).
+				Warn().
+				Uint16("code", r.ReplyCode).
+				Str("text", r.ReplyText).
+				Msg("message returned by the AMQP broker")
+
+			response.Returned = true
+			response.ReplyCode = r.ReplyCode
+			response.ReplyText = r.ReplyText
+		default:
+		}
+
+		return response, nil
+	}
+}
@@ -0,0 +1,746 @@
+package amqpaction
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	etcPath  = "../../../etc/"
+	exchange = "events"
+)
+
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+// publication is a message published to the fake AMQP broker.
+type publication struct {
+	vhost        string
+	exchange     string
+	routingKey   string
+	mandatory    bool
+	contentType  string
+	headers      map[string]string
+	deliveryMode uint8
+	body         string
+}
+
+// fakeBroker is a (minimal) in-process AMQP 0-9-1 broker, recording the messages published. The only exchange known
+// is the default one ("") and the "events" exchange, the messages being routed according to the routing keys
+// specified.
+type fakeBroker struct {
+	listener net.Listener
+	// username and password are the credentials expected (PLAIN)
+	username string
+	password string
+	// routes are the routing keys the messages are routed with, the others being unroutable
+	routes []string
+	// nack makes the broker negatively acknowledge the messages
+	nack bool
+
+	mu           sync.Mutex
+	publications []publication
+}
+
+func newFakeBroker(secure bool, username, password string, routes ...string) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	b := &fakeBroker{listener: listener, username: username, password: password, routes: routes}
+
+	if secure {
+		cert, err := tls.LoadX509KeyPair(path.Join(etcPath, "cert/leaf.pem"), path.Join(etcPath, "cert/leaf.key"))
+		So(err, ShouldBeNil)
+
+		b.listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	go func() {
+		for {
+			conn, err := b.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *fakeBroker) port() int {
+	return b.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (b *fakeBroker) close() {
+	_ = b.listener.Close()
+}
+
+func (b *fakeBroker) published() []publication {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.publications
+}
+
+func (b *fakeBroker) routable(routingKey string) bool {
+	for _, route := range b.routes {
+		if route == routingKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// frame is a frame of the AMQP protocol.
+type frame struct {
+	kind    byte
+	channel uint16
+	payload []byte
+}
+
+// method returns the class and the method of the frame, along with the arguments of the method.
+func (f frame) method() (uint16, uint16, *bytes.Reader) {
+	return binary.BigEndian.Uint16(f.payload), binary.BigEndian.Uint16(f.payload[2:]), bytes.NewReader(f.payload[4:])
+}
+
+// conn is a connection of a client to the fake broker.
+type conn struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (c *conn) read() (frame, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{kind: header[0], channel: binary.BigEndian.Uint16(header[1:]), payload: make([]byte, binary.BigEndian.Uint32(header[3:])+1)}
+	if _, err := io.ReadFull(c.r, f.payload); err != nil {
+		return frame{}, err
+	}
+
+	if f.payload[len(f.payload)-1] != frameEnd {
+		return frame{}, errors.New("incorrect frame end")
+	}
+
+	f.payload = f.payload[:len(f.payload)-1]
+
+	return f, nil
+}
+
+// readMethod reads the frames until the next method frame (ignoring the heartbeats).
+func (c *conn) readMethod() (frame, error) {
+	for {
+		f, err := c.read()
+		if err != nil || f.kind != frameHeartbeat {
+			return f, err
+		}
+	}
+}
+
+func (c *conn) write(kind byte, channel uint16, payload []byte) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(kind)
+	_ = binary.Write(buf, binary.BigEndian, channel)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+	buf.WriteByte(frameEnd)
+
+	_, _ = c.w.Write(buf.Bytes())
+}
+
+func (c *conn) writeMethod(channel, class, method uint16, args ...interface{}) {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.BigEndian, class)
+	_ = binary.Write(buf, binary.BigEndian, method)
+
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case shortstr:
+			buf.WriteByte(byte(len(v)))
+			buf.WriteString(string(v))
+		case longstr:
+			_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+			buf.WriteString(string(v))
+		default:
+			_ = binary.Write(buf, binary.BigEndian, v)
+		}
+	}
+
+	c.write(frameMethod, channel, buf.Bytes())
+}
+
+type (
+	shortstr string
+	longstr  string
+)
+
+func readShortstr(r *bytes.Reader) string {
+	n, _ := r.ReadByte()
+	s := make([]byte, n)
+	_, _ = io.ReadFull(r, s)
+
+	return string(s)
+}
+
+func readLongstr(r *bytes.Reader) string {
+	var n uint32
+	_ = binary.Read(r, binary.BigEndian, &n)
+	s := make([]byte, n)
+	_, _ = io.ReadFull(r, s)
+
+	return string(s)
+}
+
+// readTable reads a table, the values of which being expected to be strings.
+func readTable(r *bytes.Reader) map[string]string {
+	table := map[string]string{}
+	t := bytes.NewReader([]byte(readLongstr(r)))
+
+	for t.Len() > 0 {
+		k := readShortstr(t)
+		if kind, _ := t.ReadByte(); kind != 'S' {
+			break
+		}
+
+		table[k] = readLongstr(t)
+	}
+
+	return table
+}
+
+// handshake negotiates the connection with the client, returning the virtual host opened.
+func (b *fakeBroker) handshake(c *conn) (string, error) {
+	protocol := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, protocol); err != nil {
+		return "", err
+	}
+
+	if string(protocol) != "AMQP\x00\x00\x09\x01" {
+		return "", errors.New("unsupported protocol")
+	}
+
+	c.writeMethod(0, 10, 10, uint8(0), uint8(9), longstr(""), longstr("PLAIN"), longstr("en_US"))
+
+	f, err := c.readMethod()
+	if err != nil {
+		return "", err
+	}
+
+	_, _, args := f.method()
+	_ = readLongstr(args) // client properties
+	_ = readShortstr(args)
+
+	if readLongstr(args) != "\x00"+b.username+"\x00"+b.password {
+		c.writeMethod(0, 10, 50, uint16(403), shortstr("ACCESS_REFUSED - Login was refused"), uint16(0), uint16(0))
+
+		return "", errors.New("access refused")
+	}
+
+	c.writeMethod(0, 10, 30, uint16(0), uint32(131072), uint16(0))
+
+	if _, err := c.readMethod(); err != nil { // tune-ok
+		return "", err
+	}
+
+	f, err = c.readMethod()
+	if err != nil {
+		return "", err
+	}
+
+	_, _, args = f.method()
+	vhost := readShortstr(args)
+
+	c.writeMethod(0, 10, 41, shortstr(""))
+
+	return vhost, nil
+}
+
+// readContent reads the content (header and body) of the given publication.
+func (c *conn) readContent(p *publication) error {
+	f, err := c.readMethod()
+	if err != nil {
+		return err
+	}
+
+	if f.kind != frameHeader {
+		return errors.New("content header expected")
+	}
+
+	r := bytes.NewReader(f.payload[4:])
+
+	var (
+		size  uint64
+		flags uint16
+	)
+
+	_ = binary.Read(r, binary.BigEndian, &size)
+	_ = binary.Read(r, binary.BigEndian, &flags)
+
+	if flags&(1<<15) != 0 {
+		p.contentType = readShortstr(r)
+	}
+
+	if flags&(1<<14) != 0 {
+		_ = readShortstr(r) // content encoding
+	}
+
+	if flags&(1<<13) != 0 {
+		p.headers = readTable(r)
+	}
+
+	if flags&(1<<12) != 0 {
+		p.deliveryMode, _ = r.ReadByte()
+	}
+
+	body := new(bytes.Buffer)
+	for uint64(body.Len()) < size {
+		f, err := c.readMethod()
+		if err != nil {
+			return err
+		}
+
+		if f.kind != frameBody {
+			return errors.New("content body expected")
+		}
+
+		body.Write(f.payload)
+	}
+
+	p.body = body.String()
+
+	return nil
+}
+
+func (b *fakeBroker) serve(nc net.Conn) {
+	defer nc.Close()
+
+	c := &conn{r: bufio.NewReader(nc), w: nc}
+
+	vhost, err := b.handshake(c)
+	if err != nil {
+		return
+	}
+
+	var tag uint64
+
+	for {
+		f, err := c.readMethod()
+		if err != nil {
+			return
+		}
+
+		class, method, args := f.method()
+
+		switch {
+		case class == 10 && method == 50: // connection.close
+			c.writeMethod(0, 10, 51)
+
+			return
+		case class == 20 && method == 10: // channel.open
+			c.writeMethod(f.channel, 20, 11, longstr(""))
+		case class == 20 && method == 40: // channel.close
+			c.writeMethod(f.channel, 20, 41)
+		case class == 85 && method == 10: // confirm.select
+			c.writeMethod(f.channel, 85, 11)
+		case class == 60 && method == 40: // basic.publish
+			var reserved uint16
+			_ = binary.Read(args, binary.BigEndian, &reserved)
+
+			p := publication{vhost: vhost, exchange: readShortstr(args), routingKey: readShortstr(args)}
+			bits, _ := args.ReadByte()
+			p.mandatory = bits&1 != 0
+
+			if err := c.readContent(&p); err != nil {
+				return
+			}
+
+			if p.exchange != "" && p.exchange != exchange {
+				c.writeMethod(f.channel, 20, 40,
+					uint16(404), shortstr(fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '%s'", p.exchange, vhost)),
+					uint16(60), uint16(40))
+
+				continue
+			}
+
+			b.mu.Lock()
+			b.publications = append(b.publications, p)
+			b.mu.Unlock()
+
+			tag++
+
+			if p.mandatory && !b.routable(p.routingKey) {
+				c.writeMethod(f.channel, 60, 50, uint16(312), shortstr("NO_ROUTE"), shortstr(p.exchange), shortstr(p.routingKey))
+				c.write(frameHeader, f.channel, append([]byte{0, 60, 0, 0}, make([]byte, 10)...))
+			}
+
+			if b.nack {
+				c.writeMethod(f.channel, 60, 120, tag, uint8(0))
+			} else {
+				c.writeMethod(f.channel, 60, 80, tag, uint8(0))
+			}
+		}
+	}
+}
+
+type amqpFixtureSupplier func() amqpFixture
+
+type amqpFixture struct {
+	ctx        context.Context
+	amqpAction Action
+	// arrange is a function which initializes the fixture and in returns provides a function which finalizes (clean)
+	// that fixture when called
+	arrange func(c C, ctx context.Context) func()
+	// assert is a function performing the assertions on the result
+	assert func(interface{}, error)
+}
+
+// amqpFixtureProvider returns a fixture publishing the given action to the given fake broker, the URI of the action
+// being prefixed by the scheme, the credentials and the address of the broker.
+func amqpFixtureProvider(
+	scheme string,
+	userinfo string,
+	b *fakeBroker,
+	action Action,
+	assert func(b *fakeBroker, res interface{}, err error),
+) amqpFixtureSupplier {
+	return func() amqpFixture {
+		rootPem, _ := ioutil.ReadFile(path.Join(etcPath, "cert/root.pem"))
+
+		action.URI = fmt.Sprintf("%s://%slocalhost:%d/%s", scheme, userinfo, b.port(), action.URI)
+		action.Options.TLS = httpaction.TLSOptions{CACertData: string(rootPem)}
+
+		return amqpFixture{
+			ctx:        context.Background(),
+			amqpAction: action,
+			arrange: func(c C, ctx context.Context) func() {
+				return b.close
+			},
+			assert: func(res interface{}, err error) {
+				assert(b, res, err)
+			},
+		}
+	}
+}
+
+func amqpSuccessfulPublishFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "guest:guest@", newFakeBroker(false, "guest", "guest", "users.created"),
+		Action{
+			Exchange:   exchange,
+			RoutingKey: "users.created",
+			Body:       "hello",
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Ack: true, DeliveryTag: 1})
+
+			So(b.published(), ShouldResemble, []publication{{
+				vhost:        "/",
+				exchange:     exchange,
+				routingKey:   "users.created",
+				deliveryMode: amqp.Transient,
+				body:         "hello",
+			}})
+		})()
+}
+
+func amqpSuccessfulPersistentPublishFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "guest:guest@", newFakeBroker(false, "guest", "guest", "users.created"),
+		Action{
+			URI:         "users",
+			Exchange:    exchange,
+			RoutingKey:  "users.created",
+			Headers:     map[string]string{"X-Event-Type": "user.created"},
+			ContentType: "application/json",
+			Persistent:  true,
+			Body:        `{"id":42}`,
+			Options:     Options{Mandatory: true},
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Ack: true, DeliveryTag: 1})
+
+			So(b.published(), ShouldResemble, []publication{{
+				vhost:        "users",
+				exchange:     exchange,
+				routingKey:   "users.created",
+				mandatory:    true,
+				contentType:  "application/json",
+				headers:      map[string]string{"X-Event-Type": "user.created"},
+				deliveryMode: amqp.Persistent,
+				body:         `{"id":42}`,
+			}})
+		})()
+}
+
+func amqpSuccessfulDefaultExchangeFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "guest:guest@", newFakeBroker(false, "guest", "guest"),
+		Action{
+			RoutingKey: "users",
+			Body:       "hello",
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Ack: true, DeliveryTag: 1})
+
+			published := b.published()
+			So(published, ShouldHaveLength, 1)
+			So(published[0].exchange, ShouldEqual, "")
+			So(published[0].routingKey, ShouldEqual, "users")
+		})()
+}
+
+func amqpNackFixture() amqpFixture {
+	b := newFakeBroker(false, "guest", "guest", "users.created")
+	b.nack = true
+
+	return amqpFixtureProvider("amqp", "guest:guest@", b,
+		Action{
+			Exchange:   exchange,
+			RoutingKey: "users.created",
+			Body:       "hello",
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Ack: false, DeliveryTag: 1})
+		})()
+}
+
+func amqpReturnedFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "guest:guest@", newFakeBroker(false, "guest", "guest", "users.created"),
+		Action{
+			Exchange:   exchange,
+			RoutingKey: "users.deleted",
+			Body:       "hello",
+			Options:    Options{Mandatory: true},
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{
+				Ack:         true,
+				DeliveryTag: 1,
+				Returned:    true,
+				ReplyCode:   312,
+				ReplyText:   "NO_ROUTE",
+			})
+		})()
+}
+
+func amqpUnknownExchangeFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "guest:guest@", newFakeBroker(false, "guest", "guest"),
+		Action{
+			Exchange:   "unknown",
+			RoutingKey: "users.created",
+			Body:       "hello",
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `Exception (404) Reason: "NOT_FOUND - no exchange 'unknown' in vhost '/'"`)
+			So(b.published(), ShouldBeEmpty)
+		})()
+}
+
+func amqpSuccessfulAuthOptionsFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "guest:guest@", newFakeBroker(false, "john", "s3cr3t"),
+		Action{
+			Body:    "hello",
+			Options: Options{Auth: AuthOptions{Username: "john", Password: "s3cr3t"}},
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Ack: true, DeliveryTag: 1})
+			So(b.published(), ShouldHaveLength, 1)
+		})()
+}
+
+func amqpFailedAuthFixture() amqpFixture {
+	return amqpFixtureProvider("amqp", "", newFakeBroker(false, "john", "s3cr3t"),
+		Action{
+			Body:    "hello",
+			Options: Options{Auth: AuthOptions{Username: "john", Password: "incorrect"}},
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldEqual, amqp.ErrCredentials)
+			So(b.published(), ShouldBeEmpty)
+		})()
+}
+
+func amqpSuccessfulTLSFixture() amqpFixture {
+	return amqpFixtureProvider("amqps", "guest:guest@", newFakeBroker(true, "guest", "guest"),
+		Action{
+			Exchange: exchange,
+			Body:     "hello",
+		},
+		func(b *fakeBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Ack: true, DeliveryTag: 1})
+			So(b.published(), ShouldHaveLength, 1)
+		})()
+}
+
+func amqpIncorrectURIFixture() amqpFixture {
+	return amqpFixture{
+		ctx: context.Background(),
+		amqpAction: Action{
+			URI:  "http://localhost",
+			Body: "hello",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			return func() {}
+		},
+		assert: func(res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(strings.HasPrefix(err.Error(), "AMQP scheme must be either"), ShouldBeTrue)
+		},
+	}
+}
+
+func amqpTimeoutFixture() amqpFixture {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+	return amqpFixture{
+		ctx: ctx,
+		amqpAction: Action{
+			URI:  fmt.Sprintf("amqp://127.0.0.1:%d", listener.Addr().(*net.TCPAddr).Port),
+			Body: "hello",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			// the broker accepts the connection, but never starts the handshake
+			go func() {
+				conn, err := listener.Accept()
+				if err == nil {
+					<-ctx.Done()
+					_ = conn.Close()
+				}
+			}()
+
+			return func() {
+				cancel()
+				_ = listener.Close()
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+		},
+	}
+}
+
+func TestAMQPFunction(t *testing.T) {
+	Convey("Considering the AMQP function", t, func(c C) {
+		fixtures := []amqpFixtureSupplier{
+			amqpSuccessfulPublishFixture,
+			amqpSuccessfulPersistentPublishFixture,
+			amqpSuccessfulDefaultExchangeFixture,
+			amqpNackFixture,
+			amqpReturnedFixture,
+			amqpUnknownExchangeFixture,
+			amqpSuccessfulAuthOptionsFixture,
+			amqpFailedAuthFixture,
+			amqpSuccessfulTLSFixture,
+			amqpIncorrectURIFixture,
+			amqpTimeoutFixture,
+		}
+
+		for _, fixtureSupplier := range fixtures {
+			Convey(fmt.Sprintf("Given the fixture supplier '%s'", runtime.FuncForPC(reflect.ValueOf(fixtureSupplier).Pointer()).Name()), func() {
+				l := log.With().Logger()
+
+				fixture := fixtureSupplier()
+				ctx := l.WithContext(fixture.ctx)
+				teardown := fixture.arrange(c, ctx)
+				defer teardown()
+
+				Convey("When calling the function", func() {
+					res, err := fixture.amqpAction.DoAction(ctx)
+
+					Convey("Then post-conditions shall be satisfied", func() {
+						fixture.assert(res, err)
+					})
+				})
+			})
+		}
+	})
+}
+
+func TestAMQPActionFactory(t *testing.T) {
+	Convey("When calling 'ActionFactory' function", t, func(c C) {
+		action := ActionFactory()
+
+		Convey("Then action created is an Action with default values", func() {
+			So(action, ShouldHaveSameTypeAs, &Action{})
+			So(action.GetURI(), ShouldEqual, "")
+			So(action.(*Action).Headers, ShouldResemble, map[string]string{})
+			So(action.(*Action).Persistent, ShouldBeFalse)
+			So(action.(*Action).Options.ConnectionName, ShouldEqual, DefaultConnectionName)
+		})
+
+		Convey("And created action can be marshalled into a log without error", func() {
+			log.
+				Info().
+				Object("action", action).
+				Msg("action built")
+		})
+	})
+}
+
+func TestAMQPConfigFactory(t *testing.T) {
+	Convey("When calling 'ConfigFactory' function", t, func(c C) {
+		config := ConfigFactory()
+
+		Convey("Then configuration provided shall be the expected one", func() {
+			So(config.PreCondition, ShouldEqual, "true")
+			So(config.PostCondition, ShouldEqual, "response.ack and not response.returned")
+		})
+
+		Convey("Then the postConditions shall be evaluated against the response, by its lowercase and Go field names", func() {
+			for _, postCondition := range []string{config.PostCondition, `response.Ack and not response.Returned and response.deliveryTag == 1`} {
+				program, err := expr.Compile(postCondition)
+				So(err, ShouldBeNil)
+
+				satisfied, err := util.EvaluatePredicateExpression(program, map[string]interface{}{
+					"response": &Response{Ack: true, DeliveryTag: 1},
+				})
+				So(err, ShouldBeNil)
+				So(satisfied, ShouldBeTrue)
+			}
+		})
+	})
+}
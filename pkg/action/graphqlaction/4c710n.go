@@ -0,0 +1,233 @@
+package graphqlaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/motemen/go-loghttp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/tcnksm/go-httpstat"
+)
+
+type Action struct {
+	URI           string                 `yaml:"uri" validate:"required,uri,scheme=graphql|scheme=graphqls"`
+	Headers       map[string]string      `yaml:"headers"`
+	Query         string                 `yaml:"query" validate:"min=2"`
+	Variables     map[string]interface{} `yaml:"variables"`
+	OperationName string                 `yaml:"operationName"`
+	Options       Options                `yaml:"options"`
+}
+
+type Options struct {
+	Response ResponseOptions `yaml:"response"`
+}
+
+type ResponseOptions struct {
+	MaxBodySize int64 `yaml:"maxBodySize" validate:"gte=-1"`
+}
+
+// Response is the representation of a GraphQL response, as exposed in the evaluation environment. On top of the
+// underlying HTTP response, it provides the decoded GraphQL envelope, e.g. response.data and response.errors.
+type Response struct {
+	util.HTTPResponse
+	// Data is the "data" entry of the GraphQL response (nil if absent or null).
+	Data interface{} `expr:"data"`
+	// Errors is the "errors" entry of the GraphQL response (empty if absent).
+	Errors []Error `expr:"errors"`
+}
+
+// Error is an error reported by a GraphQL server in the "errors" entry of the response.
+type Error struct {
+	Message    string                 `json:"message" expr:"message"`
+	Locations  []Location             `json:"locations" expr:"locations"`
+	Path       []interface{}          `json:"path" expr:"path"`
+	Extensions map[string]interface{} `json:"extensions" expr:"extensions"`
+}
+
+// Location is the location in the query an error refers to.
+type Location struct {
+	Line   int `json:"line" expr:"line"`
+	Column int `json:"column" expr:"column"`
+}
+
+// PathString returns the path of the error in a dot notation, e.g. "createUser.friends.1".
+func (e Error) PathString() string {
+	elems := make([]string, 0, len(e.Path))
+	for _, p := range e.Path {
+		elems = append(elems, fmt.Sprint(p))
+	}
+
+	return strings.Join(elems, ".")
+}
+
+func init() {
+	kynaptik.RegisterActionKind(kynaptik.ActionKind{
+		Name:          "graphql",
+		Schemes:       []string{"graphql", "graphqls"},
+		ConfigFactory: ConfigFactory,
+		ActionFactory: ActionFactory,
+	})
+}
+
+// ConfigFactory returns the default configuration for the action.
+func ConfigFactory() kynaptik.Config {
+	return kynaptik.Config{
+		// PreCondition specifies the default pre-condition value. Here, we accept everything.
+		PreCondition: "true",
+		// PostCondition specifies the default post-condition to satisfy in order to consider the GraphQL call
+		// successful. Here, we consider a status code 200 with no GraphQL errors to be successful, a truncated response
+		// (whose errors can't be decoded) being considered a failure.
+		PostCondition: "response.statusCode == 200 and not response.truncated and len(response.errors) == 0",
+	}
+}
+
+// ActionFactory returns a new action with default values.
+func ActionFactory() kynaptik.Action {
+	return &Action{
+		Headers:   map[string]string{},
+		Variables: map[string]interface{}{},
+	}
+}
+
+func (a Action) GetURI() string {
+	return a.URI
+}
+
+func (a Action) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("uri", a.URI).
+		Object("headers", util.MapToLogObjectMarshaller(a.Headers)).
+		Str("query", a.Query).
+		Dict("variables", zerolog.Dict().Fields(a.Variables))
+}
+
+func (a Action) DoAction(ctx context.Context) (interface{}, error) {
+	uri := strings.Replace(a.URI, "graphql", "http", 1)
+	payload := struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName *string                `json:"operationName"`
+	}{
+		Query: a.Query,
+	}
+
+	if len(a.Variables) > 0 {
+		payload.Variables = a.Variables
+	}
+
+	if a.OperationName != "" {
+		operationName := a.OperationName
+		payload.OperationName = &operationName
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range a.Headers {
+		request.Header.Set(k, v)
+	}
+
+	request.Header.Set(util.HeaderContentType, util.MediaTypeApplicationJSON)
+
+	var result httpstat.Result
+
+	defer func() {
+		result.End(time.Now())
+	}()
+
+	reqCtx := httpstat.WithHTTPStat(ctx, &result)
+	request = request.WithContext(reqCtx)
+
+	client := http.Client{
+		Transport: &loghttp.Transport{
+			LogRequest:  util.HTTPRequestLogger(),
+			LogResponse: util.HTTPResponseLogger(&result), //nolint:bodyclose // no need for closing response body here
+		},
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResponse, err := util.NewHTTPResponse(response, a.Options.Response.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResponse(ctx, httpResponse), nil
+}
+
+// newResponse decodes the GraphQL envelope from the given HTTP response. A body which cannot be decoded (e.g.
+// truncated) leaves Data and Errors empty, the HTTP response being still available to the post-condition.
+func newResponse(ctx context.Context, httpResponse *util.HTTPResponse) *Response {
+	r := &Response{
+		HTTPResponse: *httpResponse,
+		Errors:       []Error{},
+	}
+
+	if r.Truncated {
+		log.Ctx(ctx).
+			Warn().
+			Msg("GraphQL response truncated, the envelope can't be decoded")
+
+		return r
+	}
+
+	if len(r.Body) == 0 {
+		return r
+	}
+
+	var envelope struct {
+		Data   interface{} `json:"data"`
+		Errors []Error     `json:"errors"`
+	}
+
+	if err := json.Unmarshal([]byte(r.Body), &envelope); err != nil {
+		log.Ctx(ctx).
+			Debug().
+			Err(err).
+			Msg("response is not a GraphQL envelope")
+
+		return r
+	}
+
+	r.Data = envelope.Data
+	if envelope.Errors != nil {
+		r.Errors = envelope.Errors
+	}
+
+	if len(r.Errors) > 0 {
+		paths := zerolog.Arr()
+		for _, e := range r.Errors {
+			paths.Str(e.PathString())
+		}
+
+		event := log.Ctx(ctx).Warn()
+		if r.Data != nil {
+			event = event.Bool("partial", true)
+		}
+
+		event.
+			Int("errors", len(r.Errors)).
+			Array("paths", paths).
+			Msg("GraphQL response contains errors")
+	}
+
+	return r
+}
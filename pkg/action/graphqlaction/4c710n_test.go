@@ -0,0 +1,337 @@
+package graphqlaction
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/phayes/freeport"
+	"github.com/rs/zerolog/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type graphqlFixtureSupplier func() graphqlFixture
+
+type graphqlFixture struct {
+	ctx           context.Context
+	graphqlAction Action
+	// arrange is a function which initializes the fixture and in returns provides a function which finalizes (clean)
+	// that fixture when called
+	arrange func(c C, ctx context.Context) func()
+	// assert is a function performing the assertions on the result
+	assert func(interface{}, error)
+}
+
+func graphqlSuccessfulPostWithNoVariablesFixture() graphqlFixture {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	return graphqlFixture{
+		ctx: context.Background(),
+		graphqlAction: Action{
+			URI:     fmt.Sprintf("graphql://127.0.0.1:%d/graphql", port),
+			Headers: map[string]string{},
+			Query:   "{foo}",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			So(err, ShouldBeNil)
+
+			go func() {
+				err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					c.So(r.URL.String(), ShouldEqual, "/graphql")
+					c.So(r.Method, ShouldEqual, "POST")
+					c.So(r.Header, ShouldContainKey, util.HeaderContentType)
+					c.So(r.Header.Get(util.HeaderContentType), ShouldEqual, util.MediaTypeApplicationJSON)
+
+					payload, err := ioutil.ReadAll(r.Body)
+					c.So(err, ShouldBeNil)
+
+					c.So(string(payload), ShouldEqual, `{"query":"{foo}","variables":null,"operationName":null}`)
+
+					time.Sleep(time.Duration(rand.Intn(100-5)+5) * time.Millisecond)
+					_, _ = io.WriteString(w, "ok")
+				}))
+				if err != nil {
+					c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+				}
+			}()
+			return func() {
+				err := listener.Close()
+				So(err, ShouldBeNil)
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &Response{})
+
+			So(res.(*Response).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*Response).Body, ShouldEqual, `ok`)
+			So(res.(*Response).Data, ShouldBeNil)
+			So(res.(*Response).Errors, ShouldBeEmpty)
+		},
+	}
+}
+
+func graphqlSuccessfulPostWithHeadersAndVariablesInvocationFixture() graphqlFixture {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	return graphqlFixture{
+		ctx: context.Background(),
+		graphqlAction: Action{
+			URI: fmt.Sprintf("graphql://127.0.0.1:%d/graphql", port),
+			Headers: map[string]string{
+				"X-Userid": "Rmlyc3Qgb3B0aW9u=",
+			},
+			Query: "query foo($x: String) { bar }",
+			Variables: map[string]interface{}{
+				"a": map[string]interface{}{
+					"v": 0,
+				},
+				"b": map[string]interface{}{
+					"v": 1,
+				},
+			},
+			OperationName: "foo",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			So(err, ShouldBeNil)
+
+			go func() {
+				err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					c.So(r.URL.String(), ShouldEqual, "/graphql")
+					c.So(r.Method, ShouldEqual, "POST")
+					c.So(r.Header, ShouldContainKey, util.HeaderContentType)
+					c.So(r.Header.Get(util.HeaderContentType), ShouldEqual, util.MediaTypeApplicationJSON)
+					c.So(r.Header.Get("X-Userid"), ShouldEqual, "Rmlyc3Qgb3B0aW9u=")
+
+					payload, err := ioutil.ReadAll(r.Body)
+					c.So(err, ShouldBeNil)
+
+					c.So(string(payload), ShouldEqual, `{"query":"query foo($x: String) { bar }","variables":{"a":{"v":0},"b":{"v":1}},"operationName":"foo"}`)
+
+					time.Sleep(time.Duration(rand.Intn(100-5)+5) * time.Millisecond)
+					_, _ = io.WriteString(w, "ok")
+				}))
+				if err != nil {
+					c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+				}
+			}()
+			return func() {
+				err := listener.Close()
+				So(err, ShouldBeNil)
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &Response{})
+
+			So(res.(*Response).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*Response).Body, ShouldEqual, `ok`)
+			So(res.(*Response).Data, ShouldBeNil)
+			So(res.(*Response).Errors, ShouldBeEmpty)
+		},
+	}
+}
+
+func graphqlEnvelopeFixture(body string, assert func(interface{}, error)) graphqlFixtureSupplier {
+	return func() graphqlFixture {
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		return graphqlFixture{
+			ctx: context.Background(),
+			graphqlAction: Action{
+				URI:     fmt.Sprintf("graphql://127.0.0.1:%d/graphql", port),
+				Headers: map[string]string{},
+				Query:   "mutation { createUser(name: \"john\") { id friends { name } } }",
+			},
+			arrange: func(c C, ctx context.Context) func() {
+				listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+				So(err, ShouldBeNil)
+
+				go func() {
+					err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set(util.HeaderContentType, util.MediaTypeApplicationJSON)
+						_, _ = io.WriteString(w, body)
+					}))
+					if err != nil {
+						c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+					}
+				}()
+				return func() {
+					err := listener.Close()
+					So(err, ShouldBeNil)
+				}
+			},
+			assert: assert,
+		}
+	}
+}
+
+func graphqlSuccessfulPostWithDataFixture() graphqlFixture {
+	return graphqlEnvelopeFixture(
+		`{"data":{"createUser":{"id":"42","friends":[]}}}`,
+		func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &Response{})
+
+			So(res.(*Response).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*Response).Data, ShouldResemble, map[string]interface{}{
+				"createUser": map[string]interface{}{
+					"id":      "42",
+					"friends": []interface{}{},
+				},
+			})
+			So(res.(*Response).Errors, ShouldBeEmpty)
+			So(evaluateDefaultPostCondition(res), ShouldBeTrue)
+		})()
+}
+
+func graphqlFailedPostWithErrorsFixture() graphqlFixture {
+	return graphqlEnvelopeFixture(
+		`{"data":null,"errors":[{"message":"name already taken","locations":[{"line":1,"column":12}],"path":["createUser"],"extensions":{"code":"CONFLICT"}}]}`,
+		func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &Response{})
+
+			So(res.(*Response).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*Response).Data, ShouldBeNil)
+			So(res.(*Response).Errors, ShouldResemble, []Error{
+				{
+					Message:    "name already taken",
+					Locations:  []Location{{Line: 1, Column: 12}},
+					Path:       []interface{}{"createUser"},
+					Extensions: map[string]interface{}{"code": "CONFLICT"},
+				},
+			})
+			So(evaluateDefaultPostCondition(res), ShouldBeFalse)
+		})()
+}
+
+func graphqlPartialDataPostFixture() graphqlFixture {
+	return graphqlEnvelopeFixture(
+		`{"data":{"createUser":{"id":"42","friends":[{"name":"bob"},null]}},"errors":[{"message":"friend not found","path":["createUser","friends",1,"name"]}]}`,
+		func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &Response{})
+
+			So(res.(*Response).Data, ShouldNotBeNil)
+			So(res.(*Response).Errors, ShouldHaveLength, 1)
+			So(res.(*Response).Errors[0].PathString(), ShouldEqual, "createUser.friends.1.name")
+			So(evaluateDefaultPostCondition(res), ShouldBeFalse)
+			So(evaluate(`response.data.createUser.id == "42"`, res), ShouldBeTrue)
+			So(evaluate(`response.errors[0].message == "friend not found"`, res), ShouldBeTrue)
+			So(evaluate(`response.errors[0].path[2] == 1`, res), ShouldBeTrue)
+		})()
+}
+
+func graphqlTruncatedPostFixture() graphqlFixture {
+	f := graphqlEnvelopeFixture(
+		`{"data":null,"errors":[{"message":"name already taken","path":["createUser"]}]}`,
+		func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &Response{})
+
+			So(res.(*Response).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*Response).Truncated, ShouldBeTrue)
+			So(res.(*Response).Data, ShouldBeNil)
+			So(res.(*Response).Errors, ShouldBeEmpty)
+			So(evaluateDefaultPostCondition(res), ShouldBeFalse)
+		})()
+
+	f.graphqlAction.Options.Response.MaxBodySize = 16
+
+	return f
+}
+
+func evaluateDefaultPostCondition(res interface{}) bool {
+	return evaluate(ConfigFactory().PostCondition, res)
+}
+
+// evaluate evaluates the given predicate against the given response.
+func evaluate(predicate string, res interface{}) bool {
+	program, err := expr.Compile(predicate)
+	So(err, ShouldBeNil)
+
+	result, err := expr.Run(program, map[string]interface{}{"response": res})
+	So(err, ShouldBeNil)
+
+	return result.(bool)
+}
+
+func TestGraphqlFunction(t *testing.T) {
+	Convey("Considering the GraphQL function", t, func(c C) {
+		fixtures := []graphqlFixtureSupplier{
+			graphqlSuccessfulPostWithNoVariablesFixture,
+			graphqlSuccessfulPostWithHeadersAndVariablesInvocationFixture,
+			graphqlSuccessfulPostWithDataFixture,
+			graphqlFailedPostWithErrorsFixture,
+			graphqlPartialDataPostFixture,
+			graphqlTruncatedPostFixture,
+		}
+
+		for _, fixtureSupplier := range fixtures {
+			Convey(fmt.Sprintf("Given the fixture supplier '%s'", runtime.FuncForPC(reflect.ValueOf(fixtureSupplier).Pointer()).Name()), func() {
+				l := log.With().Logger()
+
+				fixture := fixtureSupplier()
+				ctx := l.WithContext(fixture.ctx)
+				teardown := fixture.arrange(c, ctx)
+				defer teardown()
+
+				Convey("When calling the function", func() {
+					res, err := fixture.graphqlAction.DoAction(ctx)
+
+					Convey("Then post-conditions shall be satisfied", func() {
+						fixture.assert(res, err)
+					})
+				})
+			})
+		}
+	})
+}
+
+func TestGraphqlActionFactory(t *testing.T) {
+	Convey("When calling ActionFactory", t, func(c C) {
+		action := ActionFactory()
+
+		Convey("Then action created is an Action with default values", func() {
+
+			So(action, ShouldHaveSameTypeAs, &Action{})
+			So(action.(*Action).URI, ShouldEqual, "")
+			So(action.(*Action).GetURI(), ShouldEqual, "")
+			So(action.(*Action).Headers, ShouldResemble, map[string]string{})
+			So(action.(*Action).Variables, ShouldResemble, map[string]interface{}{})
+		})
+
+		Convey("And created action can be marshalled into a log without error", func() {
+			log.
+				Info().
+				Object("action", action).
+				Msg("action built")
+		})
+	})
+}
+
+func TestGraphQLConfigFactory(t *testing.T) {
+	Convey("When calling 'ConfigFactory' function", t, func(c C) {
+		factory := ConfigFactory()
+		Convey("Then configuration provided shall be the expected one", func() {
+			So(factory.PreCondition, ShouldEqual, "true")
+			So(factory.PostCondition, ShouldEqual, "response.statusCode == 200 and not response.truncated and len(response.errors) == 0")
+		})
+	})
+}
@@ -0,0 +1,501 @@
+package httpaction
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/phayes/freeport"
+	"github.com/rs/zerolog/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type httpFixtureSupplier func() httpFixture
+
+type httpFixture struct {
+	ctx        context.Context
+	httpAction Action
+	// arrange is a function which initializes the fixture and in returns provides a function which finalizes (clean)
+	// that fixture when called
+	arrange func(c C, ctx context.Context) func()
+	// assert is a function performing the assertions on the result
+	assert func(interface{}, error)
+}
+
+func httpSuccessfulPostWithHeadersInvocationFixture() httpFixture {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	return httpFixture{
+		ctx: context.Background(),
+		httpAction: Action{
+			URI:    fmt.Sprintf("http://127.0.0.1:%d", port),
+			Method: "POST",
+			Headers: map[string]string{
+				util.HeaderContentType: util.MediaTypeTextPlain,
+				"X-Userid":             "Rmlyc3Qgb3B0aW9u=",
+			},
+			Body: "Hello John Doe!",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			So(err, ShouldBeNil)
+
+			go func() {
+				err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					c.So(r.URL.String(), ShouldEqual, "/")
+					c.So(r.Method, ShouldEqual, "POST")
+					c.So(r.Header, ShouldContainKey, util.HeaderContentType)
+					c.So(r.Header.Get(util.HeaderContentType), ShouldEqual, util.MediaTypeTextPlain)
+					c.So(r.Header.Get("X-Userid"), ShouldEqual, "Rmlyc3Qgb3B0aW9u=")
+
+					payload, err := ioutil.ReadAll(r.Body)
+					c.So(err, ShouldBeNil)
+
+					c.So(string(payload), ShouldEqual, "Hello John Doe!")
+
+					time.Sleep(time.Duration(rand.Intn(100-5)+5) * time.Millisecond)
+					_, _ = io.WriteString(w, "ok")
+				}))
+				if err != nil {
+					c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+				}
+			}()
+			return func() {
+				err := listener.Close()
+				So(err, ShouldBeNil)
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &util.HTTPResponse{})
+
+			So(res.(*util.HTTPResponse).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*util.HTTPResponse).Body, ShouldEqual, `ok`)
+		},
+	}
+}
+
+func httpSuccessfulGetWithTLSInvocationFixture() httpFixture {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	etcPath := "../../../etc/"
+	rootPem, _ := ioutil.ReadFile(path.Join(etcPath, "cert/root.pem"))
+
+	return httpFixture{
+		ctx: context.Background(),
+		httpAction: Action{
+			URI:    fmt.Sprintf("https://localhost:%d/foo", port),
+			Method: "GET",
+			Options: Options{
+				TLS: TLSOptions{
+					CACertData:         string(rootPem),
+					InsecureSkipVerify: false,
+				},
+			},
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			So(err, ShouldBeNil)
+
+			go func() {
+				certFile := path.Join(etcPath, "cert/leaf.pem")
+				keyFile := path.Join(etcPath, "cert/leaf.key")
+				err := http.ServeTLS(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					c.So(r.URL.String(), ShouldEqual, "/foo")
+					c.So(r.Method, ShouldEqual, "GET")
+
+					time.Sleep(time.Duration(rand.Intn(100-5)+5) * time.Millisecond)
+					_, _ = io.WriteString(w, "ok")
+				}), certFile, keyFile)
+				if err != nil {
+					c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+				}
+			}()
+			return func() {
+				err := listener.Close()
+				So(err, ShouldBeNil)
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &util.HTTPResponse{})
+
+			So(res.(*util.HTTPResponse).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*util.HTTPResponse).Body, ShouldEqual, `ok`)
+		},
+	}
+}
+
+func httpSuccessfulGetWithRedirectInvocationFixture() httpFixture {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	return httpFixture{
+		ctx: context.Background(),
+		httpAction: Action{
+			URI:    fmt.Sprintf("http://127.0.0.1:%d", port),
+			Method: "GET",
+			Options: Options{
+				Transport: TransportOptions{
+					FollowRedirect: true,
+					MaxRedirects:   5,
+				},
+			},
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			So(err, ShouldBeNil)
+
+			go func() {
+				count := 0
+				err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					count++
+					if count < 5 {
+						http.Redirect(w, r, fmt.Sprintf("http://localhost:%d/?q=%d", port, count), http.StatusMovedPermanently)
+					} else {
+						_, _ = io.WriteString(w, "ok")
+					}
+				}))
+				if err != nil {
+					c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+				}
+			}()
+			return func() {
+				err := listener.Close()
+				So(err, ShouldBeNil)
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveSameTypeAs, &util.HTTPResponse{})
+
+			So(res.(*util.HTTPResponse).StatusCode, ShouldEqual, http.StatusOK)
+			So(res.(*util.HTTPResponse).Body, ShouldEqual, `ok`)
+		},
+	}
+}
+
+func httpFailedGetWithRedirectInvocationFixtureProvider(options Options, errMessage string) func() httpFixture {
+	return func() httpFixture {
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		return httpFixture{
+			ctx: context.Background(),
+			httpAction: Action{
+				URI:     fmt.Sprintf("http://127.0.0.1:%d", port),
+				Method:  "GET",
+				Options: options,
+			},
+			arrange: func(c C, ctx context.Context) func() {
+				listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+				So(err, ShouldBeNil)
+
+				go func() {
+					count := 0
+					err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						http.Redirect(w, r, fmt.Sprintf("http://localhost:%d/?q=%d", port, count), http.StatusMovedPermanently)
+					}))
+					if err != nil {
+						c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+					}
+				}()
+				return func() {
+					err := listener.Close()
+					So(err, ShouldBeNil)
+				}
+			},
+			assert: func(res interface{}, err error) {
+				So(err, ShouldNotBeNil)
+				So(res.(*util.HTTPResponse).StatusCode, ShouldEqual, http.StatusMovedPermanently)
+				So(err.Error(), func(actual interface{}, expected ...interface{}) string {
+					var tpl bytes.Buffer
+					err := template.
+						Must(template.New("error-msg").Parse(expected[0].(string))).
+						Execute(&tpl, expected[1])
+					if err != nil {
+						return err.Error()
+					}
+					So(actual.(string), ShouldEndWith, tpl.String())
+					return ""
+				}, errMessage, map[string]interface{}{
+					"port": port,
+				})
+			},
+		}
+	}
+}
+
+func httpSuccessfulGetWithJSONResponseInvocationFixtureProvider(options Options, assertResponse func(*util.HTTPResponse)) func() httpFixture {
+	return func() httpFixture {
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		return httpFixture{
+			ctx: context.Background(),
+			httpAction: Action{
+				URI:     fmt.Sprintf("http://127.0.0.1:%d", port),
+				Method:  "GET",
+				Options: options,
+			},
+			arrange: func(c C, ctx context.Context) func() {
+				listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+				So(err, ShouldBeNil)
+
+				go func() {
+					err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set(util.HeaderContentType, util.MediaTypeApplicationJSON)
+						w.WriteHeader(http.StatusAccepted)
+						_, _ = io.WriteString(w, `{"status":"accepted"}`)
+					}))
+					if err != nil {
+						c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+					}
+				}()
+				return func() {
+					err := listener.Close()
+					So(err, ShouldBeNil)
+				}
+			},
+			assert: func(res interface{}, err error) {
+				So(err, ShouldBeNil)
+				So(res, ShouldHaveSameTypeAs, &util.HTTPResponse{})
+
+				response := res.(*util.HTTPResponse)
+				So(response.StatusCode, ShouldEqual, http.StatusAccepted)
+				So(response.Header.Get(util.HeaderContentType), ShouldEqual, util.MediaTypeApplicationJSON)
+
+				assertResponse(response)
+			},
+		}
+	}
+}
+
+func httpSuccessfulPostWithCloudEventInvocationFixtureProvider(
+	options CloudEventOptions,
+	assertRequest func(c C, r *http.Request, payload string),
+) func() httpFixture {
+	return func() httpFixture {
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		return httpFixture{
+			ctx: context.Background(),
+			httpAction: Action{
+				URI:    fmt.Sprintf("http://127.0.0.1:%d", port),
+				Method: "POST",
+				Headers: map[string]string{
+					util.HeaderContentType: util.MediaTypeApplicationJSON,
+				},
+				Body:    `{"id":42}`,
+				Options: Options{CloudEvent: options},
+			},
+			arrange: func(c C, ctx context.Context) func() {
+				listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+				So(err, ShouldBeNil)
+
+				go func() {
+					err := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						payload, err := ioutil.ReadAll(r.Body)
+						c.So(err, ShouldBeNil)
+
+						assertRequest(c, r, string(payload))
+
+						_, _ = io.WriteString(w, "ok")
+					}))
+					if err != nil {
+						c.So(err.Error(), ShouldContainSubstring, "use of closed network connection")
+					}
+				}()
+				return func() {
+					err := listener.Close()
+					So(err, ShouldBeNil)
+				}
+			},
+			assert: func(res interface{}, err error) {
+				So(err, ShouldBeNil)
+				So(res.(*util.HTTPResponse).StatusCode, ShouldEqual, http.StatusOK)
+			},
+		}
+	}
+}
+
+func TestHttpFunction(t *testing.T) {
+	Convey("Considering the Http function", t, func(c C) {
+		fixtures := []httpFixtureSupplier{
+			httpSuccessfulPostWithHeadersInvocationFixture,
+			httpSuccessfulGetWithTLSInvocationFixture,
+			httpSuccessfulGetWithRedirectInvocationFixture,
+			httpFailedGetWithRedirectInvocationFixtureProvider(
+				Options{Transport: TransportOptions{FollowRedirect: false, MaxRedirects: 5}},
+				": no redirect allowed for http://localhost:{{ .port }}/?q=0"),
+			httpFailedGetWithRedirectInvocationFixtureProvider(
+				Options{Transport: TransportOptions{FollowRedirect: true, MaxRedirects: 5}},
+				": stopped after 5 redirects"),
+			httpSuccessfulGetWithJSONResponseInvocationFixtureProvider(
+				Options{},
+				func(response *util.HTTPResponse) {
+					So(response.Body, ShouldEqual, `{"status":"accepted"}`)
+					So(response.JSON, ShouldResemble, map[string]interface{}{"status": "accepted"})
+					So(response.Truncated, ShouldBeFalse)
+
+					program, err := expr.Compile(`response.statusCode == 202 and response.json.status == "accepted"`)
+					So(err, ShouldBeNil)
+
+					satisfied, err := util.EvaluatePredicateExpression(program, map[string]interface{}{"response": response})
+					So(err, ShouldBeNil)
+					So(satisfied, ShouldBeTrue)
+				}),
+			httpSuccessfulGetWithJSONResponseInvocationFixtureProvider(
+				Options{Response: ResponseOptions{MaxBodySize: 5}},
+				func(response *util.HTTPResponse) {
+					So(response.Body, ShouldEqual, `{"sta`)
+					So(response.JSON, ShouldBeNil)
+					So(response.Truncated, ShouldBeTrue)
+				}),
+			httpSuccessfulPostWithCloudEventInvocationFixtureProvider(
+				CloudEventOptions{
+					ID:         "A234-1234-1234",
+					Type:       "com.example.order.created",
+					Source:     "/orders",
+					Subject:    "order 42",
+					Extensions: map[string]string{"TraceParent": "00-1"},
+				},
+				func(c C, r *http.Request, payload string) {
+					c.So(r.Header.Get("Ce-Specversion"), ShouldEqual, "1.0")
+					c.So(r.Header.Get("Ce-Id"), ShouldEqual, "A234-1234-1234")
+					c.So(r.Header.Get("Ce-Type"), ShouldEqual, "com.example.order.created")
+					c.So(r.Header.Get("Ce-Source"), ShouldEqual, "/orders")
+					c.So(r.Header.Get("Ce-Subject"), ShouldEqual, "order%2042")
+					c.So(r.Header.Get("Ce-Traceparent"), ShouldEqual, "00-1")
+					c.So(r.Header.Get("Ce-Time"), ShouldNotBeEmpty)
+					c.So(r.Header.Get(util.HeaderContentType), ShouldEqual, util.MediaTypeApplicationJSON)
+					c.So(payload, ShouldEqual, `{"id":42}`)
+				}),
+			httpSuccessfulPostWithCloudEventInvocationFixtureProvider(
+				CloudEventOptions{
+					Mode:   CloudEventModeStructured,
+					ID:     "A234-1234-1234",
+					Type:   "com.example.order.created",
+					Source: "/orders",
+					Time:   "2020-09-13T12:26:40Z",
+				},
+				func(c C, r *http.Request, payload string) {
+					c.So(r.Header.Get("Ce-Id"), ShouldBeEmpty)
+					c.So(r.Header.Get(util.HeaderContentType), ShouldEqual, "application/cloudevents+json; charset=UTF-8")
+					c.So(payload, ShouldEqual, `{"data":{"id":42},"datacontenttype":"application/json","id":"A234-1234-1234","source":"/orders","specversion":"1.0","time":"2020-09-13T12:26:40Z","type":"com.example.order.created"}`)
+				}),
+		}
+
+		for k, fixtureSupplier := range fixtures {
+			Convey(fmt.Sprintf("Given the fixture supplier '%s' (case %d)", runtime.FuncForPC(reflect.ValueOf(fixtureSupplier).Pointer()).Name(), k), func() {
+				l := log.With().Logger()
+
+				fixture := fixtureSupplier()
+				ctx := l.WithContext(fixture.ctx)
+				teardown := fixture.arrange(c, ctx)
+				defer teardown()
+
+				Convey("When calling the function", func() {
+					res, err := fixture.httpAction.DoAction(ctx)
+
+					Convey("Then post-conditions shall be satisfied", func() {
+						fixture.assert(res, err)
+					})
+				})
+			})
+		}
+	})
+}
+
+func TestHttpCloudEventRetried(t *testing.T) {
+	Convey("Given an action emitting a CloudEvent with the default id and time", t, func(c C) {
+		var mu sync.Mutex
+
+		var ids, times []string
+
+		fixture := httpSuccessfulPostWithCloudEventInvocationFixtureProvider(
+			CloudEventOptions{
+				Type:   "com.example.order.created",
+				Source: "/orders",
+			},
+			func(c C, r *http.Request, payload string) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				ids = append(ids, r.Header.Get("Ce-Id"))
+				times = append(times, r.Header.Get("Ce-Time"))
+			})()
+		teardown := fixture.arrange(c, fixture.ctx)
+		defer teardown()
+
+		Convey("When performing the action twice (e.g. retried)", func() {
+			_, err1 := fixture.httpAction.DoAction(fixture.ctx)
+			time.Sleep(10 * time.Millisecond)
+			_, err2 := fixture.httpAction.DoAction(fixture.ctx)
+
+			Convey("Then the same event shall be sent", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				So(ids, ShouldHaveLength, 2)
+				So(ids[0], ShouldNotBeEmpty)
+				So(ids[1], ShouldEqual, ids[0])
+				So(times[0], ShouldNotBeEmpty)
+				So(times[1], ShouldEqual, times[0])
+			})
+		})
+	})
+}
+
+func TestHttpActionFactory(t *testing.T) {
+	Convey("When calling HttpActionFactory", t, func(c C) {
+		action := ActionFactory()
+
+		Convey("Then action created is an Action with default values", func() {
+
+			So(action, ShouldHaveSameTypeAs, &Action{})
+			So(action.(*Action).URI, ShouldEqual, "")
+			So(action.(*Action).GetURI(), ShouldEqual, "")
+			So(action.(*Action).Headers, ShouldResemble, map[string]string{})
+			So(action.(*Action).Body, ShouldEqual, "")
+			So(action.(*Action).Options.Transport.MaxRedirects, ShouldEqual, 50)
+			So(action.(*Action).Options.Transport.FollowRedirect, ShouldEqual, true)
+		})
+
+		Convey("And created action can be marshalled into a log without error", func() {
+			log.
+				Info().
+				Object("action", action).
+				Msg("action built")
+		})
+	})
+}
+
+func TestHTTPConfigFactory(t *testing.T) {
+	Convey("When calling 'ConfigFactory' function", t, func(c C) {
+		factory := ConfigFactory()
+		Convey("Then configuration provided shall be the expected one", func() {
+			So(factory.PreCondition, ShouldEqual, "true")
+		})
+	})
+}
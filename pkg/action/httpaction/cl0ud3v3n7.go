@@ -0,0 +1,157 @@
+package httpaction
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+)
+
+const (
+	// CloudEventModeBinary specifies that the CloudEvent attributes are sent as ce-* headers, the body being the
+	// data of the event.
+	CloudEventModeBinary = "binary"
+	// CloudEventModeStructured specifies that the CloudEvent is sent as a JSON document
+	// (application/cloudevents+json), the body being the data member of the event.
+	CloudEventModeStructured = "structured"
+	// CloudEventSpecVersion is the version of the CloudEvents specification the events are emitted with.
+	CloudEventSpecVersion = "1.0"
+)
+
+// CloudEventOptions specifies the CloudEvent to emit, the body of the action being the data of the event.
+// See: https://github.com/cloudevents/spec/blob/v1.0.1/http-protocol-binding.md
+type CloudEventOptions struct {
+	// Mode specifies how the event is sent: binary or structured. binary by default.
+	Mode string `yaml:"mode" validate:"omitempty,oneof=binary structured"`
+	// ID specifies the id of the event. A random id by default.
+	ID string `yaml:"id"`
+	// Type specifies the type of the event. No CloudEvent is emitted if empty.
+	Type string `yaml:"type"`
+	// Source specifies the source of the event.
+	Source string `yaml:"source" validate:"required_with=Type"`
+	// Subject specifies the subject of the event.
+	Subject string `yaml:"subject"`
+	// Time specifies the time of the event (RFC3339). The current time by default.
+	Time string `yaml:"time"`
+	// DataSchema specifies the schema the data of the event adheres to.
+	DataSchema string `yaml:"dataSchema"`
+	// Extensions specifies the extension attributes of the event.
+	Extensions map[string]string `yaml:"extensions"`
+}
+
+// enabled returns true if a CloudEvent shall be emitted.
+func (o CloudEventOptions) enabled() bool {
+	return o.Type != ""
+}
+
+// fillDefaults fills the default values of the id and the time of the event, once for all, so that the event is the
+// same whatever the number of times the action is performed (e.g. retried).
+func (o *CloudEventOptions) fillDefaults(now time.Time) error {
+	if o.ID == "" {
+		id := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, id); err != nil {
+			return err
+		}
+
+		o.ID = hex.EncodeToString(id)
+	}
+
+	if o.Time == "" {
+		o.Time = now.UTC().Format(time.RFC3339Nano)
+	}
+
+	return nil
+}
+
+// attributes returns the attributes of the event (but the datacontenttype).
+func (o CloudEventOptions) attributes() map[string]string {
+	attributes := map[string]string{
+		"specversion": CloudEventSpecVersion,
+		"id":          o.ID,
+		"type":        o.Type,
+		"source":      o.Source,
+		"time":        o.Time,
+	}
+
+	if o.Subject != "" {
+		attributes["subject"] = o.Subject
+	}
+
+	if o.DataSchema != "" {
+		attributes["dataschema"] = o.DataSchema
+	}
+
+	for k, v := range o.Extensions {
+		attributes[strings.ToLower(k)] = v
+	}
+
+	return attributes
+}
+
+// apply turns the request (i.e. its headers and body) into a CloudEvent, according to the mode, returning the
+// body to send.
+func (o *CloudEventOptions) apply(header http.Header, body string, now time.Time) (string, error) {
+	if err := o.fillDefaults(now); err != nil {
+		return "", err
+	}
+
+	attributes := o.attributes()
+
+	contentType := header.Get(util.HeaderContentType)
+
+	if o.Mode != CloudEventModeStructured {
+		for k, v := range attributes {
+			header.Set("Ce-"+k, encodeCloudEventHeaderValue(v))
+		}
+
+		return body, nil
+	}
+
+	event := make(map[string]interface{}, len(attributes)+2)
+	for k, v := range attributes {
+		event[k] = v
+	}
+
+	switch {
+	case contentType != "" && util.IsJSONMediaType(contentType) && json.Valid([]byte(body)):
+		event["datacontenttype"] = contentType
+		event["data"] = json.RawMessage(body)
+	case body != "":
+		if contentType != "" {
+			event["datacontenttype"] = contentType
+		}
+
+		event["data"] = body
+	}
+
+	structured, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	header.Set(util.HeaderContentType, "application/cloudevents+json; charset=UTF-8")
+
+	return string(structured), nil
+}
+
+// encodeCloudEventHeaderValue percent-encodes the characters of the value which are not allowed in a header.
+// See: https://github.com/cloudevents/spec/blob/v1.0.1/http-protocol-binding.md#3132-http-header-values
+func encodeCloudEventHeaderValue(v string) string {
+	var sb strings.Builder
+
+	for _, b := range []byte(v) {
+		if b <= ' ' || b >= 0x7f || b == '"' || b == '%' {
+			sb.WriteString(fmt.Sprintf("%%%02X", b))
+		} else {
+			sb.WriteByte(b)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,326 @@
+package kafkaaction
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultPort specifies the default port of the Kafka brokers.
+	DefaultPort = "9092"
+	// DefaultClientID specifies the default identifier the client identifies itself with to the brokers.
+	DefaultClientID = "kynaptik"
+	// PartitionerHash specifies that the partition is chosen from the hash of the key (randomly if no key).
+	PartitionerHash = "hash"
+	// PartitionerRandom specifies that the partition is chosen randomly.
+	PartitionerRandom = "random"
+	// PartitionerRoundRobin specifies that the partitions are chosen one after the other.
+	PartitionerRoundRobin = "roundrobin"
+	// PartitionerManual specifies that the partition is the one specified by the action.
+	PartitionerManual = "manual"
+	// AcksNone specifies that the brokers don't acknowledge the message.
+	AcksNone = "none"
+	// AcksLeader specifies that the message is acknowledged once written by the leader.
+	AcksLeader = "leader"
+	// AcksAll specifies that the message is acknowledged once written by all the in-sync replicas.
+	AcksAll = "all"
+	// SASLMechanismPlain specifies the SASL/PLAIN authentication.
+	SASLMechanismPlain = "PLAIN"
+	// SASLMechanismSCRAMSHA256 specifies the SASL/SCRAM authentication, with SHA-256.
+	SASLMechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	// SASLMechanismSCRAMSHA512 specifies the SASL/SCRAM authentication, with SHA-512.
+	SASLMechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+type Action struct {
+	URI       string            `yaml:"uri" validate:"required,uri,scheme=kafka|scheme=kafkas"`
+	Key       string            `yaml:"key"`
+	Value     string            `yaml:"value"`
+	Headers   map[string]string `yaml:"headers"`
+	Partition int32             `yaml:"partition" validate:"gte=0"`
+	Options   Options           `yaml:"options"`
+}
+
+type Options struct {
+	Partitioner string                `yaml:"partitioner" validate:"omitempty,oneof=hash random roundrobin manual"`
+	Acks        string                `yaml:"acks" validate:"omitempty,oneof=none leader all"`
+	Version     string                `yaml:"version"`
+	ClientID    string                `yaml:"clientID"`
+	SASL        SASLOptions           `yaml:"sasl"`
+	TLS         httpaction.TLSOptions `yaml:"tls"`
+}
+
+type SASLOptions struct {
+	Mechanism string `yaml:"mechanism" validate:"omitempty,oneof=PLAIN SCRAM-SHA-256 SCRAM-SHA-512"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// Response is the outcome of the production of the message, as exposed in the evaluation environment. The fields are
+// exposed under their (lowercase) expr names, e.g. response.offset, the Go names being still supported.
+type Response struct {
+	// Topic is the topic the message has been produced to.
+	Topic string `expr:"topic"`
+	// Partition is the partition the message has been produced to.
+	Partition int32 `expr:"partition"`
+	// Offset is the offset of the message in the partition (-1 if unknown, i.e. with no acknowledgement).
+	Offset int64 `expr:"offset"`
+}
+
+func init() {
+	kynaptik.RegisterActionKind(kynaptik.ActionKind{
+		Name:          "kafka",
+		Schemes:       []string{"kafka", "kafkas"},
+		ConfigFactory: ConfigFactory,
+		ActionFactory: ActionFactory,
+	})
+}
+
+// ConfigFactory returns the default configuration for the action.
+func ConfigFactory() kynaptik.Config {
+	return kynaptik.Config{
+		// PreCondition specifies the default pre-condition value. Here, we accept everything.
+		PreCondition: "true",
+		// PostCondition specifies the default post-condition to satisfy in order to consider the message produced.
+		// Here, the message being acknowledged (according to the acks level) is enough.
+		PostCondition: "true",
+	}
+}
+
+// ActionFactory returns a new action with default values.
+func ActionFactory() kynaptik.Action {
+	return &Action{
+		Headers: map[string]string{},
+		Options: Options{
+			Partitioner: PartitionerHash,
+			Acks:        AcksAll,
+			ClientID:    DefaultClientID,
+			SASL: SASLOptions{
+				Mechanism: SASLMechanismPlain,
+			},
+		},
+	}
+}
+
+func (a *Action) GetURI() string {
+	return a.URI
+}
+
+func (a *Action) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("uri", a.URI).
+		Str("key", a.Key).
+		Object("headers", util.MapToLogObjectMarshaller(a.Headers)).
+		Str("value", a.Value)
+}
+
+func (a *Action) DoAction(ctx context.Context) (interface{}, error) {
+	u, err := url.Parse(a.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	brokers, topic, err := endpoints(u)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := a.config(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	message := a.message(topic)
+	key := producerKey(u.Scheme, brokers, a.Options)
+
+	type result struct {
+		response *Response
+		err      error
+	}
+
+	// the producer doesn't support contexts, the production being bounded by its timeouts once the context is done.
+	// Note the message may then still be produced, the action being at least once when retried.
+	results := make(chan result, 1)
+
+	go func() {
+		response, err := produce(key, brokers, config, message)
+		results <- result{response, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-results:
+		return r.response, r.err
+	}
+}
+
+// endpoints returns the addresses of the brokers and the topic specified by the given uri.
+func endpoints(u *url.URL) ([]string, string, error) {
+	var brokers []string
+
+	for _, broker := range strings.Split(u.Host, ",") {
+		if broker == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			broker = net.JoinHostPort(broker, DefaultPort)
+		}
+
+		brokers = append(brokers, broker)
+	}
+
+	if len(brokers) == 0 {
+		return nil, "", errors.New("no broker specified")
+	}
+
+	topic := strings.Trim(u.Path, "/")
+	if topic == "" {
+		return nil, "", errors.New("no topic specified")
+	}
+
+	return brokers, topic, nil
+}
+
+// config returns the configuration of the producer. The producers being shared (see producerPool), the timeouts are the
+// default ones, the production being bounded by the context of each action.
+func (a *Action) config(u *url.URL) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+
+	config.ClientID = a.Options.ClientID
+	if config.ClientID == "" {
+		config.ClientID = DefaultClientID
+	}
+
+	if a.Options.Version != "" {
+		version, err := sarama.ParseKafkaVersion(a.Options.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Version = version
+	}
+
+	// only the metadata of the topic of the message are needed
+	config.Metadata.Full = false
+	config.Producer.Return.Successes = true
+
+	switch a.Options.Acks {
+	case AcksNone:
+		config.Producer.RequiredAcks = sarama.NoResponse
+	case AcksLeader:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	default:
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	}
+
+	switch a.Options.Partitioner {
+	case PartitionerRandom:
+		config.Producer.Partitioner = sarama.NewRandomPartitioner
+	case PartitionerRoundRobin:
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	case PartitionerManual:
+		config.Producer.Partitioner = sarama.NewManualPartitioner
+	default:
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+	}
+
+	if u.Scheme == "kafkas" {
+		tlsConfig, err := a.Options.TLS.ToTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if a.Options.SASL.Username != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = a.Options.SASL.Username
+		config.Net.SASL.Password = a.Options.SASL.Password
+
+		if config.Version.IsAtLeast(sarama.V1_0_0_0) {
+			config.Net.SASL.Version = sarama.SASLHandshakeV1
+		}
+
+		switch a.Options.SASL.Mechanism {
+		case SASLMechanismSCRAMSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = scramClientGenerator(scramSHA256)
+		case SASLMechanismSCRAMSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = scramClientGenerator(scramSHA512)
+		default:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		}
+	}
+
+	return config, nil
+}
+
+// message returns the message to produce to the given topic.
+func (a *Action) message(topic string) *sarama.ProducerMessage {
+	message := &sarama.ProducerMessage{
+		Topic:     topic,
+		Value:     sarama.StringEncoder(a.Value),
+		Partition: a.Partition,
+	}
+
+	// with no key, the hash partitioner chooses the partition randomly
+	if a.Key != "" {
+		message.Key = sarama.StringEncoder(a.Key)
+	}
+
+	names := make([]string, 0, len(a.Headers))
+	for name := range a.Headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(a.Headers[name])})
+	}
+
+	return message
+}
+
+// produce produces the given message to the given brokers, with the producer of the given key (shared by the actions
+// of the same configuration).
+func produce(key string, brokers []string, config *sarama.Config, message *sarama.ProducerMessage) (*Response, error) {
+	sp, err := producers.acquire(key, brokers, config, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	partition, offset, err := sp.producer.SendMessage(message)
+	producers.release(key, sp, err != nil, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Producer.RequiredAcks == sarama.NoResponse {
+		// the offset is not known without acknowledgement
+		offset = -1
+	}
+
+	return &Response{Topic: message.Topic, Partition: partition, Offset: offset}, nil
+}
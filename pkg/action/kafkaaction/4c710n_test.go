@@ -0,0 +1,595 @@
+package kafkaaction
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/antonmedv/expr"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	"github.com/rs/zerolog/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	etcPath = "../../../etc/"
+	topic   = "events"
+)
+
+type kafkaFixtureSupplier func(t *testing.T) kafkaFixture
+
+type kafkaFixture struct {
+	ctx         context.Context
+	kafkaAction Action
+	// arrange is a function which initializes the fixture and in returns provides a function which finalizes (clean)
+	// that fixture when called
+	arrange func(c C, ctx context.Context) func()
+	// assert is a function performing the assertions on the result
+	assert func(interface{}, error)
+}
+
+// newMockBroker returns a mock broker (over TLS if requested), leader of the given partitions of the topic, replying
+// to the produce requests with the given response.
+func newMockBroker(t *testing.T, secure bool, partitions []int32, handlers map[string]sarama.MockResponse) *sarama.MockBroker {
+	listener, err := net.Listen("tcp", "localhost:0")
+	So(err, ShouldBeNil)
+
+	if secure {
+		cert, err := tls.LoadX509KeyPair(path.Join(etcPath, "cert/leaf.pem"), path.Join(etcPath, "cert/leaf.key"))
+		So(err, ShouldBeNil)
+
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	broker := sarama.NewMockBrokerListener(t, 1, listener)
+	// the address of the broker is advertised by host name, as expected by the certificate
+	addr := fmt.Sprintf("localhost:%d", broker.Port())
+
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(addr, broker.BrokerID())
+	for _, partition := range partitions {
+		metadata.SetLeader(topic, partition, broker.BrokerID())
+	}
+
+	handlers["MetadataRequest"] = metadata
+	broker.SetHandlerByMap(handlers)
+
+	return broker
+}
+
+// produceResponse returns the response to the production of a message to the given partition of the topic, at the
+// given offset.
+func produceResponse(partition int32, offset int64) sarama.MockResponse {
+	return sarama.NewMockWrapper(&sarama.ProduceResponse{
+		Version: 3,
+		Blocks: map[string]map[int32]*sarama.ProduceResponseBlock{
+			topic: {partition: {Err: sarama.ErrNoError, Offset: offset}},
+		},
+	})
+}
+
+// requestsOf returns the requests of the given type received by the broker.
+func requestsOf(broker *sarama.MockBroker, typ interface{}) []interface{} {
+	var requests []interface{}
+
+	for _, rr := range broker.History() {
+		if reflect.TypeOf(rr.Request) == reflect.TypeOf(typ) {
+			requests = append(requests, rr.Request)
+		}
+	}
+
+	return requests
+}
+
+// kafkaFixtureProvider returns a fixture producing the given action to a mock broker (started according to the given
+// options), the URI of the action being prefixed by the scheme and the address of the broker.
+func kafkaFixtureProvider(
+	scheme string,
+	partitions []int32,
+	handlers func(t *testing.T) map[string]sarama.MockResponse,
+	action Action,
+	assert func(broker *sarama.MockBroker, res interface{}, err error),
+) kafkaFixtureSupplier {
+	return func(t *testing.T) kafkaFixture {
+		broker := newMockBroker(t, scheme == "kafkas", partitions, handlers(t))
+		rootPem, _ := ioutil.ReadFile(path.Join(etcPath, "cert/root.pem"))
+
+		action.URI = fmt.Sprintf("%s://localhost:%d/%s", scheme, broker.Port(), topic)
+		action.Options.TLS = httpaction.TLSOptions{CACertData: string(rootPem)}
+
+		return kafkaFixture{
+			ctx:         context.Background(),
+			kafkaAction: action,
+			arrange: func(c C, ctx context.Context) func() {
+				return broker.Close
+			},
+			assert: func(res interface{}, err error) {
+				assert(broker, res, err)
+			},
+		}
+	}
+}
+
+func kafkaSuccessfulProduceFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{"ProduceRequest": produceResponse(0, 42)}
+		},
+		Action{
+			Key:     "42",
+			Value:   `{"id":42}`,
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Options: Options{Partitioner: PartitionerHash, Acks: AcksAll},
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Topic: topic, Partition: 0, Offset: 42})
+
+			requests := requestsOf(broker, &sarama.ProduceRequest{})
+			So(requests, ShouldHaveLength, 1)
+			So(requests[0].(*sarama.ProduceRequest).RequiredAcks, ShouldEqual, sarama.WaitForAll)
+		})(t)
+}
+
+func kafkaSuccessfulManualPartitionFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0, 1, 2},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{"ProduceRequest": produceResponse(2, 7)}
+		},
+		Action{
+			Value:     "hello",
+			Partition: 2,
+			Options:   Options{Partitioner: PartitionerManual, Acks: AcksLeader},
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Topic: topic, Partition: 2, Offset: 7})
+
+			requests := requestsOf(broker, &sarama.ProduceRequest{})
+			So(requests, ShouldHaveLength, 1)
+			So(requests[0].(*sarama.ProduceRequest).RequiredAcks, ShouldEqual, sarama.WaitForLocal)
+		})(t)
+}
+
+func kafkaSuccessfulNoAckFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			// no response is expected by the producer
+			return map[string]sarama.MockResponse{"ProduceRequest": sarama.NewMockWrapper(nil)}
+		},
+		Action{
+			Value:   "hello",
+			Options: Options{Acks: AcksNone},
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Topic: topic, Partition: 0, Offset: -1})
+		})(t)
+}
+
+func kafkaSuccessfulTLSFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafkas", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{"ProduceRequest": produceResponse(0, 1)}
+		},
+		Action{
+			Value: "hello",
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Topic: topic, Partition: 0, Offset: 1})
+		})(t)
+}
+
+func kafkaSuccessfulSASLPlainFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{
+				"SaslHandshakeRequest":    sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{sarama.SASLTypePlaintext}),
+				"SaslAuthenticateRequest": sarama.NewMockSaslAuthenticateResponse(t),
+				"ProduceRequest":          produceResponse(0, 1),
+			}
+		},
+		Action{
+			Value: "hello",
+			Options: Options{
+				SASL: SASLOptions{Mechanism: SASLMechanismPlain, Username: "john", Password: "s3cr3t"},
+			},
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{Topic: topic, Partition: 0, Offset: 1})
+
+			requests := requestsOf(broker, &sarama.SaslAuthenticateRequest{})
+			So(requests, ShouldNotBeEmpty)
+			So(string(requests[0].(*sarama.SaslAuthenticateRequest).SaslAuthBytes), ShouldEqual, "\x00john\x00s3cr3t")
+		})(t)
+}
+
+func kafkaFailedSASLFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{
+				"SaslHandshakeRequest":    sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{sarama.SASLTypePlaintext}),
+				"SaslAuthenticateRequest": sarama.NewMockSaslAuthenticateResponse(t).SetError(sarama.ErrSASLAuthenticationFailed),
+			}
+		},
+		Action{
+			Value: "hello",
+			Options: Options{
+				SASL: SASLOptions{Mechanism: SASLMechanismPlain, Username: "john", Password: "wrong"},
+			},
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "kafka server: SASL Authentication failed.")
+			So(requestsOf(broker, &sarama.ProduceRequest{}), ShouldBeEmpty)
+		})(t)
+}
+
+func kafkaFailedProduceFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{
+				"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).SetError(topic, 0, sarama.ErrMessageSizeTooLarge),
+			}
+		},
+		Action{
+			Value: "hello",
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "kafka server: Message was too large, server rejected it to avoid allocation error.")
+		})(t)
+}
+
+func kafkaIncorrectVersionFixture(t *testing.T) kafkaFixture {
+	return kafkaFixtureProvider("kafka", []int32{0},
+		func(t *testing.T) map[string]sarama.MockResponse {
+			return map[string]sarama.MockResponse{}
+		},
+		Action{
+			Value:   "hello",
+			Options: Options{Version: "foo"},
+		},
+		func(broker *sarama.MockBroker, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "invalid version `foo`")
+		})(t)
+}
+
+func kafkaNoTopicFixture(t *testing.T) kafkaFixture {
+	return kafkaFixture{
+		ctx: context.Background(),
+		kafkaAction: Action{
+			URI:   "kafka://localhost:9092",
+			Value: "hello",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			return func() {}
+		},
+		assert: func(res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "no topic specified")
+		},
+	}
+}
+
+func kafkaTimeoutFixture(t *testing.T) kafkaFixture {
+	broker := newMockBroker(t, false, []int32{0}, map[string]sarama.MockResponse{
+		"ProduceRequest": produceResponse(0, 1),
+	})
+	broker.SetLatency(500 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+	return kafkaFixture{
+		ctx: ctx,
+		kafkaAction: Action{
+			URI:   fmt.Sprintf("kafka://localhost:%d/%s", broker.Port(), topic),
+			Value: "hello",
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			return func() {
+				cancel()
+				broker.Close()
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+		},
+	}
+}
+
+func TestKafkaFunction(t *testing.T) {
+	Convey("Considering the Kafka function", t, func(c C) {
+		fixtures := []kafkaFixtureSupplier{
+			kafkaSuccessfulProduceFixture,
+			kafkaSuccessfulManualPartitionFixture,
+			kafkaSuccessfulNoAckFixture,
+			kafkaSuccessfulTLSFixture,
+			kafkaSuccessfulSASLPlainFixture,
+			kafkaFailedSASLFixture,
+			kafkaFailedProduceFixture,
+			kafkaIncorrectVersionFixture,
+			kafkaNoTopicFixture,
+			kafkaTimeoutFixture,
+		}
+
+		for _, fixtureSupplier := range fixtures {
+			Convey(fmt.Sprintf("Given the fixture supplier '%s'", runtime.FuncForPC(reflect.ValueOf(fixtureSupplier).Pointer()).Name()), func() {
+				l := log.With().Logger()
+
+				fixture := fixtureSupplier(t)
+				ctx := l.WithContext(fixture.ctx)
+				teardown := fixture.arrange(c, ctx)
+				defer teardown()
+
+				Convey("When calling the function", func() {
+					res, err := fixture.kafkaAction.DoAction(ctx)
+
+					Convey("Then post-conditions shall be satisfied", func() {
+						fixture.assert(res, err)
+					})
+				})
+			})
+		}
+	})
+}
+
+func TestKafkaEndpoints(t *testing.T) {
+	Convey("Considering the endpoints of the Kafka uris", t, func(c C) {
+		cases := []struct {
+			uri     string
+			brokers []string
+			topic   string
+			err     string
+		}{
+			{uri: "kafka://broker/events", brokers: []string{"broker:9092"}, topic: "events"},
+			{uri: "kafka://broker1,broker2:9093/events", brokers: []string{"broker1:9092", "broker2:9093"}, topic: "events"},
+			{uri: "kafkas://broker1:9093,broker2:9093/events/", brokers: []string{"broker1:9093", "broker2:9093"}, topic: "events"},
+			{uri: "kafka://broker", err: "no topic specified"},
+			{uri: "kafka:///events", err: "no broker specified"},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+
+			Convey(fmt.Sprintf("When getting the endpoints of '%s'", tc.uri), func() {
+				u, err := url.Parse(tc.uri)
+				So(err, ShouldBeNil)
+
+				brokers, topic, err := endpoints(u)
+
+				Convey("Then the endpoints shall be the expected ones", func() {
+					if tc.err != "" {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					} else {
+						So(err, ShouldBeNil)
+						So(brokers, ShouldResemble, tc.brokers)
+						So(topic, ShouldEqual, tc.topic)
+					}
+				})
+			})
+		}
+	})
+}
+
+func TestKafkaMessage(t *testing.T) {
+	Convey("Given an action with a key and headers", t, func(c C) {
+		action := Action{
+			Key:       "42",
+			Value:     `{"id":42}`,
+			Headers:   map[string]string{"Content-Type": "application/json", "Ce-Id": "42"},
+			Partition: 3,
+		}
+
+		Convey("When building the message", func() {
+			message := action.message(topic)
+
+			Convey("Then the message shall be the expected one", func() {
+				So(message.Topic, ShouldEqual, topic)
+				So(message.Key, ShouldEqual, sarama.StringEncoder("42"))
+				So(message.Value, ShouldEqual, sarama.StringEncoder(`{"id":42}`))
+				So(message.Partition, ShouldEqual, 3)
+				So(message.Headers, ShouldResemble, []sarama.RecordHeader{
+					{Key: []byte("Ce-Id"), Value: []byte("42")},
+					{Key: []byte("Content-Type"), Value: []byte("application/json")},
+				})
+			})
+		})
+	})
+
+	Convey("Given an action without key", t, func(c C) {
+		action := Action{Value: "hello"}
+
+		Convey("When building the message", func() {
+			message := action.message(topic)
+
+			Convey("Then the message shall have no key", func() {
+				So(message.Key, ShouldBeNil)
+				So(message.Headers, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestKafkaConfig(t *testing.T) {
+	Convey("Given an action over TLS, authenticated with SASL/SCRAM", t, func(c C) {
+		action := Action{
+			URI: "kafkas://broker/events",
+			Options: Options{
+				Version: "2.8.0",
+				SASL:    SASLOptions{Mechanism: SASLMechanismSCRAMSHA512, Username: "john", Password: "s3cr3t"},
+			},
+		}
+
+		Convey("When building the configuration of the producer", func() {
+			u, _ := url.Parse(action.URI)
+			config, err := action.config(u)
+
+			Convey("Then the configuration shall be the expected one", func() {
+				So(err, ShouldBeNil)
+				So(config.Validate(), ShouldBeNil)
+				So(config.ClientID, ShouldEqual, DefaultClientID)
+				So(config.Version, ShouldResemble, sarama.V2_8_0_0)
+				So(config.Net.TLS.Enable, ShouldBeTrue)
+				So(config.Net.SASL.Enable, ShouldBeTrue)
+				So(config.Net.SASL.Mechanism, ShouldEqual, sarama.SASLTypeSCRAMSHA512)
+				So(config.Net.SASL.Version, ShouldEqual, sarama.SASLHandshakeV1)
+				So(config.Net.SASL.SCRAMClientGeneratorFunc(), ShouldHaveSameTypeAs, &scramClient{})
+				// the producers being shared, their timeouts don't depend on the deadline of an action
+				defaults := sarama.NewConfig()
+				So(config.Net.DialTimeout, ShouldEqual, defaults.Net.DialTimeout)
+				So(config.Net.ReadTimeout, ShouldEqual, defaults.Net.ReadTimeout)
+				So(config.Net.WriteTimeout, ShouldEqual, defaults.Net.WriteTimeout)
+				So(config.Producer.Timeout, ShouldEqual, defaults.Producer.Timeout)
+			})
+		})
+	})
+}
+
+func TestKafkaProducerPool(t *testing.T) {
+	Convey("Given a pool of producers and a broker", t, func(c C) {
+		broker := newMockBroker(t, false, []int32{0}, map[string]sarama.MockResponse{})
+		defer broker.Close()
+
+		pool := newProducerPool()
+		action := Action{URI: fmt.Sprintf("kafka://localhost:%d/%s", broker.Port(), topic)}
+		u, _ := url.Parse(action.URI)
+		brokers, _, _ := endpoints(u)
+		config, err := action.config(u)
+		So(err, ShouldBeNil)
+
+		key := producerKey(u.Scheme, brokers, action.Options)
+		now := time.Unix(1600000000, 0)
+
+		Convey("When acquiring the producer of the same configuration twice", func() {
+			sp1, err1 := pool.acquire(key, brokers, config, now)
+			sp2, err2 := pool.acquire(key, brokers, config, now)
+
+			Convey("Then the producer shall be shared", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(sp2, ShouldEqual, sp1)
+				So(sp1.inUse, ShouldEqual, 2)
+			})
+
+			Convey("And when releasing it", func() {
+				pool.release(key, sp1, false, now)
+				pool.release(key, sp2, false, now)
+
+				Convey("Then it shall be kept for the next actions", func() {
+					So(pool.producers, ShouldContainKey, key)
+					So(sp1.evicted, ShouldBeFalse)
+				})
+
+				Convey("And then it shall be closed once idle", func() {
+					pool.sweep(now.Add(producerIdleTimeout))
+
+					So(pool.producers, ShouldBeEmpty)
+					So(sp1.evicted, ShouldBeTrue)
+				})
+			})
+
+			Convey("And when the production fails with one of them", func() {
+				pool.release(key, sp1, true, now)
+
+				Convey("Then the producer shall no longer be shared, but kept until not in use", func() {
+					So(pool.producers, ShouldBeEmpty)
+					So(sp1.evicted, ShouldBeTrue)
+					So(sp1.inUse, ShouldEqual, 1)
+
+					sp3, err := pool.acquire(key, brokers, config, now)
+					So(err, ShouldBeNil)
+					So(sp3, ShouldNotEqual, sp1)
+
+					pool.release(key, sp2, false, now)
+					pool.release(key, sp3, false, now)
+					So(pool.producers[key], ShouldEqual, sp3)
+				})
+			})
+		})
+
+		Convey("When acquiring the producers of different configurations", func() {
+			other := action
+			other.Options.ClientID = "other"
+			otherKey := producerKey(u.Scheme, brokers, other.Options)
+
+			sp1, err1 := pool.acquire(key, brokers, config, now)
+			sp2, err2 := pool.acquire(otherKey, brokers, config, now)
+
+			Convey("Then the producers shall be distinct", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(otherKey, ShouldNotEqual, key)
+				So(sp2, ShouldNotEqual, sp1)
+			})
+
+			pool.release(key, sp1, true, now)
+			pool.release(otherKey, sp2, true, now)
+		})
+	})
+}
+
+func TestKafkaActionFactory(t *testing.T) {
+	Convey("When calling 'ActionFactory' function", t, func(c C) {
+		action := ActionFactory()
+
+		Convey("Then action created is an Action with default values", func() {
+			So(action, ShouldHaveSameTypeAs, &Action{})
+			So(action.GetURI(), ShouldEqual, "")
+			So(action.(*Action).Headers, ShouldResemble, map[string]string{})
+			So(action.(*Action).Options.Partitioner, ShouldEqual, PartitionerHash)
+			So(action.(*Action).Options.Acks, ShouldEqual, AcksAll)
+			So(action.(*Action).Options.ClientID, ShouldEqual, DefaultClientID)
+			So(action.(*Action).Options.SASL.Mechanism, ShouldEqual, SASLMechanismPlain)
+		})
+
+		Convey("And created action can be marshalled into a log without error", func() {
+			log.
+				Info().
+				Object("action", action).
+				Msg("action built")
+		})
+	})
+}
+
+func TestKafkaConfigFactory(t *testing.T) {
+	Convey("When calling 'ConfigFactory' function", t, func(c C) {
+		config := ConfigFactory()
+
+		Convey("Then configuration provided shall be the expected one", func() {
+			So(config.PreCondition, ShouldEqual, "true")
+			So(config.PostCondition, ShouldEqual, "true")
+		})
+
+		Convey("Then the postConditions shall be evaluated against the response, by its lowercase and Go field names", func() {
+			for _, postCondition := range []string{`response.offset >= 0 and response.topic == "events"`, `response.Offset == 42 and response.Partition == 0`} {
+				program, err := expr.Compile(postCondition)
+				So(err, ShouldBeNil)
+
+				satisfied, err := util.EvaluatePredicateExpression(program, map[string]interface{}{
+					"response": &Response{Topic: topic, Partition: 0, Offset: 42},
+				})
+				So(err, ShouldBeNil)
+				So(satisfied, ShouldBeTrue)
+			}
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package kafkaaction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// producerIdleTimeout specifies the duration after which a producer not used anymore is closed.
+	producerIdleTimeout = 5 * time.Minute
+	// producerSweepInterval specifies the minimum interval between two evictions of the idle producers.
+	producerSweepInterval = time.Minute
+)
+
+// sharedProducer is a producer shared by the actions of the same configuration.
+type sharedProducer struct {
+	producer sarama.SyncProducer
+	// inUse is the number of productions in progress with the producer.
+	inUse int
+	// lastUsed is the time the producer was last released.
+	lastUsed time.Time
+	// evicted is true once the producer is no longer shared, the producer being closed as soon as it's not in use.
+	evicted bool
+}
+
+// producerPool contains the producers, by configuration. The pool lives in-process, across invocations, the idle
+// producers being closed, as well as the ones which failed to produce a message.
+type producerPool struct {
+	mu        sync.Mutex
+	producers map[string]*sharedProducer
+	nextSweep time.Time
+}
+
+func newProducerPool() *producerPool {
+	return &producerPool{producers: map[string]*sharedProducer{}}
+}
+
+var producers = newProducerPool()
+
+// producerKey returns the key identifying the producers of the given action, i.e. its brokers and its options.
+func producerKey(scheme string, brokers []string, options Options) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%#v", scheme, strings.Join(brokers, ","), options)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// acquire returns the producer for the given key, created with the given brokers and configuration if needed. The
+// producer shall be released once the production done.
+func (p *producerPool) acquire(key string, brokers []string, config *sarama.Config, now time.Time) (*sharedProducer, error) {
+	p.mu.Lock()
+	p.sweep(now)
+
+	if sp, ok := p.producers[key]; ok {
+		sp.inUse++
+		p.mu.Unlock()
+
+		return sp, nil
+	}
+	p.mu.Unlock()
+
+	// the producer is created outside the lock, the connection to the brokers taking time
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if sp, ok := p.producers[key]; ok {
+		// created concurrently in the meantime
+		_ = producer.Close()
+		sp.inUse++
+
+		return sp, nil
+	}
+
+	sp := &sharedProducer{producer: producer, inUse: 1}
+	p.producers[key] = sp
+
+	return sp, nil
+}
+
+// release releases the given producer, evicting it if the production failed.
+func (p *producerPool) release(key string, sp *sharedProducer, failed bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sp.inUse--
+	sp.lastUsed = now
+
+	if failed && !sp.evicted {
+		sp.evicted = true
+		if p.producers[key] == sp {
+			delete(p.producers, key)
+		}
+	}
+
+	if sp.evicted && sp.inUse == 0 {
+		_ = sp.producer.Close()
+	}
+}
+
+// sweep closes the idle producers, at most once per producerSweepInterval. The caller shall hold the pool.
+func (p *producerPool) sweep(now time.Time) {
+	if now.Before(p.nextSweep) {
+		return
+	}
+
+	for key, sp := range p.producers {
+		if sp.inUse == 0 && now.Sub(sp.lastUsed) >= producerIdleTimeout {
+			sp.evicted = true
+			delete(p.producers, key)
+			_ = sp.producer.Close()
+		}
+	}
+
+	p.nextSweep = now.Add(producerSweepInterval)
+}
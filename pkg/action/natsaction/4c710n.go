@@ -0,0 +1,325 @@
+package natsaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultPort specifies the default port of the NATS servers.
+	DefaultPort = "4222"
+	// DefaultName specifies the default name the client identifies itself with to the servers.
+	DefaultName = "kynaptik"
+	// ModePublish specifies that the message is published (fire and forget).
+	ModePublish = "publish"
+	// ModeRequest specifies that the message is a request, the reply being waited for.
+	ModeRequest = "request"
+	// ModeJetStream specifies that the message is published to a JetStream stream, the acknowledgement being waited
+	// for.
+	ModeJetStream = "jetstream"
+)
+
+type Action struct {
+	URI     string            `yaml:"uri" validate:"required,uri,scheme=nats|scheme=natss"`
+	Payload string            `yaml:"payload"`
+	Headers map[string]string `yaml:"headers"`
+	Options Options           `yaml:"options"`
+}
+
+type Options struct {
+	Mode      string                `yaml:"mode" validate:"omitempty,oneof=publish request jetstream"`
+	JetStream JetStreamOptions      `yaml:"jetStream"`
+	Auth      AuthOptions           `yaml:"auth"`
+	TLS       httpaction.TLSOptions `yaml:"tls"`
+	Name      string                `yaml:"name"`
+}
+
+type JetStreamOptions struct {
+	MsgID  string `yaml:"msgID"`
+	Stream string `yaml:"stream"`
+}
+
+type AuthOptions struct {
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	Token       string `yaml:"token"`
+	NKeySeed    string `yaml:"nkeySeed"`
+	Credentials string `yaml:"credentials"`
+}
+
+// Response is the outcome of the publication of the message, as exposed in the evaluation environment. The fields are
+// exposed under their (lowercase) expr names, e.g. response.json, the Go names being still supported.
+type Response struct {
+	// Subject is the subject the message has been published to.
+	Subject string `expr:"subject"`
+	// Data is the content of the reply (request mode).
+	Data string `expr:"data"`
+	// Header is the headers of the reply (request mode).
+	Header nats.Header `expr:"headers"`
+	// JSON is the content of the reply decoded, if valid JSON, nil otherwise (request mode).
+	JSON interface{} `expr:"json"`
+	// Stream is the stream the message has been stored in (jetstream mode).
+	Stream string `expr:"stream"`
+	// Sequence is the sequence of the message in the stream (jetstream mode).
+	Sequence uint64 `expr:"sequence"`
+	// Duplicate tells if the message has been considered a duplicate, according to its id (jetstream mode).
+	Duplicate bool `expr:"duplicate"`
+}
+
+func init() {
+	kynaptik.RegisterActionKind(kynaptik.ActionKind{
+		Name:          "nats",
+		Schemes:       []string{"nats", "natss"},
+		ConfigFactory: ConfigFactory,
+		ActionFactory: ActionFactory,
+	})
+}
+
+// ConfigFactory returns the default configuration for the action.
+func ConfigFactory() kynaptik.Config {
+	return kynaptik.Config{
+		// PreCondition specifies the default pre-condition value. Here, we accept everything.
+		PreCondition: "true",
+		// PostCondition specifies the default post-condition to satisfy in order to consider the message published.
+		// Here, the message being received by the server (or replied, or acknowledged, according to the mode) is
+		// enough.
+		PostCondition: "true",
+	}
+}
+
+// ActionFactory returns a new action with default values.
+func ActionFactory() kynaptik.Action {
+	return &Action{
+		Headers: map[string]string{},
+		Options: Options{
+			Mode: ModePublish,
+			Name: DefaultName,
+		},
+	}
+}
+
+func (a *Action) GetURI() string {
+	return a.URI
+}
+
+func (a *Action) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("uri", a.URI).
+		Str("mode", a.Options.Mode).
+		Object("headers", util.MapToLogObjectMarshaller(a.Headers)).
+		Str("payload", a.Payload)
+}
+
+func (a *Action) DoAction(ctx context.Context) (interface{}, error) {
+	u, err := url.Parse(a.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, subject, err := endpoints(u)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := a.connectOptions(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := nats.Connect(strings.Join(servers, ","), options...)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	message := a.message(subject)
+
+	switch a.Options.Mode {
+	case ModeRequest:
+		return request(ctx, conn, message)
+	case ModeJetStream:
+		return a.publishJetStream(ctx, conn, message)
+	default:
+		return publish(ctx, conn, message)
+	}
+}
+
+// endpoints returns the urls of the servers and the subject specified by the given uri.
+func endpoints(u *url.URL) ([]string, string, error) {
+	var servers []string
+
+	for _, server := range strings.Split(u.Host, ",") {
+		if server == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, DefaultPort)
+		}
+
+		s := url.URL{Scheme: "nats", User: u.User, Host: server}
+		servers = append(servers, s.String())
+	}
+
+	if len(servers) == 0 {
+		return nil, "", errors.New("no server specified")
+	}
+
+	subject := strings.Trim(u.Path, "/")
+	if subject == "" {
+		return nil, "", errors.New("no subject specified")
+	}
+
+	return servers, subject, nil
+}
+
+// connectOptions returns the options of the connection to the servers, the timeout being bounded by the deadline of
+// the given context (if any).
+func (a *Action) connectOptions(ctx context.Context, u *url.URL) ([]nats.Option, error) {
+	name := a.Options.Name
+	if name == "" {
+		name = DefaultName
+	}
+
+	timeout := nats.DefaultTimeout
+
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+
+		if remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	options := []nats.Option{
+		nats.Name(name),
+		nats.Timeout(timeout),
+		nats.NoReconnect(),
+	}
+
+	if u.Scheme == "natss" {
+		tlsConfig, err := a.Options.TLS.ToTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, nats.Secure(tlsConfig))
+	}
+
+	auth, err := a.Options.Auth.options()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(options, auth...), nil
+}
+
+// message returns the message to publish to the given subject.
+func (a *Action) message(subject string) *nats.Msg {
+	message := nats.NewMsg(subject)
+	message.Data = []byte(a.Payload)
+
+	for k, v := range a.Headers {
+		message.Header.Set(k, v)
+	}
+
+	return message
+}
+
+// publish publishes the given message, waiting for the server to have processed it.
+func publish(ctx context.Context, conn *nats.Conn, message *nats.Msg) (*Response, error) {
+	if err := conn.PublishMsg(message); err != nil {
+		return nil, err
+	}
+
+	flush := conn.FlushWithContext
+	if _, ok := ctx.Deadline(); !ok {
+		// a deadline is required for flushing with a context
+		flush = func(context.Context) error {
+			return conn.FlushTimeout(nats.DefaultTimeout)
+		}
+	}
+
+	if err := flush(ctx); err != nil {
+		return nil, err
+	}
+
+	return &Response{Subject: message.Subject}, nil
+}
+
+// request publishes the given message as a request, waiting for the reply.
+func request(ctx context.Context, conn *nats.Conn, message *nats.Msg) (*Response, error) {
+	requestMsg := conn.RequestMsgWithContext
+	if _, ok := ctx.Deadline(); !ok {
+		// the wait for the reply shall be bounded, even with no deadline
+		requestMsg = func(_ context.Context, message *nats.Msg) (*nats.Msg, error) {
+			return conn.RequestMsg(message, nats.DefaultTimeout)
+		}
+	}
+
+	reply, err := requestMsg(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &Response{
+		Subject: message.Subject,
+		Data:    string(reply.Data),
+		Header:  reply.Header,
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(reply.Data, &v); err == nil {
+		response.JSON = v
+	}
+
+	return response, nil
+}
+
+// publishJetStream publishes the given message to JetStream, waiting for the acknowledgement.
+func (a *Action) publishJetStream(ctx context.Context, conn *nats.Conn, message *nats.Msg) (*Response, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	// the wait for the acknowledgement shall be bounded, even with no deadline
+	options := []nats.PubOpt{nats.AckWait(nats.DefaultTimeout)}
+	if _, ok := ctx.Deadline(); ok {
+		options = []nats.PubOpt{nats.Context(ctx)}
+	}
+
+	if a.Options.JetStream.MsgID != "" {
+		options = append(options, nats.MsgId(a.Options.JetStream.MsgID))
+	}
+
+	if a.Options.JetStream.Stream != "" {
+		options = append(options, nats.ExpectStream(a.Options.JetStream.Stream))
+	}
+
+	ack, err := js.PublishMsg(message, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		Subject:   message.Subject,
+		Stream:    ack.Stream,
+		Sequence:  ack.Sequence,
+		Duplicate: ack.Duplicate,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package natsaction
+
+import (
+	"errors"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// options returns the options of the connection authenticating the client, according to the credentials specified
+// (if any).
+func (o AuthOptions) options() ([]nats.Option, error) {
+	var options []nats.Option
+
+	if o.Username != "" {
+		options = append(options, nats.UserInfo(o.Username, o.Password))
+	}
+
+	if o.Token != "" {
+		options = append(options, nats.Token(o.Token))
+	}
+
+	if o.NKeySeed != "" {
+		kp, err := nkeys.FromSeed([]byte(o.NKeySeed))
+		if err != nil {
+			return nil, err
+		}
+
+		pub, err := kp.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, nats.Nkey(pub, kp.Sign))
+	}
+
+	if o.Credentials != "" {
+		option, err := credentialsOption([]byte(o.Credentials))
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, option)
+	}
+
+	return options, nil
+}
+
+// credentialsOption returns the option authenticating the client with the given credentials (i.e. the content of a
+// creds file: the user JWT followed by the nkey seed).
+func credentialsOption(creds []byte) (nats.Option, error) {
+	jwt, err := nkeys.ParseDecoratedJWT(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	// the content is returned as is if not decorated
+	if jwt == string(creds) {
+		return nil, errors.New("no user JWT found in credentials")
+	}
+
+	kp, err := nkeys.ParseDecoratedUserNKey(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return nats.UserJWT(
+		func() (string, error) {
+			return jwt, nil
+		},
+		kp.Sign,
+	), nil
+}
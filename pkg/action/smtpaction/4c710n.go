@@ -0,0 +1,327 @@
+package smtpaction
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"time"
+
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	"github.com/ccamel/kynaptik/pkg/kynaptik"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultPort specifies the default port of the SMTP servers.
+	DefaultPort = "25"
+	// DefaultSecurePort specifies the default port of the SMTP servers over (implicit) TLS.
+	DefaultSecurePort = "465"
+	// DefaultLocalName specifies the default name the client identifies itself with (EHLO).
+	DefaultLocalName = "localhost"
+	// StartTLSAuto specifies that STARTTLS is used if supported by the server.
+	StartTLSAuto = "auto"
+	// StartTLSAlways specifies that STARTTLS is required.
+	StartTLSAlways = "always"
+	// StartTLSNever specifies that STARTTLS is never used.
+	StartTLSNever = "never"
+)
+
+type Action struct {
+	URI         string            `yaml:"uri" validate:"required,uri,scheme=smtp|scheme=smtps"`
+	From        string            `yaml:"from" validate:"required"`
+	To          []string          `yaml:"to"`
+	Cc          []string          `yaml:"cc"`
+	Bcc         []string          `yaml:"bcc"`
+	ReplyTo     string            `yaml:"replyTo"`
+	Subject     string            `yaml:"subject"`
+	Headers     map[string]string `yaml:"headers"`
+	Text        string            `yaml:"text"`
+	HTML        string            `yaml:"html"`
+	Attachments []Attachment      `yaml:"attachments" validate:"dive"`
+	Options     Options           `yaml:"options"`
+}
+
+// Attachment is a file attached to the message.
+type Attachment struct {
+	// Filename is the name of the file.
+	Filename string `yaml:"filename" validate:"required"`
+	// ContentType is the media type of the file. Guessed from the extension of the file name by default.
+	ContentType string `yaml:"contentType"`
+	// Content is the content of the file, base64 encoded.
+	Content string `yaml:"content"`
+}
+
+type Options struct {
+	StartTLS  string                `yaml:"startTLS" validate:"omitempty,oneof=auto always never"`
+	Auth      AuthOptions           `yaml:"auth"`
+	TLS       httpaction.TLSOptions `yaml:"tls"`
+	LocalName string                `yaml:"localName"`
+}
+
+type AuthOptions struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Response is the reply of the SMTP server to the message, as exposed in the evaluation environment. The fields are
+// exposed under their (lowercase) expr names, e.g. response.statusCode, the Go names being still supported.
+type Response struct {
+	// StatusCode is the reply code of the server, e.g. 250.
+	StatusCode int `expr:"statusCode"`
+	// Message is the reply message of the server, e.g. "2.0.0 OK queued as 1234".
+	Message string `expr:"message"`
+}
+
+func init() {
+	kynaptik.RegisterActionKind(kynaptik.ActionKind{
+		Name:          "smtp",
+		Schemes:       []string{"smtp", "smtps"},
+		ConfigFactory: ConfigFactory,
+		ActionFactory: ActionFactory,
+	})
+}
+
+// ConfigFactory returns the default configuration for the action.
+func ConfigFactory() kynaptik.Config {
+	return kynaptik.Config{
+		// PreCondition specifies the default pre-condition value. Here, we accept everything.
+		PreCondition: "true",
+		// PostCondition specifies the default post-condition to satisfy in order to consider the message sent.
+		// Here, we consider a reply code 2xx to be successful.
+		PostCondition: "response.statusCode >= 200 and response.statusCode < 300",
+	}
+}
+
+// ActionFactory returns a new action with default values.
+func ActionFactory() kynaptik.Action {
+	return &Action{
+		Headers: map[string]string{},
+		Options: Options{
+			StartTLS:  StartTLSAuto,
+			LocalName: DefaultLocalName,
+		},
+	}
+}
+
+func (a *Action) GetURI() string {
+	return a.URI
+}
+
+func (a *Action) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("uri", a.URI).
+		Str("from", a.From).
+		Strs("to", a.To).
+		Strs("cc", a.Cc).
+		Strs("bcc", a.Bcc).
+		Str("subject", a.Subject).
+		Int("attachments", len(a.Attachments))
+}
+
+func (a *Action) DoAction(ctx context.Context) (interface{}, error) {
+	u, err := url.Parse(a.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(a.From)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect from address '%s': %w", a.From, err)
+	}
+
+	recipients, err := a.recipients()
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := a.compose(from, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := a.Options.TLS.ToTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ServerName = u.Hostname()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", address(u))
+	if err != nil {
+		return nil, err
+	}
+
+	// the connection is closed as soon as the context is done, interrupting the exchange with the server
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	response, err := a.send(conn, u, tlsConfig, from.Address, recipients, message)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		// the message is refused by the server, which is left to the post-condition to decide
+		log.Ctx(ctx).
+			Warn().
+			Int("code", replyErr.Code).
+			Str("message", replyErr.Msg).
+			Msg("message refused by the SMTP server")
+
+		return &Response{StatusCode: replyErr.Code, Message: replyErr.Msg}, nil
+	}
+
+	return response, err
+}
+
+// recipients returns the addresses of all the recipients of the message (to, cc and bcc).
+func (a *Action) recipients() ([]string, error) {
+	var recipients []string
+
+	for _, list := range [][]string{a.To, a.Cc, a.Bcc} {
+		for _, s := range list {
+			addr, err := mail.ParseAddress(s)
+			if err != nil {
+				return nil, fmt.Errorf("incorrect recipient address '%s': %w", s, err)
+			}
+
+			recipients = append(recipients, addr.Address)
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipient specified")
+	}
+
+	return recipients, nil
+}
+
+// address returns the address of the SMTP server of the given uri.
+func address(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = DefaultPort
+		if u.Scheme == "smtps" {
+			port = DefaultSecurePort
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// send sends the message through the given connection (directly over TLS for the smtps scheme), returning the reply
+// of the server to the message.
+func (a *Action) send(
+	conn net.Conn,
+	u *url.URL,
+	tlsConfig *tls.Config,
+	from string,
+	recipients []string,
+	message []byte,
+) (*Response, error) {
+	if u.Scheme == "smtps" {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, u.Hostname())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	defer client.Close()
+
+	localName := a.Options.LocalName
+	if localName == "" {
+		localName = DefaultLocalName
+	}
+
+	if err := client.Hello(localName); err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "smtp" && a.Options.StartTLS != StartTLSNever {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return nil, err
+			}
+		} else if a.Options.StartTLS == StartTLSAlways {
+			return nil, errors.New("STARTTLS not supported by the server")
+		}
+	}
+
+	if a.Options.Auth.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return nil, errors.New("authentication not supported by the server")
+		}
+
+		if err := client.Auth(smtp.PlainAuth("", a.Options.Auth.Username, a.Options.Auth.Password, u.Hostname())); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return nil, err
+	}
+
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return nil, err
+		}
+	}
+
+	// the DATA command is issued directly (and not through smtp.Client.Data) to keep track of the final reply
+	id, err := client.Text.Cmd("DATA")
+	if err != nil {
+		return nil, err
+	}
+
+	client.Text.StartResponse(id)
+	_, _, err = client.Text.ReadResponse(354)
+	client.Text.EndResponse(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := client.Text.DotWriter()
+	if _, err := w.Write(message); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	code, msg, err := client.Text.ReadResponse(250)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = client.Quit()
+
+	return &Response{StatusCode: code, Message: msg}, nil
+}
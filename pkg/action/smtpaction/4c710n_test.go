@@ -0,0 +1,625 @@
+package smtpaction
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/ccamel/kynaptik/pkg/action/httpaction"
+	"github.com/rs/zerolog/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const etcPath = "../../../etc/"
+
+// envelope is a message received by the fake SMTP server.
+type envelope struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string
+}
+
+// fakeServer is a (minimal) in-process SMTP server, recording the messages received.
+type fakeServer struct {
+	listener net.Listener
+	// tlsConfig enables STARTTLS, if specified
+	tlsConfig *tls.Config
+	// username and password enable the authentication (PLAIN), if specified
+	username string
+	password string
+
+	mu        sync.Mutex
+	envelopes []envelope
+}
+
+func newFakeServer(implicitTLS bool, startTLS bool, username, password string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	s := &fakeServer{listener: listener, username: username, password: password}
+
+	if implicitTLS || startTLS {
+		cert, err := tls.LoadX509KeyPair(path.Join(etcPath, "cert/leaf.pem"), path.Join(etcPath, "cert/leaf.key"))
+		So(err, ShouldBeNil)
+
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		if implicitTLS {
+			s.listener = tls.NewListener(listener, tlsConfig)
+		} else {
+			s.tlsConfig = tlsConfig
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn, implicitTLS)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeServer) close() {
+	_ = s.listener.Close()
+}
+
+func (s *fakeServer) received() []envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.envelopes
+}
+
+func (s *fakeServer) serve(conn net.Conn, secure bool) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	e := envelope{tls: secure}
+
+	_ = tp.PrintfLine("220 localhost fake ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			extensions := []string{"localhost", "8BITMIME"}
+			if s.tlsConfig != nil && !e.tls {
+				extensions = append(extensions, "STARTTLS")
+			}
+
+			if s.username != "" {
+				extensions = append(extensions, "AUTH PLAIN")
+			}
+
+			for i, extension := range extensions {
+				sep := "-"
+				if i == len(extensions)-1 {
+					sep = " "
+				}
+
+				_ = tp.PrintfLine("250%s%s", sep, extension)
+			}
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 2.0.0 ready to start TLS")
+
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			e.tls = true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			if string(credentials) != "\x00"+s.username+"\x00"+s.password {
+				_ = tp.PrintfLine("535 5.7.8 authentication credentials invalid")
+				continue
+			}
+
+			e.auth = s.username
+			_ = tp.PrintfLine("235 2.7.0 authentication successful")
+		case "MAIL":
+			e.from = arg[strings.Index(arg, "<")+1 : strings.Index(arg, ">")]
+			_ = tp.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			to := arg[strings.Index(arg, "<")+1 : strings.Index(arg, ">")]
+			if strings.HasPrefix(to, "unknown") {
+				_ = tp.PrintfLine("550 5.1.1 mailbox unavailable")
+				continue
+			}
+
+			e.to = append(e.to, to)
+			_ = tp.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			e.data = string(data)
+
+			s.mu.Lock()
+			s.envelopes = append(s.envelopes, e)
+			s.mu.Unlock()
+
+			_ = tp.PrintfLine("250 2.0.0 OK queued as 42")
+		case "QUIT":
+			_ = tp.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 5.5.2 command not recognized")
+		}
+	}
+}
+
+// readMessage parses the given message, returning its headers along with its (MIME) parts, decoded.
+func readMessage(data string) (mail.Header, map[string]string) {
+	message, err := mail.ReadMessage(strings.NewReader(data))
+	So(err, ShouldBeNil)
+
+	parts := map[string]string{}
+
+	var read func(contentType string, r *bufio.Reader, encoding string)
+	read = func(contentType string, r *bufio.Reader, encoding string) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		So(err, ShouldBeNil)
+
+		if strings.HasPrefix(mediaType, "multipart/") {
+			mr := multipart.NewReader(r, params["boundary"])
+
+			for {
+				p, err := mr.NextRawPart()
+				if err != nil {
+					break
+				}
+
+				read(p.Header.Get("Content-Type"), bufio.NewReader(p), p.Header.Get("Content-Transfer-Encoding"))
+			}
+
+			return
+		}
+
+		content, err := ioutil.ReadAll(r)
+		So(err, ShouldBeNil)
+
+		switch encoding {
+		case "base64":
+			content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(content), "\r\n", ""))
+			So(err, ShouldBeNil)
+		case "quoted-printable":
+			content, err = ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(content)))
+			So(err, ShouldBeNil)
+		}
+
+		parts[mediaType] = string(content)
+	}
+
+	read(message.Header.Get("Content-Type"), bufio.NewReader(message.Body), message.Header.Get("Content-Transfer-Encoding"))
+
+	return message.Header, parts
+}
+
+type smtpFixtureSupplier func() smtpFixture
+
+type smtpFixture struct {
+	ctx        context.Context
+	smtpAction Action
+	// arrange is a function which initializes the fixture and in returns provides a function which finalizes (clean)
+	// that fixture when called
+	arrange func(c C, ctx context.Context) func()
+	// assert is a function performing the assertions on the result
+	assert func(interface{}, error)
+}
+
+// smtpFixtureProvider returns a fixture sending the given action to a fake server (started according to the given
+// options), the URI of the action being prefixed by the scheme and the address of the server.
+func smtpFixtureProvider(
+	scheme string,
+	implicitTLS, startTLS bool,
+	username, password string,
+	action Action,
+	assert func(s *fakeServer, res interface{}, err error),
+) smtpFixtureSupplier {
+	return func() smtpFixture {
+		s := newFakeServer(implicitTLS, startTLS, username, password)
+		rootPem, _ := ioutil.ReadFile(path.Join(etcPath, "cert/root.pem"))
+
+		action.URI = fmt.Sprintf("%s://localhost:%d", scheme, s.port())
+		action.Options.TLS = httpaction.TLSOptions{CACertData: string(rootPem)}
+
+		return smtpFixture{
+			ctx:        context.Background(),
+			smtpAction: action,
+			arrange: func(c C, ctx context.Context) func() {
+				return s.close
+			},
+			assert: func(res interface{}, err error) {
+				assert(s, res, err)
+			},
+		}
+	}
+}
+
+func smtpSuccessfulTextMessageFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:    "Kynaptik <kynaptik@example.com>",
+			To:      []string{"on-call@example.com", "Jöhn <john@example.com>"},
+			Cc:      []string{"team@example.com"},
+			Bcc:     []string{"audit@example.com"},
+			Subject: "Incident #42 – database down",
+			Headers: map[string]string{"X-Priority": "1"},
+			Text:    "The database is down.\nPlease check.",
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{StatusCode: 250, Message: "2.0.0 OK queued as 42"})
+
+			envelopes := s.received()
+			So(envelopes, ShouldHaveLength, 1)
+			So(envelopes[0].from, ShouldEqual, "kynaptik@example.com")
+			So(envelopes[0].to, ShouldResemble, []string{"on-call@example.com", "john@example.com", "team@example.com", "audit@example.com"})
+			So(envelopes[0].tls, ShouldBeFalse)
+
+			header, parts := readMessage(envelopes[0].data)
+			subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+			So(err, ShouldBeNil)
+			So(subject, ShouldEqual, "Incident #42 – database down")
+			So(header.Get("From"), ShouldEqual, `"Kynaptik" <kynaptik@example.com>`)
+			So(header.Get("To"), ShouldEqual, `<on-call@example.com>, =?utf-8?q?J=C3=B6hn?= <john@example.com>`)
+			So(header.Get("Cc"), ShouldEqual, `<team@example.com>`)
+			So(header.Get("Bcc"), ShouldBeEmpty)
+			So(header.Get("X-Priority"), ShouldEqual, "1")
+			So(header.Get("Message-Id"), ShouldEndWith, "@example.com>")
+			So(parts, ShouldResemble, map[string]string{"text/plain": "The database is down.\nPlease check.\n"})
+		})()
+}
+
+func smtpSuccessfulMultipartMessageFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Subject: "Report",
+			Text:    "See the report attached.",
+			HTML:    "<p>See the report attached.</p>",
+			Attachments: []Attachment{
+				{Filename: "report.json", Content: base64.StdEncoding.EncodeToString([]byte(`{"id":42}`))},
+				{Filename: "data", Content: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x42}, 100))},
+			},
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{StatusCode: 250, Message: "2.0.0 OK queued as 42"})
+
+			envelopes := s.received()
+			So(envelopes, ShouldHaveLength, 1)
+
+			header, parts := readMessage(envelopes[0].data)
+			So(header.Get("Content-Type"), ShouldStartWith, "multipart/mixed; boundary=")
+			So(parts, ShouldResemble, map[string]string{
+				"text/plain":               "See the report attached.",
+				"text/html":                "<p>See the report attached.</p>",
+				"application/json":         `{"id":42}`,
+				"application/octet-stream": string(bytes.Repeat([]byte{0x42}, 100)),
+			})
+		})()
+}
+
+func smtpSuccessfulStartTLSWithAuthFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, true, "kynaptik", "s3cr3t",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Subject: "Hello",
+			Text:    "Hello",
+			Options: Options{
+				StartTLS: StartTLSAlways,
+				Auth:     AuthOptions{Username: "kynaptik", Password: "s3cr3t"},
+			},
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{StatusCode: 250, Message: "2.0.0 OK queued as 42"})
+
+			envelopes := s.received()
+			So(envelopes, ShouldHaveLength, 1)
+			So(envelopes[0].tls, ShouldBeTrue)
+			So(envelopes[0].auth, ShouldEqual, "kynaptik")
+		})()
+}
+
+func smtpSuccessfulImplicitTLSFixture() smtpFixture {
+	return smtpFixtureProvider("smtps", true, false, "", "",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Subject: "Hello",
+			Text:    "Hello",
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{StatusCode: 250, Message: "2.0.0 OK queued as 42"})
+
+			envelopes := s.received()
+			So(envelopes, ShouldHaveLength, 1)
+			So(envelopes[0].tls, ShouldBeTrue)
+		})()
+}
+
+func smtpFailedAuthFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, true, "kynaptik", "s3cr3t",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Subject: "Hello",
+			Text:    "Hello",
+			Options: Options{
+				Auth: AuthOptions{Username: "kynaptik", Password: "wrong"},
+			},
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{StatusCode: 535, Message: "5.7.8 authentication credentials invalid"})
+			So(s.received(), ShouldBeEmpty)
+		})()
+}
+
+func smtpRejectedRecipientFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com", "unknown@example.com"},
+			Subject: "Hello",
+			Text:    "Hello",
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+			So(res, ShouldResemble, &Response{StatusCode: 550, Message: "5.1.1 mailbox unavailable"})
+			So(s.received(), ShouldBeEmpty)
+		})()
+}
+
+func smtpStartTLSNotSupportedFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Subject: "Hello",
+			Text:    "Hello",
+			Options: Options{StartTLS: StartTLSAlways},
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "STARTTLS not supported by the server")
+		})()
+}
+
+func smtpIncorrectAttachmentFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:        "kynaptik@example.com",
+			To:          []string{"on-call@example.com"},
+			Attachments: []Attachment{{Filename: "report.json", Content: "not base64!"}},
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "incorrect content of attachment 'report.json': illegal base64 data at input byte 3")
+		})()
+}
+
+func smtpHeaderInjectionFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Headers: map[string]string{"X-Incident": "42\r\nBcc: attacker@example.com"},
+			Text:    "Hello",
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(err, ShouldBeNil)
+
+			envelopes := s.received()
+			So(envelopes, ShouldHaveLength, 1)
+			So(envelopes[0].to, ShouldResemble, []string{"on-call@example.com"})
+
+			header, _ := readMessage(envelopes[0].data)
+			So(header.Get("Bcc"), ShouldBeEmpty)
+
+			incident, err := new(mime.WordDecoder).DecodeHeader(header.Get("X-Incident"))
+			So(err, ShouldBeNil)
+			So(incident, ShouldEqual, "42\r\nBcc: attacker@example.com")
+		})()
+}
+
+func smtpIncorrectHeaderNameFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From:    "kynaptik@example.com",
+			To:      []string{"on-call@example.com"},
+			Headers: map[string]string{"X-Incident: 42\r\nBcc": "attacker@example.com"},
+			Text:    "Hello",
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "incorrect header name 'X-Incident: 42\r\nBcc'")
+			So(s.received(), ShouldBeEmpty)
+		})()
+}
+
+func smtpNoRecipientFixture() smtpFixture {
+	return smtpFixtureProvider("smtp", false, false, "", "",
+		Action{
+			From: "kynaptik@example.com",
+			Text: "Hello",
+		},
+		func(s *fakeServer, res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "no recipient specified")
+		})()
+}
+
+func smtpTimeoutFixture() smtpFixture {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+
+	return smtpFixture{
+		ctx: ctx,
+		smtpAction: Action{
+			URI:  fmt.Sprintf("smtp://127.0.0.1:%d", listener.Addr().(*net.TCPAddr).Port),
+			From: "kynaptik@example.com",
+			To:   []string{"on-call@example.com"},
+		},
+		arrange: func(c C, ctx context.Context) func() {
+			// the server accepts the connection, but never greets the client
+			go func() {
+				conn, err := listener.Accept()
+				if err == nil {
+					<-ctx.Done()
+					_ = conn.Close()
+				}
+			}()
+
+			return func() {
+				cancel()
+				_ = listener.Close()
+			}
+		},
+		assert: func(res interface{}, err error) {
+			So(res, ShouldBeNil)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+		},
+	}
+}
+
+func TestSMTPFunction(t *testing.T) {
+	Convey("Considering the SMTP function", t, func(c C) {
+		fixtures := []smtpFixtureSupplier{
+			smtpSuccessfulTextMessageFixture,
+			smtpSuccessfulMultipartMessageFixture,
+			smtpSuccessfulStartTLSWithAuthFixture,
+			smtpSuccessfulImplicitTLSFixture,
+			smtpFailedAuthFixture,
+			smtpRejectedRecipientFixture,
+			smtpStartTLSNotSupportedFixture,
+			smtpIncorrectAttachmentFixture,
+			smtpHeaderInjectionFixture,
+			smtpIncorrectHeaderNameFixture,
+			smtpNoRecipientFixture,
+			smtpTimeoutFixture,
+		}
+
+		for _, fixtureSupplier := range fixtures {
+			Convey(fmt.Sprintf("Given the fixture supplier '%s'", runtime.FuncForPC(reflect.ValueOf(fixtureSupplier).Pointer()).Name()), func() {
+				l := log.With().Logger()
+
+				fixture := fixtureSupplier()
+				ctx := l.WithContext(fixture.ctx)
+				teardown := fixture.arrange(c, ctx)
+				defer teardown()
+
+				Convey("When calling the function", func() {
+					res, err := fixture.smtpAction.DoAction(ctx)
+
+					Convey("Then post-conditions shall be satisfied", func() {
+						fixture.assert(res, err)
+					})
+				})
+			})
+		}
+	})
+}
+
+func TestSMTPActionFactory(t *testing.T) {
+	Convey("When calling 'ActionFactory' function", t, func(c C) {
+		action := ActionFactory()
+
+		Convey("Then action created is an Action with default values", func() {
+			So(action, ShouldHaveSameTypeAs, &Action{})
+			So(action.GetURI(), ShouldEqual, "")
+			So(action.(*Action).Headers, ShouldResemble, map[string]string{})
+			So(action.(*Action).Options.StartTLS, ShouldEqual, StartTLSAuto)
+			So(action.(*Action).Options.LocalName, ShouldEqual, DefaultLocalName)
+		})
+
+		Convey("And created action can be marshalled into a log without error", func() {
+			log.
+				Info().
+				Object("action", action).
+				Msg("action built")
+		})
+	})
+}
+
+func TestSMTPConfigFactory(t *testing.T) {
+	Convey("When calling 'ConfigFactory' function", t, func(c C) {
+		config := ConfigFactory()
+
+		Convey("Then configuration provided shall be the expected one", func() {
+			So(config.PreCondition, ShouldEqual, "true")
+			So(config.PostCondition, ShouldEqual, "response.statusCode >= 200 and response.statusCode < 300")
+		})
+
+		Convey("Then the postCondition shall be evaluated against the response, by its lowercase and Go field names", func() {
+			for _, postCondition := range []string{config.PostCondition, `response.StatusCode == 250 and response.Message != ""`} {
+				program, err := expr.Compile(postCondition)
+				So(err, ShouldBeNil)
+
+				satisfied, err := util.EvaluatePredicateExpression(program, map[string]interface{}{
+					"response": &Response{StatusCode: 250, Message: "2.0.0 OK queued as 42"},
+				})
+				So(err, ShouldBeNil)
+				So(satisfied, ShouldBeTrue)
+			}
+		})
+	})
+}
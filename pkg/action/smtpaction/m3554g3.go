@@ -0,0 +1,287 @@
+package smtpaction
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxLineLength specifies the maximum length of the lines of the base64 encoded attachments (RFC 2045).
+const maxLineLength = 76
+
+// compose returns the content of the message (RFC 5322), i.e. the headers and the (MIME) body.
+func (a *Action) compose(from *mail.Address, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+
+	header, err := a.header(from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := a.body()
+	if err != nil {
+		return nil, err
+	}
+
+	// the headers of the (top level) part are part of the headers of the message
+	for k, v := range body.header {
+		header[k] = v
+	}
+
+	writeHeader(&buf, header)
+	buf.Write(body.body)
+
+	return buf.Bytes(), nil
+}
+
+// header returns the headers of the message, the bcc recipients being excluded.
+func (a *Action) header(from *mail.Address, now time.Time) (textproto.MIMEHeader, error) {
+	header := textproto.MIMEHeader{}
+
+	for k, v := range a.Headers {
+		if !validHeaderName(k) {
+			return nil, fmt.Errorf("incorrect header name '%s'", k)
+		}
+
+		// encoded as the subject, the line breaks of the value (if any) cannot inject headers
+		header.Set(k, mime.QEncoding.Encode("utf-8", v))
+	}
+
+	header.Set("From", from.String())
+
+	for name, list := range map[string][]string{"To": a.To, "Cc": a.Cc} {
+		if len(list) == 0 {
+			continue
+		}
+
+		addresses, err := formatAddresses(list)
+		if err != nil {
+			return nil, err
+		}
+
+		header.Set(name, addresses)
+	}
+
+	if a.ReplyTo != "" {
+		addresses, err := formatAddresses([]string{a.ReplyTo})
+		if err != nil {
+			return nil, err
+		}
+
+		header.Set("Reply-To", addresses)
+	}
+
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", a.Subject))
+	header.Set("Date", now.Format(time.RFC1123Z))
+	header.Set("Message-Id", messageID(from, now))
+	header.Set("Mime-Version", "1.0")
+
+	return header, nil
+}
+
+// body returns the (MIME) body of the message: the text and/or html parts (as alternatives), possibly followed by the
+// attachments.
+func (a *Action) body() (part, error) {
+	var parts []part
+
+	if a.Text != "" || a.HTML == "" {
+		parts = append(parts, textPart("text/plain; charset=utf-8", a.Text))
+	}
+
+	if a.HTML != "" {
+		parts = append(parts, textPart("text/html; charset=utf-8", a.HTML))
+	}
+
+	content := parts[0]
+
+	if len(parts) > 1 {
+		var err error
+		if content, err = multipartPart("alternative", parts); err != nil {
+			return part{}, err
+		}
+	}
+
+	if len(a.Attachments) > 0 {
+		parts = []part{content}
+
+		for _, attachment := range a.Attachments {
+			p, err := attachmentPart(attachment)
+			if err != nil {
+				return part{}, err
+			}
+
+			parts = append(parts, p)
+		}
+
+		var err error
+		if content, err = multipartPart("mixed", parts); err != nil {
+			return part{}, err
+		}
+	}
+
+	return content, nil
+}
+
+// part is a MIME part.
+type part struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// textPart returns the part of the given text, quoted-printable encoded.
+func textPart(contentType, text string) part {
+	var buf bytes.Buffer
+
+	w := quotedprintable.NewWriter(&buf)
+	_, _ = io.WriteString(w, text)
+	_ = w.Close()
+
+	return part{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: buf.Bytes(),
+	}
+}
+
+// attachmentPart returns the part of the given attachment, base64 encoded.
+func attachmentPart(attachment Attachment) (part, error) {
+	content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(attachment.Content))
+	if err != nil {
+		return part{}, fmt.Errorf("incorrect content of attachment '%s': %w", attachment.Filename, err)
+	}
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(attachment.Filename))
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+
+	var buf bytes.Buffer
+
+	for len(encoded) > maxLineLength {
+		buf.WriteString(encoded[:maxLineLength] + "\r\n")
+		encoded = encoded[maxLineLength:]
+	}
+
+	buf.WriteString(encoded)
+
+	return part{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		},
+		body: buf.Bytes(),
+	}, nil
+}
+
+// multipartPart returns the multipart (of the given subtype) part containing the given parts.
+func multipartPart(subtype string, parts []part) (part, error) {
+	var buf bytes.Buffer
+
+	w := multipart.NewWriter(&buf)
+
+	for _, p := range parts {
+		pw, err := w.CreatePart(p.header)
+		if err != nil {
+			return part{}, err
+		}
+
+		if _, err := pw.Write(p.body); err != nil {
+			return part{}, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return part{}, err
+	}
+
+	return part{
+		header: textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf("multipart/%s; boundary=%s", subtype, w.Boundary())},
+		},
+		body: buf.Bytes(),
+	}, nil
+}
+
+// writeHeader writes the given headers (sorted by name), followed by the blank line separating them from the body.
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range header[name] {
+			fmt.Fprintf(buf, "%s: %s\r\n", name, v)
+		}
+	}
+
+	buf.WriteString("\r\n")
+}
+
+// validHeaderName returns true if the given name is a valid header field name (RFC 5322), i.e. made of printable
+// US-ASCII characters except the colon.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if r < '!' || r > '~' || r == ':' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatAddresses returns the given addresses as a header value.
+func formatAddresses(list []string) (string, error) {
+	addresses := make([]string, 0, len(list))
+
+	for _, s := range list {
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return "", fmt.Errorf("incorrect address '%s': %w", s, err)
+		}
+
+		addresses = append(addresses, addr.String())
+	}
+
+	return strings.Join(addresses, ", "), nil
+}
+
+// messageID returns a new (unique) identifier for a message sent by the given address.
+func messageID(from *mail.Address, now time.Time) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from.Address, "@"); i >= 0 {
+		domain = from.Address[i+1:]
+	}
+
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+
+	return fmt.Sprintf("<%d.%s@%s>", now.UnixNano(), hex.EncodeToString(nonce), domain)
+}
@@ -0,0 +1,315 @@
+package kynaptik
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/gamegos/jsend"
+	"github.com/justinas/alice"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	// DefaultOrderingTTL specifies the default time (in ms) the sequence of an idle partition is remembered: 24h.
+	DefaultOrderingTTL = 86400000
+	// partitionSweepInterval specifies the minimum interval between two evictions of the expired partitions.
+	partitionSweepInterval = time.Minute
+)
+
+// Ordering specifies how the events are ordered, the events of a same partition (given by a key) being processed one
+// at a time (within the process), and the stale ones (given by a sequence) being possibly dropped.
+type Ordering struct {
+	// Key specifies the expression giving the key of the partition of the event, e.g. `data.orderId`.
+	// No ordering if empty.
+	Key string `yaml:"key"`
+	// Sequence specifies the expression giving the sequence of the event within its partition (a number or a string),
+	// e.g. `data.version`. An event whose sequence is lower than the one of the last event processed is dropped.
+	// No event is dropped if empty.
+	Sequence string `yaml:"sequence"`
+	// TTL specifies the time (in ms) the sequence of a partition is remembered once no more event of the partition is
+	// processed. 24h by default.
+	TTL time.Duration `yaml:"ttl" validate:"gte=0"`
+}
+
+// enabled returns true if the ordering is enabled.
+func (o Ordering) enabled() bool {
+	return o.Key != ""
+}
+
+// ttl returns the time the sequence of an idle partition is remembered.
+func (o Ordering) ttl() time.Duration {
+	if o.TTL == 0 {
+		return DefaultOrderingTTL * time.Millisecond
+	}
+
+	return o.TTL * time.Millisecond
+}
+
+// MarshalZerologObject produces logs related to the ordering.
+func (o Ordering) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("key", o.Key).
+		Str("sequence", o.Sequence).
+		Dur("ttl", o.ttl())
+}
+
+// partition serializes the processing of the events sharing a same key, keeping track of the sequence of the last
+// event processed.
+type partition struct {
+	sem  chan struct{}
+	refs int
+	last interface{}
+	// expiresAt is the time the partition expires at, once no more event refers to it.
+	expiresAt time.Time
+}
+
+// idle returns true if no more event refers to the partition, and the partition expired.
+func (p *partition) idle(now time.Time) bool {
+	return p.refs == 0 && !now.Before(p.expiresAt)
+}
+
+// partitionTable contains the partitions, by key. A partition is removed once no more event refers to it, unless it
+// keeps track of a sequence, in which case it is removed once expired.
+type partitionTable struct {
+	mu         sync.Mutex
+	partitions map[string]*partition
+	nextSweep  time.Time
+}
+
+func newPartitionTable() *partitionTable {
+	return &partitionTable{partitions: map[string]*partition{}}
+}
+
+var partitions = newPartitionTable()
+
+// acquire returns the partition of the given key, referenced by the caller.
+func (t *partitionTable) acquire(key string, now time.Time) *partition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	p, ok := t.partitions[key]
+	if !ok || p.idle(now) {
+		p = &partition{sem: make(chan struct{}, 1)}
+		t.partitions[key] = p
+	}
+
+	p.refs++
+
+	return p
+}
+
+// release releases the reference of the caller on the partition of the given key, the partition expiring at the
+// given time if no more event refers to it.
+func (t *partitionTable) release(key string, p *partition, expiresAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p.refs--
+
+	if p.refs > 0 {
+		return
+	}
+
+	if p.last == nil {
+		delete(t.partitions, key)
+		return
+	}
+
+	p.expiresAt = expiresAt
+}
+
+// sweep removes the expired partitions no more referred to, at most once per partitionSweepInterval. The caller shall
+// hold the lock of the table.
+func (t *partitionTable) sweep(now time.Time) {
+	if now.Before(t.nextSweep) {
+		return
+	}
+
+	for key, p := range t.partitions {
+		if p.idle(now) {
+			delete(t.partitions, key)
+		}
+	}
+
+	t.nextSweep = now.Add(partitionSweepInterval)
+}
+
+// advance keeps track of the sequence of the last event processed in the partition. The caller shall hold the
+// partition.
+func (t *partitionTable) advance(p *partition, sequence interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p.last = sequence
+}
+
+// compareSequences compares two sequences, either numbers or strings, returning -1, 0 or 1.
+func compareSequences(a, b interface{}) (int, error) {
+	toFloat := func(v interface{}) (float64, bool) {
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+
+		return 0, false
+	}
+
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+	}
+
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			switch {
+			case sa < sb:
+				return -1, nil
+			case sa > sb:
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+	}
+
+	return 0, fmt.Errorf("incomparable sequences %v (%T) and %v (%T)", a, a, b, b)
+}
+
+// orderingHandler serializes the processing of the events of a same partition, dropping the stale ones. The wait for
+// the partition is bounded by the timeout of the function (if any), including when the actions are performed in the
+// background (asynchronous mode).
+func orderingHandler() alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config := r.Context().Value(ctxKeyConfig).(Config)
+			ordering := config.Ordering
+
+			if !ordering.enabled() {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
+			env := r.Context().Value(ctxKeyEnv).(environment)
+
+			sendError := func(code int, stage string, err error) {
+				_, _ = jsend.
+					Wrap(w).
+					Status(code).
+					Message(err.Error()).
+					Data(&ResponseData{Stage: stage}).
+					Send()
+			}
+
+			evaluate := func(source string) (interface{}, error) {
+				program, err := programs.compile(source)
+				if err != nil {
+					sendError(http.StatusServiceUnavailable, "parse-ordering", err)
+					return nil, err
+				}
+
+				value, err := util.EvaluateExpression(program, env)
+				if err != nil {
+					sendError(http.StatusBadRequest, "ordering", err)
+					return nil, err
+				}
+
+				return value, nil
+			}
+
+			value, err := evaluate(ordering.Key)
+			if err != nil {
+				return
+			}
+
+			if value == nil || value == "" {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			var sequence interface{}
+			if ordering.Sequence != "" {
+				if sequence, err = evaluate(ordering.Sequence); err != nil {
+					return
+				}
+			}
+
+			// the partitions are scoped to the function
+			key := fmt.Sprintf("%s/%v", functionKey(r), value)
+			p := partitions.acquire(key, time.Now())
+
+			defer func() { partitions.release(key, p, time.Now().Add(ordering.ttl())) }()
+
+			ctx := r.Context()
+			cancel := func() { /* noop by default */ }
+
+			if config.Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, config.Timeout*time.Millisecond)
+			}
+			defer cancel()
+
+			select {
+			case p.sem <- struct{}{}:
+			case <-ctx.Done():
+				sendError(http.StatusServiceUnavailable, "ordering", fmt.Errorf("partition '%v' not available: %w", value, ctx.Err()))
+				return
+			}
+
+			defer func() { <-p.sem }()
+
+			if sequence != nil && p.last != nil {
+				c, err := compareSequences(sequence, p.last)
+				if err != nil {
+					sendError(http.StatusBadRequest, "ordering", err)
+					return
+				}
+
+				if c < 0 {
+					hlog.
+						FromRequest(r).
+						Info().
+						Str("partition", fmt.Sprint(value)).
+						Interface("sequence", sequence).
+						Interface("last", p.last).
+						Msg("🗑️ stale event dropped")
+
+					_, _ = jsend.
+						Wrap(w).
+						Status(http.StatusOK).
+						Message(fmt.Sprintf("stale event dropped (sequence %v lower than %v)", sequence, p.last)).
+						Data(&ResponseData{Stage: "ordering"}).
+						Send()
+					return
+				}
+			}
+
+			rw := &recordingResponseWriter{ResponseWriter: w}
+
+			Ͱ.ServeHTTP(rw, r)
+
+			// only the events successfully processed advance the sequence, the other ones being possibly retried
+			if sequence != nil && rw.statusCode >= 200 && rw.statusCode < 300 {
+				partitions.advance(p, sequence)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package kynaptik
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompareSequences(t *testing.T) {
+	Convey("Considering the comparison of sequences", t, func(c C) {
+		cases := []struct {
+			a, b     interface{}
+			expected int
+			err      string
+		}{
+			{a: 1, b: 2, expected: -1},
+			{a: 2.0, b: 1, expected: 1},
+			{a: int64(3), b: 3.0, expected: 0},
+			{a: "2021-01-02T00:00:00Z", b: "2021-01-01T00:00:00Z", expected: 1},
+			{a: "a", b: "a", expected: 0},
+			{a: "1", b: 1, err: "incomparable sequences 1 (string) and 1 (int)"},
+			{a: true, b: false, err: "incomparable sequences true (bool) and false (bool)"},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When comparing %v (%T) and %v (%T)", tc.a, tc.a, tc.b, tc.b), func() {
+				result, err := compareSequences(tc.a, tc.b)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.err == "" {
+						So(err, ShouldBeNil)
+						So(result, ShouldEqual, tc.expected)
+					} else {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					}
+				})
+			})
+		}
+	})
+}
+
+func TestPartitionTable(t *testing.T) {
+	Convey("Considering a table of partitions", t, func(c C) {
+		table := newPartitionTable()
+		now := time.Unix(1600000000, 0)
+		ttl := time.Hour
+
+		Convey("When a partition not keeping track of a sequence is released", func() {
+			p := table.acquire("a", now)
+			table.release("a", p, now.Add(ttl))
+
+			Convey("Then it shall be removed", func() {
+				So(table.partitions, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a partition keeping track of a sequence is released", func() {
+			p := table.acquire("a", now)
+			table.advance(p, 3)
+			table.release("a", p, now.Add(ttl))
+
+			Convey("Then it shall be kept until it expires", func() {
+				So(table.acquire("a", now.Add(ttl/2)).last, ShouldEqual, 3)
+			})
+
+			Convey("Then its sequence shall be forgotten once expired", func() {
+				So(table.acquire("a", now.Add(ttl)).last, ShouldBeNil)
+			})
+
+			Convey("Then it shall be evicted once expired, on the acquisition of another partition", func() {
+				table.acquire("b", now.Add(ttl))
+
+				So(table.partitions, ShouldHaveLength, 1)
+				So(table.partitions, ShouldContainKey, "b")
+			})
+		})
+
+		Convey("When a partition keeping track of a sequence is still referred to", func() {
+			p := table.acquire("a", now)
+			table.acquire("a", now)
+			table.advance(p, 3)
+			table.release("a", p, now.Add(ttl))
+
+			Convey("Then it shall not be evicted, whatever the time", func() {
+				table.acquire("b", now.Add(2*ttl))
+
+				So(table.partitions, ShouldHaveLength, 2)
+				So(table.partitions["a"].last, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When many idle partitions expired", func() {
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("p%d", i)
+				p := table.acquire(key, now)
+				table.advance(p, i)
+				table.release(key, p, now.Add(ttl))
+			}
+
+			So(table.partitions, ShouldHaveLength, 100)
+
+			Convey("Then they shall be evicted at most once per sweep interval", func() {
+				table.acquire("b", now.Add(ttl))
+				So(table.partitions, ShouldHaveLength, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,601 @@
+package kynaptik
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/gamegos/jsend"
+	"github.com/justinas/alice"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"github.com/spf13/afero"
+)
+
+const (
+	// IdempotencyStoreMemory specifies that the processed keys are kept in memory (in-process, LRU).
+	IdempotencyStoreMemory = "memory"
+	// IdempotencyStoreFile specifies that the processed keys are kept in files.
+	IdempotencyStoreFile = "file"
+	// DefaultIdempotencyTTL specifies the default time (in ms) the processed keys are remembered: 24h.
+	DefaultIdempotencyTTL = 86400000
+	// DefaultIdempotencyLease specifies the default time (in ms) the key of an event being processed is reserved: 5min.
+	DefaultIdempotencyLease = 300000
+	// DefaultIdempotencyPath specifies the default folder holding the processed keys, for the file store.
+	DefaultIdempotencyPath = "/idempotency"
+	// DefaultIdempotencyCapacity specifies the maximum number of keys remembered by the memory store.
+	DefaultIdempotencyCapacity = 10000
+	// HeaderIdempotentReplayed is the header set on the replies of the duplicated requests.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// Idempotency specifies how the duplicated events (e.g. redeliveries, retries) are detected, so that they are not
+// processed twice: the reply of the first event is sent back instead.
+type Idempotency struct {
+	// Key specifies the expression giving the key identifying the event, e.g. `data.id`. No idempotency if empty.
+	Key string `yaml:"key"`
+	// TTL specifies the time (in ms) the processed keys are remembered. 24h by default.
+	TTL time.Duration `yaml:"ttl" validate:"gte=0"`
+	// Lease specifies the time (in ms) the key of an event being processed is reserved at most, the duplicates received
+	// meanwhile being rejected. 5min by default.
+	Lease time.Duration `yaml:"lease" validate:"gte=0"`
+	// Store specifies where the processed keys are kept: memory (in-process) or file. memory by default.
+	Store string `yaml:"store" validate:"omitempty,oneof=memory file"`
+	// Path specifies the folder holding the processed keys, for the file store. /idempotency by default.
+	Path string `yaml:"path"`
+}
+
+// enabled returns true if the idempotency is enabled.
+func (p Idempotency) enabled() bool {
+	return p.Key != ""
+}
+
+// ttl returns the time the processed keys are remembered.
+func (p Idempotency) ttl() time.Duration {
+	if p.TTL == 0 {
+		return DefaultIdempotencyTTL * time.Millisecond
+	}
+
+	return p.TTL * time.Millisecond
+}
+
+// lease returns the time the key of an event being processed is reserved at most.
+func (p Idempotency) lease() time.Duration {
+	if p.Lease == 0 {
+		return DefaultIdempotencyLease * time.Millisecond
+	}
+
+	return p.Lease * time.Millisecond
+}
+
+// store returns the store to use, given the filesystem.
+func (p Idempotency) store(fs afero.Fs) IdempotencyStore {
+	if p.Store != IdempotencyStoreFile {
+		return idempotencyMemoryStore
+	}
+
+	dir := p.Path
+	if dir == "" {
+		dir = DefaultIdempotencyPath
+	}
+
+	return NewFileIdempotencyStore(fs, dir)
+}
+
+// MarshalZerologObject produces logs related to the idempotency.
+func (p Idempotency) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("key", p.Key).
+		Dur("ttl", p.ttl()).
+		Dur("lease", p.lease()).
+		Str("store", p.Store)
+}
+
+// StoredReply is the reply sent back for an event, as remembered by an IdempotencyStore.
+type StoredReply struct {
+	// StatusCode is the status code of the reply.
+	StatusCode int `json:"statusCode"`
+	// Header contains the headers of the reply.
+	Header http.Header `json:"header"`
+	// Body is the content of the body of the reply.
+	Body []byte `json:"body"`
+}
+
+// IdempotencyStore remembers the replies sent back for the events processed, by key, for a limited time.
+type IdempotencyStore interface {
+	// Get returns the reply remembered for the given key, if any and not expired.
+	Get(key string, now time.Time) (*StoredReply, bool, error)
+	// Reserve atomically reserves the given key until the given expiration time, for the event to be processed, unless
+	// the key is already reserved or a reply is remembered for it (returned, if any and not expired).
+	Reserve(key string, now, expiresAt time.Time) (*StoredReply, bool, error)
+	// Put remembers the reply for the given key, until the given expiration time.
+	Put(key string, reply StoredReply, expiresAt time.Time) error
+	// Release drops the reservation of the given key (if any), for the event to be processed again.
+	Release(key string) error
+}
+
+// idempotencyMemoryStore is the (in-process) memory store, shared by the invocations.
+var idempotencyMemoryStore = NewMemoryIdempotencyStore(DefaultIdempotencyCapacity)
+
+// memoryIdempotencyEntry is an entry of the memory store, a key being reserved as long as no reply is remembered.
+type memoryIdempotencyEntry struct {
+	key       string
+	reply     *StoredReply
+	expiresAt time.Time
+}
+
+// memoryIdempotencyStore is an IdempotencyStore keeping the replies in memory, evicting the least recently used ones
+// when the capacity is reached.
+type memoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+// NewMemoryIdempotencyStore returns a new IdempotencyStore keeping at most the given number of replies in memory.
+func NewMemoryIdempotencyStore(capacity int) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (s *memoryIdempotencyStore) Get(key string, now time.Time) (*StoredReply, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*memoryIdempotencyEntry)
+	if !now.Before(entry.expiresAt) {
+		s.lru.Remove(element)
+		delete(s.entries, key)
+
+		return nil, false, nil
+	}
+
+	if entry.reply == nil {
+		return nil, false, nil
+	}
+
+	s.lru.MoveToFront(element)
+
+	reply := *entry.reply
+
+	return &reply, true, nil
+}
+
+func (s *memoryIdempotencyStore) Reserve(key string, now, expiresAt time.Time) (*StoredReply, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*memoryIdempotencyEntry)
+		if now.Before(entry.expiresAt) {
+			s.lru.MoveToFront(element)
+
+			if entry.reply == nil {
+				return nil, false, nil
+			}
+
+			reply := *entry.reply
+
+			return &reply, false, nil
+		}
+	}
+
+	s.set(&memoryIdempotencyEntry{key: key, expiresAt: expiresAt})
+
+	return nil, true, nil
+}
+
+func (s *memoryIdempotencyStore) Put(key string, reply StoredReply, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(&memoryIdempotencyEntry{key: key, reply: &reply, expiresAt: expiresAt})
+
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok && element.Value.(*memoryIdempotencyEntry).reply == nil {
+		s.lru.Remove(element)
+		delete(s.entries, key)
+	}
+
+	return nil
+}
+
+// set sets the given entry as the most recently used one, evicting the least recently used ones beyond the capacity.
+func (s *memoryIdempotencyStore) set(entry *memoryIdempotencyEntry) {
+	if element, ok := s.entries[entry.key]; ok {
+		element.Value = entry
+		s.lru.MoveToFront(element)
+
+		return
+	}
+
+	s.entries[entry.key] = s.lru.PushFront(entry)
+
+	for s.capacity > 0 && s.lru.Len() > s.capacity {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryIdempotencyEntry).key)
+	}
+}
+
+// fileIdempotencyEntry is the content of a file of the file store, a key being reserved as long as no reply is
+// remembered.
+type fileIdempotencyEntry struct {
+	Key       string       `json:"key"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+	Reply     *StoredReply `json:"reply,omitempty"`
+}
+
+// fileIdempotencyMu serializes the accesses to the file stores within the process, the exclusive creation of the
+// files guarding the reservations against the other processes sharing the folder.
+var fileIdempotencyMu sync.Mutex
+
+// fileIdempotencyStore is an IdempotencyStore keeping the replies in files (one per key) of a folder.
+type fileIdempotencyStore struct {
+	fs  afero.Fs
+	dir string
+}
+
+// NewFileIdempotencyStore returns a new IdempotencyStore keeping the replies in files of the given folder.
+func NewFileIdempotencyStore(fs afero.Fs, dir string) IdempotencyStore {
+	return &fileIdempotencyStore{fs: fs, dir: dir}
+}
+
+// filename returns the name of the file holding the reply of the given key.
+func (s *fileIdempotencyStore) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return path.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// read returns the entry of the file of the given key, if any.
+func (s *fileIdempotencyStore) read(key string) (*fileIdempotencyEntry, error) {
+	filename := s.filename(key)
+
+	content, err := afero.ReadFile(s.fs, filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entry fileIdempotencyEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return nil, fmt.Errorf("incorrect idempotency file %s: %w", filename, err)
+	}
+
+	return &entry, nil
+}
+
+// readValid returns the entry of the given key, if any and not expired (the expired entries being removed).
+func (s *fileIdempotencyStore) readValid(key string, now time.Time) (*fileIdempotencyEntry, error) {
+	entry, err := s.read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	if entry.Key != key || !now.Before(entry.ExpiresAt) {
+		_ = s.fs.Remove(s.filename(key))
+
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+func (s *fileIdempotencyStore) Get(key string, now time.Time) (*StoredReply, bool, error) {
+	fileIdempotencyMu.Lock()
+	defer fileIdempotencyMu.Unlock()
+
+	entry, err := s.readValid(key, now)
+	if err != nil || entry == nil || entry.Reply == nil {
+		return nil, false, err
+	}
+
+	return entry.Reply, true, nil
+}
+
+func (s *fileIdempotencyStore) Reserve(key string, now, expiresAt time.Time) (*StoredReply, bool, error) {
+	fileIdempotencyMu.Lock()
+	defer fileIdempotencyMu.Unlock()
+
+	entry, err := s.readValid(key, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if entry != nil {
+		return entry.Reply, false, nil
+	}
+
+	content, err := json.Marshal(fileIdempotencyEntry{Key: key, ExpiresAt: expiresAt})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+		return nil, false, err
+	}
+
+	// the exclusive creation fails if the key has been reserved meanwhile by another process
+	file, err := s.fs.OpenFile(s.filename(key), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = file.Write(content)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return nil, true, nil
+}
+
+func (s *fileIdempotencyStore) Put(key string, reply StoredReply, expiresAt time.Time) error {
+	fileIdempotencyMu.Lock()
+	defer fileIdempotencyMu.Unlock()
+
+	content, err := json.Marshal(fileIdempotencyEntry{Key: key, ExpiresAt: expiresAt, Reply: &reply})
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	// write then rename, so that a partially written file is never read
+	filename := s.filename(key)
+	if err := afero.WriteFile(s.fs, filename+".tmp", content, 0644); err != nil {
+		return err
+	}
+
+	return s.fs.Rename(filename+".tmp", filename)
+}
+
+func (s *fileIdempotencyStore) Release(key string) error {
+	fileIdempotencyMu.Lock()
+	defer fileIdempotencyMu.Unlock()
+
+	entry, err := s.read(key)
+	if err != nil || entry == nil || entry.Key != key || entry.Reply != nil {
+		return err
+	}
+
+	return s.fs.Remove(s.filename(key))
+}
+
+// recordingResponseWriter is an http.ResponseWriter keeping track of the reply sent, for it to be remembered.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header
+	body       bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+		w.header = w.Header().Clone()
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.body.Write(b)
+
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotentOutcome remembers the reply of an event whose key is reserved, once known.
+type idempotentOutcome struct {
+	// deferred is true if the reply is remembered later on, by the handler performing the actions in the background.
+	deferred bool
+	remember func(statusCode int, header http.Header, body []byte)
+}
+
+// deferIdempotentOutcome defers the remembering of the reply of the event (if its key is reserved) to the returned
+// function, so that the reply remembered for the actions performed in the background (asynchronous mode) is the one
+// of their outcome, and not the acknowledgment sent back to the caller.
+func deferIdempotentOutcome(r *http.Request) func(statusCode int, header http.Header, body []byte) {
+	outcome, ok := r.Context().Value(ctxKeyIdempotentOutcome).(*idempotentOutcome)
+	if !ok {
+		return func(int, http.Header, []byte) { /* noop: no idempotency */ }
+	}
+
+	outcome.deferred = true
+
+	return outcome.remember
+}
+
+// rememberable returns true if the reply of the given status code is remembered for the key of the event, i.e. unless
+// the event was not processed, or only for the time being (e.g. rate limited, or conflicting with a duplicate), so that
+// it can be retried.
+func rememberable(statusCode int) bool {
+	switch {
+	case statusCode == 0, statusCode >= 500:
+		return false
+	case statusCode == http.StatusTooManyRequests, statusCode == http.StatusConflict:
+		return false
+	default:
+		return true
+	}
+}
+
+// idempotencyHandler short-circuits the duplicated events with the reply remembered for their key, and remembers the
+// reply of the events processed (unless the processing failed with a server error, or was rejected for the time being,
+// so that the event can be retried).
+// The key of an event is reserved while it is processed, so that its duplicates received meanwhile are rejected
+// (409) instead of being processed concurrently.
+func idempotencyHandler(fs afero.Fs) alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config := r.Context().Value(ctxKeyConfig).(Config)
+			idempotency := config.Idempotency
+
+			if !idempotency.enabled() {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
+			env := r.Context().Value(ctxKeyEnv).(environment)
+
+			program, err := programs.compile(idempotency.Key)
+			if err != nil {
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusServiceUnavailable).
+					Message(err.Error()).
+					Data(&ResponseData{Stage: "parse-idempotency-key"}).
+					Send()
+				return
+			}
+
+			value, err := util.EvaluateExpression(program, env)
+			if err != nil {
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusBadRequest).
+					Message(err.Error()).
+					Data(&ResponseData{Stage: "idempotency"}).
+					Send()
+				return
+			}
+
+			if value == nil || value == "" {
+				hlog.
+					FromRequest(r).
+					Debug().
+					Msg("🔑 no idempotency key")
+
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			// the keys are scoped to the function
+			key := fmt.Sprintf("%s/%v", functionKey(r), value)
+			store := idempotency.store(fs)
+			now := time.Now()
+
+			reply, reserved, err := store.Reserve(key, now, now.Add(idempotency.lease()))
+			if err != nil {
+				hlog.
+					FromRequest(r).
+					Error().
+					Err(err).
+					Msg("❌ idempotency store failure")
+
+				reserved = true
+			}
+
+			if reply != nil {
+				hlog.
+					FromRequest(r).
+					Info().
+					Str("key", fmt.Sprint(value)).
+					Msg("♻️ duplicate suppressed")
+
+				for k, v := range reply.Header {
+					if _, ok := w.Header()[k]; !ok {
+						w.Header()[k] = v
+					}
+				}
+
+				w.Header().Set(HeaderIdempotentReplayed, "true")
+				w.WriteHeader(reply.StatusCode)
+				_, _ = w.Write(reply.Body)
+
+				return
+			}
+
+			if !reserved {
+				hlog.
+					FromRequest(r).
+					Info().
+					Str("key", fmt.Sprint(value)).
+					Msg("♻️ duplicate rejected, still being processed")
+
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusConflict).
+					Message(fmt.Sprintf("event with key '%v' is being processed", value)).
+					Data(&ResponseData{Stage: "idempotency"}).
+					Send()
+				return
+			}
+
+			outcome := &idempotentOutcome{
+				remember: func(statusCode int, header http.Header, body []byte) {
+					var err error
+					if !rememberable(statusCode) {
+						err = store.Release(key)
+					} else {
+						stored := StoredReply{StatusCode: statusCode, Header: header, Body: body}
+						err = store.Put(key, stored, time.Now().Add(idempotency.ttl()))
+					}
+
+					if err != nil {
+						hlog.
+							FromRequest(r).
+							Error().
+							Err(err).
+							Msg("❌ idempotency store failure")
+					}
+				},
+			}
+
+			rw := &recordingResponseWriter{ResponseWriter: w}
+
+			Ͱ.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), ctxKeyIdempotentOutcome, outcome)))
+
+			if !outcome.deferred {
+				outcome.remember(rw.statusCode, rw.header, rw.body.Bytes())
+			}
+		})
+	}
+}
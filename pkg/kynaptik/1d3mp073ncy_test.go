@@ -0,0 +1,184 @@
+package kynaptik
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestIdempotencyStores(t *testing.T) {
+	Convey("Considering the idempotency stores", t, func(c C) {
+		now := time.Unix(1600000000, 0)
+		reply := StoredReply{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       []byte(`{"status":"success"}`),
+		}
+
+		stores := map[string]func() IdempotencyStore{
+			"memory": func() IdempotencyStore { return NewMemoryIdempotencyStore(2) },
+			"file":   func() IdempotencyStore { return NewFileIdempotencyStore(afero.NewMemMapFs(), "/idempotency") },
+		}
+
+		for name, factory := range stores {
+			factory := factory
+
+			Convey(fmt.Sprintf("Given a %s store", name), func() {
+				store := factory()
+
+				Convey("When getting an unknown key", func() {
+					_, found, err := store.Get("foo", now)
+
+					Convey("Then no reply shall be found", func() {
+						So(err, ShouldBeNil)
+						So(found, ShouldBeFalse)
+					})
+				})
+
+				Convey("When putting a reply", func() {
+					So(store.Put("foo", reply, now.Add(time.Minute)), ShouldBeNil)
+
+					Convey("Then the reply shall be found before it expires", func() {
+						got, found, err := store.Get("foo", now.Add(30*time.Second))
+						So(err, ShouldBeNil)
+						So(found, ShouldBeTrue)
+						So(*got, ShouldResemble, reply)
+					})
+
+					Convey("Then the reply shall not be found once expired", func() {
+						_, found, err := store.Get("foo", now.Add(time.Minute))
+						So(err, ShouldBeNil)
+						So(found, ShouldBeFalse)
+
+						_, found, err = store.Get("foo", now)
+						So(err, ShouldBeNil)
+						So(found, ShouldBeFalse)
+					})
+
+					Convey("Then the key shall not be reserved, the reply being returned instead", func() {
+						got, reserved, err := store.Reserve("foo", now, now.Add(time.Minute))
+						So(err, ShouldBeNil)
+						So(reserved, ShouldBeFalse)
+						So(*got, ShouldResemble, reply)
+					})
+
+					Convey("Then the release of the key shall keep the reply", func() {
+						So(store.Release("foo"), ShouldBeNil)
+
+						_, found, err := store.Get("foo", now)
+						So(err, ShouldBeNil)
+						So(found, ShouldBeTrue)
+					})
+				})
+
+				Convey("When reserving a key", func() {
+					got, reserved, err := store.Reserve("foo", now, now.Add(time.Minute))
+					So(err, ShouldBeNil)
+					So(reserved, ShouldBeTrue)
+					So(got, ShouldBeNil)
+
+					Convey("Then no reply shall be found", func() {
+						_, found, err := store.Get("foo", now)
+						So(err, ShouldBeNil)
+						So(found, ShouldBeFalse)
+					})
+
+					Convey("Then the key shall not be reserved again until the reservation expires", func() {
+						got, reserved, err := store.Reserve("foo", now.Add(30*time.Second), now.Add(time.Minute))
+						So(err, ShouldBeNil)
+						So(reserved, ShouldBeFalse)
+						So(got, ShouldBeNil)
+
+						_, reserved, err = store.Reserve("foo", now.Add(time.Minute), now.Add(2*time.Minute))
+						So(err, ShouldBeNil)
+						So(reserved, ShouldBeTrue)
+					})
+
+					Convey("Then the key shall be reserved again once released", func() {
+						So(store.Release("foo"), ShouldBeNil)
+
+						_, reserved, err := store.Reserve("foo", now, now.Add(time.Minute))
+						So(err, ShouldBeNil)
+						So(reserved, ShouldBeTrue)
+					})
+
+					Convey("Then the reply put shall be found", func() {
+						So(store.Put("foo", reply, now.Add(time.Minute)), ShouldBeNil)
+
+						got, found, err := store.Get("foo", now)
+						So(err, ShouldBeNil)
+						So(found, ShouldBeTrue)
+						So(*got, ShouldResemble, reply)
+					})
+				})
+
+				Convey("When reserving a key concurrently", func() {
+					var reservations int32
+					var wg sync.WaitGroup
+
+					for i := 0; i < 10; i++ {
+						wg.Add(1)
+
+						go func() {
+							defer wg.Done()
+
+							if _, reserved, err := store.Reserve("bar", now, now.Add(time.Minute)); err == nil && reserved {
+								atomic.AddInt32(&reservations, 1)
+							}
+						}()
+					}
+
+					wg.Wait()
+
+					Convey("Then the key shall be reserved once", func() {
+						So(atomic.LoadInt32(&reservations), ShouldEqual, 1)
+					})
+				})
+			})
+		}
+
+		Convey("Given a memory store with a capacity of 2", func() {
+			store := NewMemoryIdempotencyStore(2)
+
+			So(store.Put("a", reply, now.Add(time.Minute)), ShouldBeNil)
+			So(store.Put("b", reply, now.Add(time.Minute)), ShouldBeNil)
+
+			Convey("When using a key then putting a third one", func() {
+				_, _, _ = store.Get("a", now)
+				So(store.Put("c", reply, now.Add(time.Minute)), ShouldBeNil)
+
+				Convey("Then the least recently used key shall be evicted", func() {
+					_, found, _ := store.Get("a", now)
+					So(found, ShouldBeTrue)
+					_, found, _ = store.Get("b", now)
+					So(found, ShouldBeFalse)
+					_, found, _ = store.Get("c", now)
+					So(found, ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("Given a file store with a corrupted file", func() {
+			fs := afero.NewMemMapFs()
+			store := NewFileIdempotencyStore(fs, "/idempotency").(*fileIdempotencyStore)
+
+			So(afero.WriteFile(fs, store.filename("foo"), []byte("{"), 0644), ShouldBeNil)
+
+			Convey("When getting the key", func() {
+				_, found, err := store.Get("foo", now)
+
+				Convey("Then an error shall be returned", func() {
+					So(found, ShouldBeFalse)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldStartWith, "incorrect idempotency file /idempotency/")
+				})
+			})
+		})
+	})
+}
@@ -1,16 +1,17 @@
 package kynaptik
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"mime"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/antonmedv/expr"
 	"github.com/antonmedv/expr/vm"
 	"github.com/ccamel/kynaptik/internal/util"
 	"github.com/flimzy/donewriter"
@@ -24,7 +25,8 @@ import (
 
 type environment map[string]interface{}
 
-// Invokeλ performs the invocation of the λ function.
+// Invokeλ performs the invocation of the λ function, the actions being created by the given ActionFactory (or
+// selected among the kinds of action registered, if nil).
 func Invokeλ(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -43,12 +45,20 @@ func Invokeλ(
 			checkContentTypeHandler(),
 			parsePreConditionHandler(),
 			parsePostConditionHandler(),
+			parseRetryOnHandler(),
+			parseReplyHandler(),
+			parseRateLimitHandler(),
+			authenticateHandler(),
 			parsePayloadHandler(),
 			buildEnvironmentHandler(),
+			dryRunHandler(actionFactory),
+			idempotencyHandler(fs),
 			matchPreConditionHandler(),
 			buildActionHandler(actionFactory),
+			asyncHandler(),
+			orderingHandler(),
 			donewriter.WrapWriter,
-			matchPostConditionHandler(),
+			matchPostConditionHandler(actionFactory),
 		).
 		Then(doActionHandler()).
 		ServeHTTP(w, r)
@@ -59,6 +69,7 @@ func installValidatorHandler() alice.Constructor {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			validate := validator.New()
 			_ = validate.RegisterValidation("scheme", util.SchemeValidate)
+			validate.RegisterStructValidation(validateConfig, Config{})
 
 			r = r.WithContext(context.WithValue(r.Context(), ctxKeyValidate, validate))
 
@@ -95,14 +106,24 @@ func loadConfigurationHandler(fs afero.Fs, configFactory func() Config) alice.Co
 			namespace := r.Header.Get("X-Fission-Function-Namespace")
 			validate := r.Context().Value(ctxKeyValidate).(*validator.Validate)
 
-			in, err := util.OpenResource(fs, folder, namespace, configName)
-			defer func() {
-				if in != nil {
-					_ = in.Close()
+			key := resourceKey{
+				fs:        fs,
+				folder:    folder,
+				namespace: namespace,
+				name:      configName,
+				variant:   fmt.Sprintf("%#v", configFactory()),
+			}
+
+			value, found, cached, err := resources.load(key, func(in io.Reader) (interface{}, error) {
+				c := configFactory()
+				if err := yaml.NewDecoder(in, yaml.Validator(validate)).Decode(&c); err != nil {
+					return nil, err
 				}
-			}()
 
-			if err == nil && in == nil {
+				return &cachedConfig{config: c, programs: newProgramCache()}, nil
+			})
+
+			if err == nil && !found {
 				err = fmt.Errorf(`no configuration file %s found in /%s/%s`, configName, folder, namespace) // TODO not handy
 			}
 
@@ -111,31 +132,25 @@ func loadConfigurationHandler(fs afero.Fs, configFactory func() Config) alice.Co
 					Wrap(w).
 					Status(http.StatusServiceUnavailable).
 					Message(err.Error()).
-					Data(&ResponseData{"load-configuration"}).
+					Data(&ResponseData{Stage: "load-configuration"}).
 					Send()
 				return
 			}
 
-			c := configFactory()
-			if err := yaml.NewDecoder(in, yaml.Validator(validate)).Decode(&c); err != nil {
-				_, _ = jsend.
-					Wrap(w).
-					Status(http.StatusServiceUnavailable).
-					Message(err.Error()).
-					Data(&ResponseData{"load-configuration"}).
-					Send()
-				return
-			}
+			c := value.(*cachedConfig)
 
 			hlog.
 				FromRequest(r).
 				Info().
-				Object("configuration", c).
+				Object("configuration", c.config).
+				Bool("cached", cached).
 				Msg("🗒 configuration loaded")
 
-			r = r.WithContext(context.WithValue(r.Context(), ctxKeyConfig, c))
+			ctx := context.WithValue(r.Context(), ctxKeyConfig, c.config)
+			ctx = context.WithValue(ctx, ctxKeyPrograms, c.programs)
+			ctx = context.WithValue(ctx, ctxKeyRules, newRules(c.config))
 
-			Ͱ.ServeHTTP(w, r)
+			Ͱ.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
@@ -147,41 +162,40 @@ func loadSecretHandler(fs afero.Fs) alice.Constructor {
 			folder := "secrets"
 			namespace := r.Header.Get("X-Fission-Function-Namespace")
 
-			in, err := util.OpenResource(fs, folder, namespace, resourceName)
-			defer func() {
-				if in != nil {
-					_ = in.Close()
+			key := resourceKey{
+				fs:        fs,
+				folder:    folder,
+				namespace: namespace,
+				name:      resourceName,
+			}
+
+			value, found, cached, err := resources.load(key, func(in io.Reader) (interface{}, error) {
+				c := map[string]interface{}{}
+				if err := yaml.NewDecoder(in).Decode(&c); err != nil {
+					return nil, err
 				}
-			}()
+
+				return c, nil
+			})
 
 			if err != nil {
 				_, _ = jsend.
 					Wrap(w).
 					Status(http.StatusServiceUnavailable).
 					Message(err.Error()).
-					Data(&ResponseData{"load-secret"}).
+					Data(&ResponseData{Stage: "load-secret"}).
 					Send()
 				return
 			}
 
-			if in != nil {
-				c := map[string]interface{}{}
-				if err := yaml.NewDecoder(in).Decode(&c); err != nil {
-					_, _ = jsend.
-						Wrap(w).
-						Status(http.StatusServiceUnavailable).
-						Message(err.Error()).
-						Data(&ResponseData{"load-secret"}).
-						Send()
-					return
-				}
-
+			if found {
 				hlog.
 					FromRequest(r).
 					Info().
+					Bool("cached", cached).
 					Msg("📓 secret loaded")
 
-				r = r.WithContext(context.WithValue(r.Context(), ctxKeySecret, c))
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeySecret, value))
 			} else {
 				hlog.
 					FromRequest(r).
@@ -204,7 +218,7 @@ func checkContentLengthHandler() alice.Constructor {
 					Wrap(w).
 					Status(http.StatusExpectationFailed).
 					Message(fmt.Sprintf("request too large. Maximum bytes allowed: %d", maxBodySize)).
-					Data(&ResponseData{"check-content-length"}).
+					Data(&ResponseData{Stage: "check-content-length"}).
 					Send()
 
 				return
@@ -218,33 +232,30 @@ func checkContentLengthHandler() alice.Constructor {
 func checkContentTypeHandler() alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accept := r.Context().Value(ctxKeyConfig).(Config).accept()
 			contentType := r.Header.Get(util.HeaderContentType)
 
 			if contentType != "" {
-				for _, v := range strings.Split(contentType, ",") {
-					t, _, err := mime.ParseMediaType(v)
-					if err != nil {
-						break
-					}
-					if t == util.MediaTypeApplicationJSON {
-						hlog.
-							FromRequest(r).
-							Info().
-							Str(util.HeaderContentType, util.MediaTypeApplicationJSON).
-							Msg("☑️️ valid media type")
+				if negotiated, ok := negotiateMediaType(accept, contentType); ok {
+					hlog.
+						FromRequest(r).
+						Info().
+						Str(util.HeaderContentType, negotiated.mediaType).
+						Msg("☑️️ valid media type")
 
-						Ͱ.ServeHTTP(w, r)
+					r = r.WithContext(context.WithValue(r.Context(), ctxKeyMediaType, negotiated))
 
-						return
-					}
+					Ͱ.ServeHTTP(w, r)
+
+					return
 				}
 			}
 
 			_, _ = jsend.
 				Wrap(w).
 				Status(http.StatusUnsupportedMediaType).
-				Message(fmt.Sprintf("unsupported media type. Expected: %s", util.MediaTypeApplicationJSON)).
-				Data(&ResponseData{"check-content-type"}).
+				Message(fmt.Sprintf("unsupported media type. Expected: %s", strings.Join(accept, ", "))).
+				Data(&ResponseData{Stage: "check-content-type"}).
 				Send()
 		})
 	}
@@ -253,15 +264,89 @@ func checkContentTypeHandler() alice.Constructor {
 func parsePreConditionHandler() alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			condition := r.Context().Value(ctxKeyConfig).(Config).PreCondition
+			rules := r.Context().Value(ctxKeyRules).([]*rule)
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
+
+			for _, rule := range rules {
+				program, err := programs.compile(rule.PreCondition)
+				if err != nil {
+					_, _ = jsend.
+						Wrap(w).
+						Status(http.StatusServiceUnavailable).
+						Message(rule.wrapError(err).Error()).
+						Data(&ResponseData{Stage: "parse-pre-condition"}).
+						Send()
+					return
+				}
+
+				rule.preConditionProgram = program
+			}
+
+			hlog.
+				FromRequest(r).
+				Info().
+				Msg("☑️️ preCondition parsed")
+
+			Ͱ.ServeHTTP(w, r)
+		})
+	}
+}
+
+func parsePostConditionHandler() alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rules := r.Context().Value(ctxKeyRules).([]*rule)
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
+
+			for _, rule := range rules {
+				if rule.PostCondition == "" {
+					// the postCondition by default of the kind of each action applies
+					continue
+				}
+
+				program, err := programs.compile(rule.PostCondition)
+				if err != nil {
+					_, _ = jsend.
+						Wrap(w).
+						Status(http.StatusServiceUnavailable).
+						Message(rule.wrapError(err).Error()).
+						Data(&ResponseData{Stage: "parse-post-condition"}).
+						Send()
+					return
+				}
+
+				rule.postConditionProgram = program
+			}
+
+			hlog.
+				FromRequest(r).
+				Info().
+				Msg("☑️️ postCondition parsed")
+
+			Ͱ.ServeHTTP(w, r)
+		})
+	}
+}
+
+func parseRetryOnHandler() alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			retry := r.Context().Value(ctxKeyConfig).(Config).Retry
 
-			program, err := expr.Compile(condition)
+			if !retry.enabled() {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
+
+			program, err := programs.compile(retry.retryOn())
 			if err != nil {
 				_, _ = jsend.
 					Wrap(w).
 					Status(http.StatusServiceUnavailable).
 					Message(err.Error()).
-					Data(&ResponseData{"parse-pre-condition"}).
+					Data(&ResponseData{Stage: "parse-retry-on"}).
 					Send()
 				return
 			}
@@ -269,27 +354,34 @@ func parsePreConditionHandler() alice.Constructor {
 			hlog.
 				FromRequest(r).
 				Info().
-				Msg("☑️️ preCondition parsed")
+				Msg("☑️️ retryOn parsed")
 
-			r = r.WithContext(context.WithValue(r.Context(), ctxKeyPreConditionProgram, program))
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyRetryOnProgram, program))
 
 			Ͱ.ServeHTTP(w, r)
 		})
 	}
 }
 
-func parsePostConditionHandler() alice.Constructor {
+func parseReplyHandler() alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			condition := r.Context().Value(ctxKeyConfig).(Config).PostCondition
+			reply := r.Context().Value(ctxKeyConfig).(Config).Reply
+
+			if reply.StatusCode == "" {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
 
-			program, err := expr.Compile(condition)
+			program, err := programs.compile(reply.StatusCode)
 			if err != nil {
 				_, _ = jsend.
 					Wrap(w).
 					Status(http.StatusServiceUnavailable).
 					Message(err.Error()).
-					Data(&ResponseData{"parse-post-condition"}).
+					Data(&ResponseData{Stage: "parse-reply"}).
 					Send()
 				return
 			}
@@ -297,53 +389,126 @@ func parsePostConditionHandler() alice.Constructor {
 			hlog.
 				FromRequest(r).
 				Info().
-				Msg("☑️️ postCondition parsed")
+				Msg("☑️️ reply parsed")
 
-			r = r.WithContext(context.WithValue(r.Context(), ctxKeyPostConditionProgram, program))
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyReplyProgram, program))
 
 			Ͱ.ServeHTTP(w, r)
 		})
 	}
 }
 
-func parsePayloadHandler() alice.Constructor {
+func parseRateLimitHandler() alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			maxBodySize := r.Context().Value(ctxKeyConfig).(Config).MaxBodySize
-			reader := r.Body
+			rateLimit := r.Context().Value(ctxKeyConfig).(Config).RateLimit
 
-			if maxBodySize > 0 {
-				reader = http.MaxBytesReader(w, r.Body, maxBodySize)
+			if !rateLimit.enabled() || rateLimit.Key == "" {
+				Ͱ.ServeHTTP(w, r)
+				return
 			}
 
-			payload, err := ioutil.ReadAll(reader)
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
+
+			program, err := programs.compile(rateLimit.Key)
 			if err != nil {
-				switch {
-				case err.Error() == "http: request body too large": // TODO: fragile - how to improve?
-					_, _ = jsend.
-						Wrap(w).
-						Status(http.StatusRequestEntityTooLarge).
-						Message(fmt.Sprintf("request too large. Maximum bytes allowed: %d", maxBodySize)).
-						Data(&ResponseData{"parse-payload"}).
-						Send()
-				default:
-					_, _ = jsend.
-						Wrap(w).
-						Status(http.StatusBadRequest).
-						Message(err.Error()).
-						Data(&ResponseData{"parse-payload"}).
-						Send()
-				}
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusServiceUnavailable).
+					Message(err.Error()).
+					Data(&ResponseData{Stage: "parse-rate-limit"}).
+					Send()
+				return
+			}
+
+			hlog.
+				FromRequest(r).
+				Info().
+				Msg("☑️️ rateLimit parsed")
+
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyRateLimitKeyProgram, program))
+
+			Ͱ.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readPayload reads the body of the incoming request, up to the given maximum size.
+func readPayload(w http.ResponseWriter, r *http.Request, maxBodySize int64) ([]byte, error) {
+	reader := r.Body
+
+	if maxBodySize > 0 {
+		reader = http.MaxBytesReader(w, r.Body, maxBodySize)
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+// sendReadPayloadError replies with the error which occurred while reading the body of the incoming request.
+func sendReadPayloadError(w http.ResponseWriter, err error, maxBodySize int64, stage string) {
+	switch {
+	case err.Error() == "http: request body too large": // TODO: fragile - how to improve?
+		_, _ = jsend.
+			Wrap(w).
+			Status(http.StatusRequestEntityTooLarge).
+			Message(fmt.Sprintf("request too large. Maximum bytes allowed: %d", maxBodySize)).
+			Data(&ResponseData{Stage: stage}).
+			Send()
+	default:
+		_, _ = jsend.
+			Wrap(w).
+			Status(http.StatusBadRequest).
+			Message(err.Error()).
+			Data(&ResponseData{Stage: stage}).
+			Send()
+	}
+}
+
+func authenticateHandler() alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config := r.Context().Value(ctxKeyConfig).(Config)
+
+			if !config.Authentication.enabled() {
+				Ͱ.ServeHTTP(w, r)
 				return
 			}
 
-			data := map[string]interface{}{}
-			if err := json.Unmarshal(payload, &data); err != nil {
+			var payload []byte
+
+			if config.Authentication.HMAC.enabled() {
+				var err error
+
+				payload, err = readPayload(w, r, config.MaxBodySize)
+				if err != nil {
+					sendReadPayloadError(w, err, config.MaxBodySize, "authenticate")
+					return
+				}
+
+				// the body is consumed: make it available again for the next handlers
+				r.Body = ioutil.NopCloser(bytes.NewReader(payload))
+			}
+
+			secret, _ := r.Context().Value(ctxKeySecret).(map[string]interface{})
+
+			auth, err := authenticate(config.Authentication, r, payload, secret, time.Now())
+			if err != nil {
+				status := http.StatusServiceUnavailable
+				if _, ok := err.(authenticationError); ok {
+					status = http.StatusUnauthorized
+				}
+
+				hlog.
+					FromRequest(r).
+					Warn().
+					Err(err).
+					Msg("⛔ request not authenticated")
+
 				_, _ = jsend.
 					Wrap(w).
-					Status(http.StatusBadRequest).
+					Status(status).
 					Message(err.Error()).
-					Data(&ResponseData{"parse-payload"}).
+					Data(&ResponseData{Stage: "authenticate"}).
 					Send()
 				return
 			}
@@ -351,7 +516,65 @@ func parsePayloadHandler() alice.Constructor {
 			hlog.
 				FromRequest(r).
 				Info().
-				Msg("☑️️ payload parsed")
+				Msg("☑️️ request authenticated")
+
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyAuth, auth))
+
+			Ͱ.ServeHTTP(w, r)
+		})
+	}
+}
+
+func parsePayloadHandler() alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maxBodySize := r.Context().Value(ctxKeyConfig).(Config).MaxBodySize
+
+			payload, err := readPayload(w, r, maxBodySize)
+			if err != nil {
+				sendReadPayloadError(w, err, maxBodySize, "parse-payload")
+				return
+			}
+
+			negotiated := r.Context().Value(ctxKeyMediaType).(*negotiatedMediaType)
+
+			data, err := negotiated.decoder(payload, negotiated.params)
+			if err != nil {
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusBadRequest).
+					Message(err.Error()).
+					Data(&ResponseData{Stage: "parse-payload"}).
+					Send()
+				return
+			}
+
+			event, data, err := extractCloudEvent(r, negotiated, data)
+			if err != nil {
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusBadRequest).
+					Message(err.Error()).
+					Data(&ResponseData{Stage: "parse-payload"}).
+					Send()
+				return
+			}
+
+			logger := hlog.FromRequest(r)
+			if event != nil {
+				logger.
+					Info().
+					Interface("id", event["id"]).
+					Interface("type", event["type"]).
+					Interface("source", event["source"]).
+					Msg("☑️️ CloudEvent parsed")
+
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyEvent, event))
+			} else {
+				logger.
+					Info().
+					Msg("☑️️ payload parsed")
+			}
 
 			r = r.WithContext(context.WithValue(r.Context(), ctxKeyData, data))
 
@@ -363,10 +586,18 @@ func parsePayloadHandler() alice.Constructor {
 func buildEnvironmentHandler() alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id string
+			if v, ok := hlog.IDFromRequest(r); ok {
+				id = v.String()
+			}
+
 			env := environment{
-				"data":   r.Context().Value(ctxKeyData),
-				"config": r.Context().Value(ctxKeyConfig),
-				"secret": r.Context().Value(ctxKeySecret),
+				"request": util.NewHTTPRequest(r, id),
+				"data":    r.Context().Value(ctxKeyData),
+				"config":  r.Context().Value(ctxKeyConfig),
+				"secret":  r.Context().Value(ctxKeySecret),
+				"auth":    r.Context().Value(ctxKeyAuth),
+				"event":   r.Context().Value(ctxKeyEvent),
 			}
 
 			hlog.
@@ -384,26 +615,48 @@ func buildEnvironmentHandler() alice.Constructor {
 func matchPreConditionHandler() alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			program := r.Context().Value(ctxKeyPreConditionProgram).(*vm.Program)
+			config := r.Context().Value(ctxKeyConfig).(Config)
+			rules := r.Context().Value(ctxKeyRules).([]*rule)
 			env := r.Context().Value(ctxKeyEnv).(environment)
 
-			matched, err := util.EvaluatePredicateExpression(program, env)
-			if err != nil {
-				_, _ = jsend.
-					Wrap(w).
-					Status(http.StatusBadRequest).
-					Message(err.Error()).
-					Data(&ResponseData{"match-pre-condition"}).
-					Send()
-				return
+			report, _ := r.Context().Value(ctxKeyDryRun).(*DryRunReport)
+
+			var matchedRules []*rule
+
+			for _, rule := range rules {
+				matched, err := util.EvaluatePredicateExpression(rule.preConditionProgram, env)
+				if err != nil {
+					_, _ = jsend.
+						Wrap(w).
+						Status(http.StatusBadRequest).
+						Message(rule.wrapError(err).Error()).
+						Data(&ResponseData{Stage: "match-pre-condition", DryRun: report}).
+						Send()
+					return
+				}
+
+				if report != nil {
+					report.addPreCondition(rule, matched)
+				}
+
+				if matched {
+					matchedRules = append(matchedRules, rule)
+
+					if config.MatchMode != MatchModeAll {
+						break
+					}
+				}
 			}
 
-			if matched {
+			if len(matchedRules) > 0 {
 				hlog.
 					FromRequest(r).
 					Info().
+					Strs("rules", firedRuleNames(matchedRules)).
 					Msg("👌️️ pre-condition matched")
 
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyMatchedRules, matchedRules))
+
 				Ͱ.ServeHTTP(w, r)
 			} else {
 				hlog.
@@ -415,7 +668,7 @@ func matchPreConditionHandler() alice.Constructor {
 					Wrap(w).
 					Status(http.StatusOK).
 					Message("unsatisfied condition").
-					Data(&ResponseData{"match-pre-condition"}).
+					Data(&ResponseData{Stage: "match-pre-condition", DryRun: report}).
 					Send()
 			}
 		})
@@ -425,45 +678,64 @@ func matchPreConditionHandler() alice.Constructor {
 func buildActionHandler(actionFactory ActionFactory) alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			actionSpec := r.Context().Value(ctxKeyConfig).(Config).Action
+			rules := r.Context().Value(ctxKeyMatchedRules).([]*rule)
 			validate := r.Context().Value(ctxKeyValidate).(*validator.Validate)
+			programs := r.Context().Value(ctxKeyPrograms).(*programCache)
 			env := r.Context().Value(ctxKeyEnv).(environment)
-			action := actionFactory()
 
 			sendError := func(err error) {
 				_, _ = jsend.
 					Wrap(w).
 					Status(http.StatusServiceUnavailable).
 					Message(err.Error()).
-					Data(&ResponseData{"build-action"}).
+					Data(&ResponseData{Stage: "build-action"}).
 					Send()
 			}
 
-			in, err := util.RenderTemplatedString("action", actionSpec, env)
-			if err != nil {
-				sendError(err)
-				return
-			}
+			tasks := make([]*task, 0, len(rules))
 
-			if err := yaml.NewDecoder(in, yaml.Validator(validate)).Decode(action); err != nil {
-				sendError(err)
-				return
-			}
+			for _, rule := range rules {
+				in, err := util.RenderTemplatedString("action", rule.Action, env)
+				if err != nil {
+					sendError(rule.wrapError(err))
+					return
+				}
 
-			hlog.
-				FromRequest(r).
-				Info().
-				Object("action", action).
-				Msg("☑️️ action built")
+				actions, err := decodeActions(in, resolverOf(actionFactory), validate)
+				if err != nil {
+					sendError(rule.wrapError(err))
+					return
+				}
+
+				for _, action := range actions {
+					hlog.
+						FromRequest(r).
+						Info().
+						Object("action", action).
+						Msg("☑️️ action built")
+
+					t := newTask(rule, action, env)
+
+					if t.postConditionProgram == nil {
+						t.postCondition, t.postConditionProgram, err = defaultPostCondition(programs, action)
+						if err != nil {
+							sendError(rule.wrapError(err))
+							return
+						}
+					}
+
+					tasks = append(tasks, t)
+				}
+			}
 
-			r = r.WithContext(context.WithValue(r.Context(), ctxKeyAction, action))
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyTasks, tasks))
 
 			Ͱ.ServeHTTP(w, r)
 		})
 	}
 }
 
-func matchPostConditionHandler() alice.Constructor {
+func matchPostConditionHandler(actionFactory ActionFactory) alice.Constructor {
 	return func(Ͱ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			Ͱ.ServeHTTP(w, r)
@@ -473,59 +745,120 @@ func matchPostConditionHandler() alice.Constructor {
 				return
 			}
 
-			action := r.Context().Value(ctxKeyAction).(Action)
+			rules := r.Context().Value(ctxKeyMatchedRules).([]*rule)
+			tasks := r.Context().Value(ctxKeyTasks).([]*task)
+
 			config := r.Context().Value(ctxKeyConfig).(Config)
-			program := r.Context().Value(ctxKeyPostConditionProgram).(*vm.Program)
-			env := r.Context().Value(ctxKeyEnv).(environment)
 
-			matched, err := util.EvaluatePredicateExpression(program, env)
-			if err != nil {
-				_, _ = jsend.
-					Wrap(w).
-					Status(http.StatusBadRequest).
-					Message(err.Error()).
-					Data(&ResponseData{"match-post-condition"}).
-					Send()
-				return
+			outcomes := make([]Outcome, len(tasks))
+			for i, task := range tasks {
+				outcomes[i] = evaluateTaskOutcome(r, task)
+
+				if config.DeadLetter.enabled() && outcomes[i].code != http.StatusOK && deadLetterStages[outcomes[i].Stage] {
+					outcomes[i].DeadLetter = sendToDeadLetter(r, actionFactory, task, outcomes[i])
+				}
 			}
 
-			if matched {
-				hlog.
-					FromRequest(r).
-					Info().
-					Str("endpoint", action.GetURI()).
-					Msg("👍 invocation succeeded")
+			data := &ResponseData{
+				Stage:    "match-post-condition",
+				Rules:    firedRuleNames(rules),
+				Outcomes: outcomes,
+			}
+			code := http.StatusOK
+			message := fmt.Sprintf("%d action(s) succeeded", len(outcomes))
 
-				_, _ = jsend.
-					Wrap(w).
-					Status(http.StatusOK).
-					Message("HTTP call succeeded").
-					Data(&ResponseData{"match-post-condition"}).
-					Send()
-			} else {
-				hlog.
-					FromRequest(r).
-					Error().
-					Str("endpoint", action.GetURI()).
-					Str("postCondition", config.PostCondition).
-					Err(fmt.Errorf("condition not satisfied")).
-					Msg("❌ invocation failed")
+			var response interface{}
 
-				_, _ = jsend.
-					Wrap(w).
-					Status(http.StatusBadGateway).
-					Message(fmt.Sprintf(
-						"endpoint '%s' call didn't satisfy postCondition: %s", action.GetURI(), config.PostCondition)).
-					Data(&ResponseData{"match-post-condition"}).
-					Send()
+			for i, outcome := range outcomes {
+				response = tasks[i].response
+
+				if outcome.code != http.StatusOK {
+					data.Stage = outcome.Stage
+					code = outcome.code
+					message = outcome.Message
+
+					break
+				}
+			}
+
+			reply := config.Reply
+
+			if reply.enabled() && (code == http.StatusOK || reply.OnFailure) {
+				program, _ := r.Context().Value(ctxKeyReplyProgram).(*vm.Program)
+				env := replyEnvironment(r.Context().Value(ctxKeyEnv).(environment), response, code, message, data)
+
+				if err := reply.send(w, program, env, code); err != nil {
+					hlog.
+						FromRequest(r).
+						Error().
+						Err(err).
+						Msg("❌ reply failed")
+
+					_, _ = jsend.
+						Wrap(w).
+						Status(http.StatusServiceUnavailable).
+						Message(err.Error()).
+						Data(&ResponseData{Stage: "reply", Rules: data.Rules, Outcomes: outcomes}).
+						Send()
+				}
+
+				return
 			}
+
+			_, _ = jsend.
+				Wrap(w).
+				Status(code).
+				Message(message).
+				Data(data).
+				Send()
 		})
 	}
 }
 
+// evaluateTaskOutcome determines the outcome of the given (executed) task, evaluating its postCondition.
+func evaluateTaskOutcome(r *http.Request, t *task) Outcome {
+	if errors.As(t.err, &circuitOpenError{}) {
+		return newOutcome(t, "circuit-open", http.StatusServiceUnavailable, t.rule.wrapError(t.err).Error())
+	}
+
+	if errors.As(t.err, &rateLimitedError{}) {
+		return newOutcome(t, "rate-limited", http.StatusTooManyRequests, t.rule.wrapError(t.err).Error())
+	}
+
+	if t.err != nil {
+		return newOutcome(t, "do-action", http.StatusBadGateway, t.rule.wrapError(t.err).Error())
+	}
+
+	matched, err := util.EvaluatePredicateExpression(t.postConditionProgram, t.env)
+	if err != nil {
+		return newOutcome(t, "match-post-condition", http.StatusBadRequest, t.rule.wrapError(err).Error())
+	}
+
+	if !matched {
+		hlog.
+			FromRequest(r).
+			Error().
+			Str("endpoint", t.action.GetURI()).
+			Str("postCondition", t.postCondition).
+			Err(fmt.Errorf("condition not satisfied")).
+			Msg("❌ invocation failed")
+
+		return newOutcome(t, "match-post-condition", http.StatusBadGateway, fmt.Sprintf(
+			"endpoint '%s' call didn't satisfy postCondition: %s", t.action.GetURI(), t.postCondition))
+	}
+
+	hlog.
+		FromRequest(r).
+		Info().
+		Str("endpoint", t.action.GetURI()).
+		Msg("👍 invocation succeeded")
+
+	return newOutcome(t, "match-post-condition", http.StatusOK, "")
+}
+
 func doActionHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		action := r.Context().Value(ctxKeyAction).(Action)
+		tasks := r.Context().Value(ctxKeyTasks).([]*task)
 		config := r.Context().Value(ctxKeyConfig).(Config)
 
 		ctx := r.Context()
@@ -536,26 +869,230 @@ func doActionHandler() http.Handler {
 		}
 		defer cancel()
 
-		response, err := action.DoAction(ctx)
+		if config.Parallelism <= 1 {
+			for _, t := range tasks {
+				doTask(ctx, r, t)
+			}
+
+			return
+		}
+
+		var wg sync.WaitGroup
+
+		sem := make(chan struct{}, config.Parallelism)
+
+		for _, t := range tasks {
+			sem <- struct{}{}
+
+			wg.Add(1)
+
+			go func(t *task) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				doTask(ctx, r, t)
+			}(t)
+		}
+
+		wg.Wait()
+	})
+}
+
+// doTask executes the action of the given task, keeping track of the result. The action is retried according to
+// the retry policy, if any.
+func doTask(ctx context.Context, r *http.Request, t *task) {
+	config := r.Context().Value(ctxKeyConfig).(Config)
+	retry := config.Retry
+	retryOn, _ := r.Context().Value(ctxKeyRetryOnProgram).(*vm.Program)
+
+	for attempt := 1; ; attempt++ {
+		response, err := doActionWithinLimits(ctx, r, t)
+
+		if errors.As(err, &rateLimitedError{}) {
+			hlog.
+				FromRequest(r).
+				Warn().
+				Str("endpoint", t.action.GetURI()).
+				Int("attempt", attempt).
+				Err(err).
+				Msg("🚦 invocation rate-limited")
+		}
+
+		if errors.As(err, &circuitOpenError{}) {
+			hlog.
+				FromRequest(r).
+				Warn().
+				Str("endpoint", t.action.GetURI()).
+				Int("attempt", attempt).
+				Err(err).
+				Msg("🚧 invocation short-circuited")
+
+			if attempt == 1 {
+				// otherwise, keep the result of the previous attempt which is more meaningful
+				t.response, t.err = response, err
+			}
+
+			break
+		}
+
+		t.response, t.err = response, err
 
 		if err != nil {
 			hlog.
 				FromRequest(r).
 				Error().
+				Str("endpoint", t.action.GetURI()).
+				Int("attempt", attempt).
 				Err(err).
 				Msg("❌ invocation failed")
+		} else {
+			hlog.
+				FromRequest(r).
+				Info().
+				Str("endpoint", t.action.GetURI()).
+				Int("attempt", attempt).
+				Msg("☑️️ invocation done")
+		}
 
-			_, _ = jsend.
-				Wrap(w).
-				Status(http.StatusBadGateway).
-				Message(err.Error()).
-				Data(&ResponseData{"do-action"}).
-				Send()
-			return
+		if retryOn == nil || attempt >= retry.MaxAttempts || !shouldRetry(r, t, retryOn, attempt) {
+			break
 		}
 
-		// put the response in the environment to share it for the layers above
-		env := r.Context().Value(ctxKeyEnv).(environment)
-		env["response"] = response
-	})
+		interval := retry.interval(attempt)
+
+		hlog.
+			FromRequest(r).
+			Warn().
+			Str("endpoint", t.action.GetURI()).
+			Int("attempt", attempt).
+			Dur("interval", interval).
+			Msg("🔁 invocation to be retried")
+
+		if err := sleep(ctx, interval); err != nil {
+			hlog.
+				FromRequest(r).
+				Error().
+				Str("endpoint", t.action.GetURI()).
+				Int("attempt", attempt).
+				Err(err).
+				Msg("❌ no more time for retrying")
+
+			break
+		}
+	}
+
+	if t.err == nil {
+		// put the response in the environment of the task to share it for the layers above
+		t.env["response"] = t.response
+	}
+}
+
+// doActionWithinLimits performs the action of the given task, once allowed by the concurrency and rate limits (if
+// enabled).
+func doActionWithinLimits(ctx context.Context, r *http.Request, t *task) (interface{}, error) {
+	config := r.Context().Value(ctxKeyConfig).(Config)
+	// the limits are scoped to the function
+	function := functionKey(r)
+
+	if config.MaxConcurrency > 0 {
+		release, err := acquireSlot(ctx, limiters.slotsFor(function, config.MaxConcurrency), config.LimitMode)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	if config.RateLimit.enabled() {
+		key := function
+
+		if program, ok := r.Context().Value(ctxKeyRateLimitKeyProgram).(*vm.Program); ok {
+			value, err := util.EvaluateExpression(program, t.env)
+			if err != nil {
+				return nil, err
+			}
+
+			key = fmt.Sprintf("%s/%v", function, value)
+		}
+
+		if err := takeToken(ctx, key, config.RateLimit, config.LimitMode); err != nil {
+			return nil, err
+		}
+	}
+
+	return doActionThroughCircuit(ctx, r, config.CircuitBreaker, t)
+}
+
+// doActionThroughCircuit performs the action of the given task, under the control of the circuit breaker (if
+// enabled). A call is considered successful if it satisfies the postCondition of the task, so that a backend replying
+// with errors (e.g. HTTP 5xx) opens the circuit as well.
+func doActionThroughCircuit(ctx context.Context, r *http.Request, p CircuitBreaker, t *task) (interface{}, error) {
+	if !p.enabled() {
+		return t.action.DoAction(ctx)
+	}
+
+	host := circuitKey(t.action.GetURI())
+	breaker := circuitBreakers.get(host)
+
+	if ok, _ := breaker.allow(p, time.Now()); !ok {
+		return nil, circuitOpenError{host: host}
+	}
+
+	response, err := t.action.DoAction(ctx)
+
+	if state := breaker.record(p, time.Now(), err == nil && satisfiesPostCondition(t, response)); state == circuitOpen {
+		hlog.
+			FromRequest(r).
+			Warn().
+			Str("host", host).
+			Msg("🚧 circuit open")
+	}
+
+	return response, err
+}
+
+// satisfiesPostCondition tells if the given response of the action of the given task satisfies the postCondition of
+// the task (a postCondition which can't be evaluated being not satisfied).
+func satisfiesPostCondition(t *task, response interface{}) bool {
+	env := make(environment, len(t.env)+1)
+	for k, v := range t.env {
+		env[k] = v
+	}
+
+	env["response"] = response
+
+	matched, err := util.EvaluatePredicateExpression(t.postConditionProgram, env)
+
+	return err == nil && matched
+}
+
+// shouldRetry evaluates the retryOn condition against the result of the last attempt of the given task.
+func shouldRetry(r *http.Request, t *task, retryOn *vm.Program, attempt int) bool {
+	env := make(environment, len(t.env)+3)
+	for k, v := range t.env {
+		env[k] = v
+	}
+
+	env["response"] = t.response
+	env["error"] = nil
+	env["attempt"] = attempt
+
+	if t.err != nil {
+		env["error"] = t.err.Error()
+	}
+
+	retry, err := util.EvaluatePredicateExpression(retryOn, env)
+	if err != nil {
+		hlog.
+			FromRequest(r).
+			Error().
+			Str("endpoint", t.action.GetURI()).
+			Err(err).
+			Msg("❌ retryOn evaluation failed")
+
+		return false
+	}
+
+	return retry
 }
@@ -2,19 +2,28 @@ package kynaptik
 
 import (
 	"context"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/rs/zerolog"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/spf13/afero"
@@ -644,7 +653,7 @@ postCondition: |
 	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) { return "ok", nil }
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		So(rr.Code, ShouldEqual, http.StatusBadRequest)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition"},"message":"invalid operation: \u003cnil\u003e + int (1:3)\n | a + 5 == 6\n | ..^","status":"fail"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://","stage":"match-post-condition","status":"fail","message":"invalid operation: \u003cnil\u003e + int (1:3)\n | a + 5 == 6\n | ..^"}]},"message":"invalid operation: \u003cnil\u003e + int (1:3)\n | a + 5 == 6\n | ..^","status":"fail"}`)
 	}
 
 	return f
@@ -673,7 +682,7 @@ postCondition: |
 	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) { return "ok", nil }
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		So(rr.Code, ShouldEqual, http.StatusBadRequest)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition"},"message":"incorrect type string returned when evaluating expression 'response\n'. Expected 'boolean'","status":"fail"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://","stage":"match-post-condition","status":"fail","message":"incorrect type string returned when evaluating expression 'response\n'. Expected 'boolean'"}]},"message":"incorrect type string returned when evaluating expression 'response\n'. Expected 'boolean'","status":"fail"}`)
 	}
 
 	return f
@@ -702,7 +711,7 @@ postCondition: |
 	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) { return "ko", nil }
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		So(rr.Code, ShouldEqual, http.StatusBadGateway)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition"},"message":"endpoint 'null://' call didn't satisfy postCondition: response == \"ok\"\n","status":"error"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://","stage":"match-post-condition","status":"error","message":"endpoint 'null://' call didn't satisfy postCondition: response == \"ok\"\n"}]},"message":"endpoint 'null://' call didn't satisfy postCondition: response == \"ok\"\n","status":"error"}`)
 	}
 
 	return f
@@ -798,7 +807,7 @@ action: |
 	}
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		So(rr.Code, ShouldEqual, http.StatusBadGateway)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action"},"message":"net/http: request canceled","status":"error"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"http://127.0.0.1","stage":"do-action","status":"error","message":"net/http: request canceled"}]},"message":"net/http: request canceled","status":"error"}`)
 	}
 
 	return f
@@ -837,7 +846,7 @@ postCondition: |
 	}
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		So(rr.Code, ShouldEqual, http.StatusOK)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition"},"message":"HTTP call succeeded","status":"success"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"http://127.0.0.1?id=Rmlyc3Qgb3B0aW9u=","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
 	}
 
 	return f
@@ -882,7 +891,7 @@ postCondition: |
 	}
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		So(rr.Code, ShouldEqual, http.StatusBadGateway)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action"},"message":"context deadline exceeded","status":"error"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"http://127.0.0.1?id=Rmlyc3Qgb3B0aW9u=","stage":"do-action","status":"error","message":"context deadline exceeded"}]},"message":"context deadline exceeded","status":"error"}`)
 	}
 
 	return f
@@ -920,7 +929,2492 @@ password: 'c+KCrGNy4oKsdA=='
 	f.assert = func(rr *httptest.ResponseRecorder) {
 		// fmt.Println(rr.Body.String())
 		// So(rr.Code, ShouldEqual, http.StatusOK)
-		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition"},"message":"HTTP call succeeded","status":"success"}`)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://127.0.0.1","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func rulesFirstMatchFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "type": "order", "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+rules:
+  - name: orders
+    preCondition: data.type == "order"
+    action: |
+      uri: 'null://orders/{{ .data.id }}'
+      param1: 'foo'
+    postCondition: response == "ok"
+  - name: others
+    action: |
+      uri: 'null://others'
+      param1: 'bar'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		So(action.URI, ShouldEqual, "null://orders/42")
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","rules":["orders"],"outcomes":[{"rule":"orders","uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func rulesAllMatchFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "type": "order", "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+matchMode: all-match
+postCondition: response == "ok"
+rules:
+  - name: orders
+    preCondition: data.type == "order"
+    action: |
+      uri: 'null://orders/{{ .data.id }}'
+      param1: 'foo'
+  - name: invoices
+    preCondition: data.type == "invoice"
+    action: |
+      uri: 'null://invoices/{{ .data.id }}'
+      param1: 'foo'
+  - action: |
+      uri: 'null://audit'
+      param1: 'bar'
+`
+	var uris []string
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		uris = append(uris, action.URI)
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","rules":["orders","rule-2"],"outcomes":[{"rule":"orders","uri":"null://orders/42","stage":"match-post-condition","status":"success"},{"rule":"rule-2","uri":"null://audit","stage":"match-post-condition","status":"success"}]},"message":"2 action(s) succeeded","status":"success"}`)
+		So(uris, ShouldResemble, []string{"null://orders/42", "null://audit"})
+	}
+
+	return f
+}
+
+func rulesUnsatisfiedPostConditionFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "type": "order", "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+matchMode: all-match
+rules:
+  - name: orders
+    action: |
+      uri: 'null://orders'
+      param1: 'foo'
+    postCondition: response == "ok"
+  - name: audit
+    action: |
+      uri: 'null://audit'
+      param1: 'bar'
+    postCondition: response == "ok"
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		if action.URI == "null://audit" {
+			return "ko", nil
+		}
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","rules":["orders","audit"],"outcomes":[{"rule":"orders","uri":"null://orders","stage":"match-post-condition","status":"success"},{"rule":"audit","uri":"null://audit","stage":"match-post-condition","status":"error","message":"endpoint 'null://audit' call didn't satisfy postCondition: response == \"ok\""}]},"message":"endpoint 'null://audit' call didn't satisfy postCondition: response == \"ok\"","status":"error"}`)
+	}
+
+	return f
+}
+
+func rulesUnparsablePreConditionFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "type": "order" }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+rules:
+  - name: orders
+    preCondition: '!='
+    action: |
+      uri: 'null://orders'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"parse-pre-condition"},"message":"rule 'orders': unexpected token Operator(\"!=\") (1:1)\n | !=\n | ^","status":"error"}`)
+	}
+
+	return f
+}
+
+func rulesInvalidMatchModeFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "type": "order" }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+matchMode: any-match
+rules:
+  - action: |
+      uri: 'null://orders'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"load-configuration"},"message":"[2:12] Key: 'Config.MatchMode' Error:Field validation for 'MatchMode' failed on the 'oneof' tag\n\u003e  2 | matchMode: any-match\n                  ^\n   3 | rules:\n   4 |   - action: |\n   5 |       uri: 'null://orders'\n   6 | ","status":"error"}`)
+	}
+
+	return f
+}
+
+func rulesEmptyActionFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "type": "order" }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+rules:
+  - name: orders
+    action: ''
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"load-configuration"},"message":"[4:13] Key: 'Rule.Action' Error:Field validation for 'Action' failed on the 'min' tag\n   2 | rules:\n   3 |   - name: orders\n\u003e  4 |     action: ''\n                   ^\n","status":"error"}`)
+	}
+
+	return f
+}
+
+func fanOutSequentialFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  - uri: 'null://audit/{{ .data.id }}'
+    param1: 'foo'
+  - uri: 'null://notification/{{ .data.id }}'
+    param1: 'foo'
+  - uri: 'null://indexer/{{ .data.id }}'
+    param1: 'foo'
+
+postCondition: response == "ok"
+`
+	var uris []string
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		uris = append(uris, action.URI)
+
+		switch action.URI {
+		case "null://notification/42":
+			return nil, fmt.Errorf("connection refused")
+		case "null://indexer/42":
+			return "ko", nil
+		default:
+			return "ok", nil
+		}
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(uris, ShouldResemble, []string{"null://audit/42", "null://notification/42", "null://indexer/42"})
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://audit/42","stage":"match-post-condition","status":"success"},{"uri":"null://notification/42","stage":"do-action","status":"error","message":"connection refused"},{"uri":"null://indexer/42","stage":"match-post-condition","status":"error","message":"endpoint 'null://indexer/42' call didn't satisfy postCondition: response == \"ok\""}]},"message":"connection refused","status":"error"}`)
+	}
+
+	return f
+}
+
+func fanOutParallelFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+parallelism: 2
+action: |
+  {{- range $i, $e := until 5 }}
+  - uri: 'null://endpoint/{{ $i }}'
+    param1: 'foo'
+  {{- end }}
+
+postCondition: response == "ok"
+`
+	var (
+		running    int32
+		maxRunning int32
+	)
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(maxRunning, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://endpoint/0","stage":"match-post-condition","status":"success"},{"uri":"null://endpoint/1","stage":"match-post-condition","status":"success"},{"uri":"null://endpoint/2","stage":"match-post-condition","status":"success"},{"uri":"null://endpoint/3","stage":"match-post-condition","status":"success"},{"uri":"null://endpoint/4","stage":"match-post-condition","status":"success"}]},"message":"5 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func fanOutEmptyListFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  {{ list | toJson }}
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"build-action"},"message":"no action specified","status":"error"}`)
+	}
+
+	return f
+}
+
+func retrySucceededFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  uri: 'null://audit'
+  param1: 'foo'
+postCondition: response == "ok"
+retry:
+  maxAttempts: 3
+  initialInterval: 10
+  multiplier: 2
+  jitter: 0.1
+`
+	attempts := 0
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		attempts++
+		if attempts < 3 {
+			return nil, fmt.Errorf("connection refused")
+		}
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(attempts, ShouldEqual, 3)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://audit","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func retryExhaustedFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  uri: 'null://audit'
+  param1: 'foo'
+retry:
+  maxAttempts: 2
+  initialInterval: 10
+`
+	attempts := 0
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		attempts++
+
+		return nil, fmt.Errorf("connection refused (attempt %d)", attempts)
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(attempts, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://audit","stage":"do-action","status":"error","message":"connection refused (attempt 2)"}]},"message":"connection refused (attempt 2)","status":"error"}`)
+	}
+
+	return f
+}
+
+func retryOnResponseFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  uri: 'null://audit'
+  param1: 'foo'
+postCondition: response == "ok"
+retry:
+  maxAttempts: 5
+  initialInterval: 5
+  retryOn: response == "busy" and attempt < 3
+`
+	attempts := 0
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		attempts++
+
+		return "busy", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(attempts, ShouldEqual, 3)
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://audit","stage":"match-post-condition","status":"error","message":"endpoint 'null://audit' call didn't satisfy postCondition: response == \"ok\""}]},"message":"endpoint 'null://audit' call didn't satisfy postCondition: response == \"ok\"","status":"error"}`)
+	}
+
+	return f
+}
+
+func retryWithTimeoutFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+timeout: 100
+action: |
+  uri: 'null://audit'
+  param1: 'foo'
+retry:
+  maxAttempts: 5
+  initialInterval: 5000
+`
+	attempts := 0
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		attempts++
+
+		return nil, fmt.Errorf("connection refused")
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(attempts, ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://audit","stage":"do-action","status":"error","message":"connection refused"}]},"message":"connection refused","status":"error"}`)
+	}
+
+	return f
+}
+
+func unparsableRetryOnFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  uri: 'null://audit'
+  param1: 'foo'
+retry:
+  maxAttempts: 2
+  retryOn: '!='
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"parse-retry-on"},"message":"unexpected token Operator(\"!=\") (1:1)\n | !=\n | ^","status":"error"}`)
+	}
+
+	return f
+}
+
+func circuitOpenFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  - uri: 'null://circuit-open-fixture/1'
+    param1: 'foo'
+  - uri: 'null://circuit-open-fixture/2'
+    param1: 'foo'
+  - uri: 'null://circuit-open-fixture/3'
+    param1: 'foo'
+postCondition: response == "ok"
+retry:
+  maxAttempts: 3
+circuitBreaker:
+  failureThreshold: 2
+  coolDown: 60000
+`
+	attempts := 0
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		attempts++
+
+		return nil, fmt.Errorf("connection refused")
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(attempts, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://circuit-open-fixture/1","stage":"do-action","status":"error","message":"connection refused"},{"uri":"null://circuit-open-fixture/2","stage":"circuit-open","status":"error","message":"circuit open for host 'circuit-open-fixture'"},{"uri":"null://circuit-open-fixture/3","stage":"circuit-open","status":"error","message":"circuit open for host 'circuit-open-fixture'"}]},"message":"connection refused","status":"error"}`)
+	}
+
+	return f
+}
+
+func circuitOpenOnUnsatisfiedPostConditionFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  - uri: 'null://circuit-open-post-condition-fixture/1'
+    param1: 'foo'
+  - uri: 'null://circuit-open-post-condition-fixture/2'
+    param1: 'foo'
+postCondition: response == "ok"
+circuitBreaker:
+  failureThreshold: 1
+  coolDown: 60000
+`
+	attempts := 0
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		attempts++
+
+		// the backend replies, but with an error
+		return "internal server error", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(attempts, ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://circuit-open-post-condition-fixture/1","stage":"match-post-condition","status":"error","message":"endpoint 'null://circuit-open-post-condition-fixture/1' call didn't satisfy postCondition: response == \"ok\""},{"uri":"null://circuit-open-post-condition-fixture/2","stage":"circuit-open","status":"error","message":"circuit open for host 'circuit-open-post-condition-fixture'"}]},"message":"endpoint 'null://circuit-open-post-condition-fixture/1' call didn't satisfy postCondition: response == \"ok\"","status":"error"}`)
+	}
+
+	return f
+}
+
+func circuitOpenFromPreviousInvocationsFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  uri: 'null://circuit-open-previous-fixture'
+  param1: 'foo'
+circuitBreaker:
+  failureThreshold: 1
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig,
+		func(f engineFixture) func() {
+			circuitBreakers.get("circuit-open-previous-fixture").open(time.Now())
+
+			return noop
+		})
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		So("action shall not be invoked", ShouldBeEmpty)
+
+		return nil, nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"circuit-open","outcomes":[{"uri":"null://circuit-open-previous-fixture","stage":"circuit-open","status":"error","message":"circuit open for host 'circuit-open-previous-fixture'"}]},"message":"circuit open for host 'circuit-open-previous-fixture'","status":"error"}`)
+	}
+
+	return f
+}
+
+func hmacFixture(signature string, secret string, assert func(*httptest.ResponseRecorder)) engineFixture {
+	payload := `{ "action": "opened" }`
+	req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+authentication:
+  hmac:
+    scheme: github
+    secret: webhookKey
+
+preCondition: data.action == "opened"
+
+action: |
+  uri: 'null://127.0.0.1'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.secret = secret
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig, arrangeSecret,
+		func(f engineFixture) func() {
+			if signature != "" {
+				f.fnReq.Header.Set("X-Hub-Signature-256", signature)
+			}
+
+			return noop
+		})
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return "ok", nil
+	}
+	f.assert = assert
+
+	return f
+}
+
+func hmacAuthenticatedFixture() engineFixture {
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	_, _ = mac.Write([]byte(`{ "action": "opened" }`))
+
+	return hmacFixture("sha256="+hex.EncodeToString(mac.Sum(nil)), `webhookKey: s3cr3t`, func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://127.0.0.1","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	})
+}
+
+func hmacInvalidSignatureFixture() engineFixture {
+	return hmacFixture("sha256=0123456789abcdef", `webhookKey: s3cr3t`, func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"authenticate"},"message":"invalid signature","status":"fail"}`)
+	})
+}
+
+func hmacMissingSignatureFixture() engineFixture {
+	return hmacFixture("", `webhookKey: s3cr3t`, func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"authenticate"},"message":"missing signature header X-Hub-Signature-256","status":"fail"}`)
+	})
+}
+
+func hmacNoKeyInSecretFixture() engineFixture {
+	return hmacFixture("sha256=0123456789abcdef", `otherKey: s3cr3t`, func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"authenticate"},"message":"no key 'webhookKey' found in secret","status":"error"}`)
+	})
+}
+
+func hmacInvalidSchemeFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{}`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+authentication:
+  hmac:
+    scheme: gitlab
+    secret: webhookKey
+
+action: |
+  uri: 'null://127.0.0.1'
+  param1: 'foo'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"load-configuration"},"message":"[4:13] Key: 'HMAC.Scheme' Error:Field validation for 'Scheme' failed on the 'oneof' tag\n   2 | authentication:\n   3 |   hmac:\n\u003e  4 |     scheme: gitlab\n                   ^\n   5 |     secret: webhookKey\n   6 | \n   7 | action: |\n   8 | ","status":"error"}`)
+	}
+
+	return f
+}
+
+func jwtFixture(scope string, assert func(*httptest.ResponseRecorder)) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	So(err, ShouldBeNil)
+
+	token := signToken(jwt.SigningMethodRS256, key, "", jwt.MapClaims{
+		"iss":   "https://issuer.example.com",
+		"sub":   "producer",
+		"scope": scope,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+authentication:
+  jwt:
+    publicKey: producerKey
+    issuer: https://issuer.example.com
+
+preCondition: auth.claims.scope contains "orders"
+
+action: |
+  uri: 'null://127.0.0.1/{{ .auth.claims.sub }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.secret = "producerKey: |\n  " + strings.ReplaceAll(strings.TrimSpace(rsaPublicKeyPEM(key)), "\n", "\n  ") + "\n"
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig, arrangeSecret,
+		func(f engineFixture) func() {
+			f.fnReq.Header.Set("Authorization", "Bearer "+token)
+
+			return noop
+		})
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		So(action.URI, ShouldEqual, "null://127.0.0.1/producer")
+
+		return "ok", nil
+	}
+	f.assert = assert
+
+	return f
+}
+
+func jwtAuthorizedFixture() engineFixture {
+	return jwtFixture("orders:read orders:write", func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://127.0.0.1/producer","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	})
+}
+
+func jwtNotAuthorizedFixture() engineFixture {
+	return jwtFixture("users:read", func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-pre-condition"},"message":"unsatisfied condition","status":"success"}`)
+	})
+}
+
+func jwtNotAuthenticatedFixture() engineFixture {
+	f := jwtFixture("orders:read", nil)
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig, arrangeSecret)
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"authenticate"},"message":"missing bearer token","status":"fail"}`)
+	}
+
+	return f
+}
+
+func payloadFixture(mediaType, payload, preCondition, uri, expectedURI string) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = fmt.Sprintf(`
+accept:
+  - application/json
+  - application/x-www-form-urlencoded
+  - application/xml
+  - application/yaml
+  - text/plain
+
+preCondition: |
+  %s
+
+action: |
+  uri: '%s'
+  param1: 'foo'
+
+postCondition: true
+`, preCondition, uri)
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(mediaType), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, fmt.Sprintf(`{"data":{"stage":"match-post-condition","outcomes":[{"uri":"%s","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`, expectedURI))
+	}
+
+	return f
+}
+
+func jsonArrayPayloadFixture() engineFixture {
+	return payloadFixture(util.MediaTypeApplicationJSON, `[{ "id": 1 }, { "id": 2 }]`,
+		`len(data) == 2`,
+		`null://orders/{{ (index .data 1).id }}`,
+		`null://orders/2`)
+}
+
+func formPayloadFixture() engineFixture {
+	f := payloadFixture("application/x-www-form-urlencoded; charset=utf-8", `token=abc&text=hello+world&tags=a&tags=b`,
+		`data.token == "abc" and len(data.tags) == 2`,
+		`null://slack/{{ .data.text | urlquery }}`,
+		`null://slack/hello+world`)
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		So(action.URI, ShouldEqual, "null://slack/hello+world")
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	}
+
+	return f
+}
+
+func xmlPayloadFixture() engineFixture {
+	return payloadFixture("application/atom+xml", `<order id="42"><item>foo</item><item>bar</item></order>`,
+		`data.order["-id"] == "42"`,
+		`null://orders/{{ index .data.order "-id" }}/{{ index .data.order.item 1 }}`,
+		`null://orders/42/bar`)
+}
+
+func yamlPayloadFixture() engineFixture {
+	return payloadFixture("application/yaml", "order:\n  id: 42\n",
+		`data.order.id == 42`,
+		`null://orders/{{ .data.order.id }}`,
+		`null://orders/42`)
+}
+
+func textPayloadFixture() engineFixture {
+	return payloadFixture(util.MediaTypeTextPlain, "deploy",
+		`data == "deploy"`,
+		`null://commands/{{ .data }}`,
+		`null://commands/deploy`)
+}
+
+func notAcceptedMediaTypeFixture() engineFixture {
+	f := payloadFixture("application/pdf", "%PDF", "true", "null://", "null://")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"check-content-type"},"message":"unsupported media type. Expected: application/json, application/x-www-form-urlencoded, application/xml, application/yaml, text/plain","status":"fail"}`)
+	}
+
+	return f
+}
+
+func invalidXMLPayloadFixture() engineFixture {
+	f := payloadFixture("application/xml", "<order>", "true", "null://", "null://")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"parse-payload"},"message":"XML syntax error on line 1: unexpected EOF","status":"fail"}`)
+	}
+
+	return f
+}
+
+func undecodableAcceptFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{}`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+accept:
+  - application/pdf
+
+action: |
+  uri: 'null://'
+  param1: 'foo'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"load-configuration"},"message":"[3:3] Key: 'Config.Accept' Error:Field validation for 'Accept' failed on the 'decodable' tag\n   2 | accept:\n\u003e  3 |   - application/pdf\n         ^\n   5 | action: |\n   6 | ","status":"error"}`)
+	}
+
+	return f
+}
+
+func cloudEventFixture(contentType, payload string, headers map[string]string, assert func(*httptest.ResponseRecorder)) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+preCondition: |
+  event.type == "com.example.order.created" and event.subject == nil
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: '{{ .event.id }}'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(contentType), arrangeConfig,
+		func(f engineFixture) func() {
+			for k, v := range headers {
+				f.fnReq.Header.Set(k, v)
+			}
+
+			return noop
+		})
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		So(action.Param1, ShouldEqual, "A234-1234-1234")
+
+		return "ok", nil
+	}
+	f.assert = assert
+
+	return f
+}
+
+func cloudEventBinaryModeFixture() engineFixture {
+	return cloudEventFixture(util.MediaTypeApplicationJSON, `{ "id": 42 }`,
+		map[string]string{
+			"ce-specversion": "1.0",
+			"ce-id":          "A234-1234-1234",
+			"ce-type":        "com.example.order.created",
+			"ce-source":      "/orders",
+		},
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+		})
+}
+
+func cloudEventStructuredModeFixture() engineFixture {
+	return cloudEventFixture(MediaTypeApplicationCloudEventsJSON,
+		`{ "specversion": "1.0", "id": "A234-1234-1234", "type": "com.example.order.created", "source": "/orders", "data": { "id": 42 } }`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+		})
+}
+
+func cloudEventInvalidFixture() engineFixture {
+	return cloudEventFixture(MediaTypeApplicationCloudEventsJSON,
+		`{ "specversion": "1.0", "id": "A234-1234-1234", "source": "/orders", "data": { "id": 42 } }`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"parse-payload"},"message":"invalid CloudEvent: missing attribute 'type'","status":"fail"}`)
+		})
+}
+
+func requestMetadataFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/hooks?ref=main", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	req.Header.Set("X-GitHub-Event", "push")
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+preCondition: |
+  request.Method == "POST" and request.Header.Get("x-github-event") == "push" and request.ID != ""
+
+action: |
+  uri: 'null://{{ .request.Header.Get "X-Github-Event" }}{{ .request.Path }}/{{ .request.Query.Get "ref" }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://push/hooks/main","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func replyFixture(reply string, actionErr error, assert func(rr *httptest.ResponseRecorder)) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`token=abc&command=%2Fdeploy&text=kynaptik`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+accept:
+  - application/x-www-form-urlencoded
+
+preCondition: |
+  data.command == "/deploy"
+
+action: |
+  uri: 'null://deploy/{{ .data.text }}'
+  param1: 'foo'
+
+postCondition: |
+  response == "created"
+
+reply:
+` + reply
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders("application/x-www-form-urlencoded"), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		if actionErr != nil {
+			return nil, actionErr
+		}
+
+		return "created", nil
+	}
+	f.assert = assert
+
+	return f
+}
+
+func replySuccessFixture() engineFixture {
+	return replyFixture(`
+  body: |
+    {"response_type": "in_channel", "text": "deploying {{ .data.text }} ({{ .stage }}, {{ .response }})"}
+`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Header().Get(util.HeaderContentType), ShouldEqual, util.MediaTypeApplicationJSON)
+			So(rr.Body.String(), ShouldEqual, `{"response_type": "in_channel", "text": "deploying kynaptik (match-post-condition, created)"}
+`)
+		})
+}
+
+func replyStatusCodeAndHeadersFixture() engineFixture {
+	return replyFixture(`
+  statusCode: |
+    response == "created" ? 201 : 200
+  headers:
+    Location: '/deployments/{{ .data.text }}'
+  body: 'deploying {{ .data.text }}'
+`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusCreated)
+			So(rr.Header().Get("Location"), ShouldEqual, "/deployments/kynaptik")
+			So(rr.Header().Get(util.HeaderContentType), ShouldEqual, "text/plain; charset=utf-8")
+			So(rr.Body.String(), ShouldEqual, `deploying kynaptik`)
+		})
+}
+
+func replyOnFailureFixture() engineFixture {
+	return replyFixture(`
+  statusCode: |
+    statusCode >= 500 ? 200 : statusCode
+  body: 'failed to deploy {{ .data.text }} ({{ .stage }}): {{ .message }}'
+  onFailure: true
+`,
+		fmt.Errorf("connection refused"),
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Body.String(), ShouldEqual, `failed to deploy kynaptik (do-action): connection refused`)
+		})
+}
+
+func replyNotOnFailureFixture() engineFixture {
+	return replyFixture(`
+  body: 'deploying {{ .data.text }}'
+`,
+		fmt.Errorf("connection refused"),
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusBadGateway)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://deploy/kynaptik","stage":"do-action","status":"error","message":"connection refused"}]},"message":"connection refused","status":"error"}`)
+		})
+}
+
+func replyIncorrectStatusCodeFixture() engineFixture {
+	return replyFixture(`
+  statusCode: |
+    "created"
+  body: 'deploying {{ .data.text }}'
+`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"reply","outcomes":[{"uri":"null://deploy/kynaptik","stage":"match-post-condition","status":"success"}]},"message":"incorrect type string returned when evaluating expression '\"created\"\n'. Expected 'integer'","status":"error"}`)
+		})
+}
+
+func replyUnparsableStatusCodeFixture() engineFixture {
+	return replyFixture(`
+  statusCode: |
+    201 +
+`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"parse-reply"},"message":"unexpected token EOF (1:6)\n | 201 +\n | .....^","status":"error"}`)
+		})
+}
+
+func replyBadTemplateFixture() engineFixture {
+	return replyFixture(`
+  body: 'deploying {{ .data.text '
+`,
+		nil,
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"reply","outcomes":[{"uri":"null://deploy/kynaptik","stage":"match-post-condition","status":"success"}]},"message":"template: body:1: unclosed action","status":"error"}`)
+		})
+}
+
+func asyncFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	var calls int32
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+async: true
+timeout: 1000
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		rr := actDefault(f)
+
+		So(atomic.LoadInt32(&calls), ShouldEqual, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		So(Drain(ctx), ShouldBeNil)
+
+		return rr
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		// the action outlives the incoming request, but is bounded by its own timeout
+		if _, ok := ctx.Deadline(); ok {
+			time.Sleep(100 * time.Millisecond)
+			atomic.AddInt32(&calls, 1)
+		}
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusAccepted)
+		So(rr.Body.String(), ShouldEqual, fmt.Sprintf(`{"data":{"stage":"accepted","requestId":"%s"},"message":"invocation accepted","status":"success"}`, rr.Header().Get("Request-Id")))
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+	}
+
+	return f
+}
+
+func deadLetterFixture(
+	actionBehaviour func(action protoAction, ctx context.Context) (interface{}, error),
+	assert func(rr *httptest.ResponseRecorder),
+) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+
+postCondition: |
+  response == "created"
+
+deadLetter:
+  action: |
+    uri: 'null://dead-letter/{{ .data.id }}'
+    param1: {{ printf "%s: %s (%v)" .stage .error .response | toJson }}
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = actionBehaviour
+	f.assert = assert
+
+	return f
+}
+
+func deadLetterOnActionErrorFixture() engineFixture {
+	var deadLetters []string
+
+	return deadLetterFixture(
+		func(action protoAction, ctx context.Context) (interface{}, error) {
+			if action.URI == "null://dead-letter/42" {
+				deadLetters = append(deadLetters, action.Param1)
+
+				return "ok", nil
+			}
+
+			return nil, fmt.Errorf("connection refused")
+		},
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusBadGateway)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://orders/42","stage":"do-action","status":"error","message":"connection refused","deadLetter":"success"}]},"message":"connection refused","status":"error"}`)
+			So(deadLetters, ShouldResemble, []string{"do-action: connection refused (<nil>)"})
+		})
+}
+
+func deadLetterOnPostConditionFixture() engineFixture {
+	var deadLetters []string
+
+	return deadLetterFixture(
+		func(action protoAction, ctx context.Context) (interface{}, error) {
+			if action.URI == "null://dead-letter/42" {
+				deadLetters = append(deadLetters, action.Param1)
+
+				return "ok", nil
+			}
+
+			return "conflict", nil
+		},
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusBadGateway)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"error","message":"endpoint 'null://orders/42' call didn't satisfy postCondition: response == \"created\"\n\n","deadLetter":"success"}]},"message":"endpoint 'null://orders/42' call didn't satisfy postCondition: response == \"created\"\n\n","status":"error"}`)
+			So(deadLetters, ShouldResemble, []string{
+				"match-post-condition: endpoint 'null://orders/42' call didn't satisfy postCondition: response == \"created\"\n\n (conflict)",
+			})
+		})
+}
+
+func deadLetterFailedFixture() engineFixture {
+	return deadLetterFixture(
+		func(action protoAction, ctx context.Context) (interface{}, error) {
+			return nil, fmt.Errorf("connection refused")
+		},
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusBadGateway)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://orders/42","stage":"do-action","status":"error","message":"connection refused","deadLetter":"error"}]},"message":"connection refused","status":"error"}`)
+		})
+}
+
+func deadLetterNotOnSuccessFixture() engineFixture {
+	var deadLetters []string
+
+	return deadLetterFixture(
+		func(action protoAction, ctx context.Context) (interface{}, error) {
+			if action.URI == "null://dead-letter/42" {
+				deadLetters = append(deadLetters, action.Param1)
+			}
+
+			return "created", nil
+		},
+		func(rr *httptest.ResponseRecorder) {
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+			So(deadLetters, ShouldBeEmpty)
+		})
+}
+
+func idempotencyFixture(store string) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	key := fmt.Sprintf("key-%d", rand.Int63())
+	calls := 0
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = fmt.Sprintf(`
+idempotency:
+  key: request.Header.Get("Idempotency-Key")
+  store: %s
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+
+postCondition: true
+`, store)
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		f.fnReq.Header.Set("Idempotency-Key", key)
+		first := actDefault(f)
+
+		So(first.Code, ShouldEqual, http.StatusOK)
+		So(first.Header().Get(HeaderIdempotentReplayed), ShouldBeEmpty)
+
+		req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+		So(err, ShouldBeNil)
+
+		req.Header = f.fnReq.Header.Clone()
+		f.fnReq = req
+
+		second := actDefault(f)
+
+		So(second.Header().Get("Request-Id"), ShouldNotEqual, first.Header().Get("Request-Id"))
+		So(second.Body.String(), ShouldEqual, first.Body.String())
+
+		return second
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Header().Get(HeaderIdempotentReplayed), ShouldEqual, "true")
+		So(rr.Header().Get(util.HeaderContentType), ShouldEqual, util.MediaTypeApplicationJSON)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func idempotencyMemoryStoreFixture() engineFixture {
+	return idempotencyFixture(IdempotencyStoreMemory)
+}
+
+func idempotencyFileStoreFixture() engineFixture {
+	return idempotencyFixture(IdempotencyStoreFile)
+}
+
+func idempotencyNotOnErrorFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	id := rand.Int63()
+	calls := 0
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+idempotency:
+  key: data.id
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		var rr *httptest.ResponseRecorder
+
+		for i := 0; i < 2; i++ {
+			req, err := http.NewRequest("POST", "/", strings.NewReader(fmt.Sprintf(`{ "id": %d }`, id)))
+			So(err, ShouldBeNil)
+
+			req.Header = f.fnReq.Header.Clone()
+			f.fnReq = req
+
+			rr = actDefault(f)
+		}
+
+		return rr
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return nil, fmt.Errorf("connection refused")
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Header().Get(HeaderIdempotentReplayed), ShouldBeEmpty)
+	}
+
+	return f
+}
+
+func idempotencyUnparsableKeyFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+idempotency:
+  key: data.id +
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"parse-idempotency-key"},"message":"unexpected token EOF (1:9)\n | data.id +\n | ........^","status":"error"}`)
+	}
+
+	return f
+}
+
+func idempotencyConcurrentDuplicateFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	key := fmt.Sprintf("key-%d", rand.Int63())
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var calls int32
+	var first, third *httptest.ResponseRecorder
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+idempotency:
+  key: request.Header.Get("Idempotency-Key")
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		f.fnReq.Header.Set("Idempotency-Key", key)
+
+		newRequest := func() engineFixture {
+			req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+			So(err, ShouldBeNil)
+
+			req.Header = f.fnReq.Header.Clone()
+			f.fnReq = req
+
+			return f
+		}
+
+		done := make(chan struct{})
+
+		go func(f engineFixture) {
+			defer close(done)
+
+			first = actDefault(f)
+		}(newRequest())
+
+		// the duplicate is received while the first event is being processed
+		<-started
+		second := actDefault(newRequest())
+
+		close(release)
+		<-done
+
+		third = actDefault(newRequest())
+
+		return second
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+		}
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusConflict)
+		So(rr.Body.String(), ShouldEqual, fmt.Sprintf(`{"data":{"stage":"idempotency"},"message":"event with key '%s' is being processed","status":"fail"}`, key))
+		So(first.Code, ShouldEqual, http.StatusOK)
+		So(first.Header().Get(HeaderIdempotentReplayed), ShouldBeEmpty)
+		So(third.Code, ShouldEqual, http.StatusOK)
+		So(third.Header().Get(HeaderIdempotentReplayed), ShouldEqual, "true")
+		So(third.Body.String(), ShouldEqual, first.Body.String())
+	}
+
+	return f
+}
+
+func idempotencyAsyncFixture() engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	key := fmt.Sprintf("key-%d", rand.Int63())
+
+	var calls int32
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+async: true
+idempotency:
+  key: request.Header.Get("Idempotency-Key")
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		f.fnReq.Header.Set("Idempotency-Key", key)
+		first := actDefault(f)
+
+		So(first.Code, ShouldEqual, http.StatusAccepted)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		So(Drain(ctx), ShouldBeNil)
+
+		req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+		So(err, ShouldBeNil)
+
+		req.Header = f.fnReq.Header.Clone()
+		f.fnReq = req
+
+		return actDefault(f)
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		atomic.AddInt32(&calls, 1)
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		// the outcome of the actions performed in the background is remembered, not the acknowledgment
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Header().Get(HeaderIdempotentReplayed), ShouldEqual, "true")
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func idempotencyPerFunctionFixture() engineFixture {
+	id := rand.Int63()
+	calls := 0
+
+	f := idempotencyFixture(IdempotencyStoreMemory)
+	f.config = `
+idempotency:
+  key: data.id
+
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		// the functions of a same namespace don't share their keys
+		first := invokeAsFunction(f, "orders", fmt.Sprintf(`{ "id": %d }`, id))
+		So(first.Code, ShouldEqual, http.StatusOK)
+
+		return invokeAsFunction(f, "invoices", fmt.Sprintf(`{ "id": %d }`, id))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Header().Get(HeaderIdempotentReplayed), ShouldBeEmpty)
+	}
+
+	return f
+}
+
+func idempotencyNotOnRateLimitedFixture() engineFixture {
+	tenant := rand.Int63()
+	id := rand.Int63()
+	calls := 0
+
+	var codes []int
+
+	f := rateLimitFixture(`
+idempotency:
+  key: data.id
+rateLimit:
+  rate: 20
+  burst: 1
+  key: data.tenant
+limitMode: reject
+`)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		var rr *httptest.ResponseRecorder
+
+		for i, payload := range []string{
+			fmt.Sprintf(`{ "tenant": "%d", "id": %d }`, tenant, rand.Int63()),
+			fmt.Sprintf(`{ "tenant": "%d", "id": %d }`, tenant, id),
+			fmt.Sprintf(`{ "tenant": "%d", "id": %d }`, tenant, id),
+		} {
+			if i == 2 {
+				// the token bucket is refilled
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			rr = invokeWithPayloads(f, payload)
+			codes = append(codes, rr.Code)
+		}
+
+		return rr
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(codes, ShouldResemble, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK})
+		So(calls, ShouldEqual, 2)
+		So(rr.Header().Get(HeaderIdempotentReplayed), ShouldBeEmpty)
+	}
+
+	return f
+}
+
+func orderingFixture(sequence string) engineFixture {
+	req, err := http.NewRequest("POST", "/", nil)
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = fmt.Sprintf(`
+ordering:
+  key: data.orderId
+  sequence: %s
+
+action: |
+  uri: 'null://orders/{{ .data.orderId }}/{{ .data.version }}'
+  param1: 'foo'
+
+postCondition: true
+`, sequence)
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+
+	return f
+}
+
+// invokeWithPayloads performs an invocation for each of the given payloads, returning the last response.
+func invokeWithPayloads(f engineFixture, payloads ...string) *httptest.ResponseRecorder {
+	var rr *httptest.ResponseRecorder
+
+	for _, payload := range payloads {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+		So(err, ShouldBeNil)
+
+		req.Header = f.fnReq.Header.Clone()
+		f.fnReq = req
+
+		rr = actDefault(f)
+	}
+
+	return rr
+}
+
+// invokeAsFunction performs an invocation of the function of the given name with the given payload.
+func invokeAsFunction(f engineFixture, name string, payload string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+	So(err, ShouldBeNil)
+
+	req.Header = f.fnReq.Header.Clone()
+	req.Header.Set("X-Fission-Function-Name", name)
+	f.fnReq = req
+
+	return actDefault(f)
+}
+
+func orderingSerializedFixture() engineFixture {
+	orderID := rand.Int31()
+
+	var running, maxRunning, calls int32
+
+	f := orderingFixture("")
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		var wg sync.WaitGroup
+
+		for i := 0; i < 5; i++ {
+			req, err := http.NewRequest("POST", "/", strings.NewReader(fmt.Sprintf(`{ "orderId": %d, "version": %d }`, orderID, i)))
+			So(err, ShouldBeNil)
+
+			req.Header = f.fnReq.Header.Clone()
+
+			wg.Add(1)
+
+			go func(f engineFixture) {
+				defer wg.Done()
+
+				actDefault(f)
+			}(engineFixture{appFS: f.appFS, fnReq: req, actionBehaviour: f.actionBehaviour})
+		}
+
+		wg.Wait()
+
+		return httptest.NewRecorder()
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&calls, 1)
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(atomic.LoadInt32(&calls), ShouldEqual, 5)
+		So(atomic.LoadInt32(&maxRunning), ShouldEqual, 1)
+	}
+
+	return f
+}
+
+func orderingStaleEventFixture() engineFixture {
+	orderID := rand.Int31()
+
+	var versions []string
+
+	f := orderingFixture("data.version")
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "orderId": %d, "version": 2 }`, orderID),
+			fmt.Sprintf(`{ "orderId": %d, "version": 1 }`, orderID))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		versions = append(versions, path.Base(action.URI))
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(versions, ShouldResemble, []string{"2"})
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"ordering"},"message":"stale event dropped (sequence 1 lower than 2)","status":"success"}`)
+	}
+
+	return f
+}
+
+func orderingNotStaleEventFixture() engineFixture {
+	orderID := rand.Int31()
+
+	var versions []string
+
+	f := orderingFixture("data.version")
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "orderId": %d, "version": 1 }`, orderID),
+			fmt.Sprintf(`{ "orderId": %d, "version": 1 }`, orderID),
+			fmt.Sprintf(`{ "orderId": %d, "version": 3 }`, orderID),
+			fmt.Sprintf(`{ "orderId": %d, "version": 2 }`, orderID+1))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		versions = append(versions, path.Base(action.URI))
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(versions, ShouldResemble, []string{"1", "1", "3", "2"})
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	}
+
+	return f
+}
+
+func orderingPerFunctionFixture() engineFixture {
+	orderID := rand.Int31()
+
+	var versions []string
+
+	f := orderingFixture("data.version")
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		// the functions of a same namespace don't share their partitions
+		first := invokeAsFunction(f, "orders", fmt.Sprintf(`{ "orderId": %d, "version": 2 }`, orderID))
+		So(first.Code, ShouldEqual, http.StatusOK)
+
+		return invokeAsFunction(f, "invoices", fmt.Sprintf(`{ "orderId": %d, "version": 1 }`, orderID))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		versions = append(versions, path.Base(action.URI))
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(versions, ShouldResemble, []string{"2", "1"})
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldNotContainSubstring, "stale event dropped")
+	}
+
+	return f
+}
+
+func orderingWaitTimeoutFixture() engineFixture {
+	orderID := rand.Int31()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var calls int32
+
+	f := orderingFixture("")
+	f.config = "timeout: 100\n" + f.config
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(fmt.Sprintf(`{ "orderId": %d, "version": 1 }`, orderID)))
+		So(err, ShouldBeNil)
+
+		req.Header = f.fnReq.Header.Clone()
+		done := make(chan struct{})
+
+		go func(f engineFixture) {
+			defer close(done)
+
+			actDefault(f)
+		}(engineFixture{appFS: f.appFS, fnReq: req, actionBehaviour: f.actionBehaviour})
+
+		// the partition is held by the first event when the second one is received
+		<-started
+		rr := invokeWithPayloads(f, fmt.Sprintf(`{ "orderId": %d, "version": 2 }`, orderID))
+
+		close(release)
+		<-done
+
+		return rr
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+		}
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, fmt.Sprintf(`{"data":{"stage":"ordering"},"message":"partition '%v' not available: context deadline exceeded","status":"error"}`, float64(orderID)))
+	}
+
+	return f
+}
+
+func orderingIncomparableSequencesFixture() engineFixture {
+	orderID := rand.Int31()
+
+	f := orderingFixture("data.version")
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "orderId": %d, "version": 1 }`, orderID),
+			fmt.Sprintf(`{ "orderId": %d, "version": "2" }`, orderID))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"ordering"},"message":"incomparable sequences 2 (string) and 1 (float64)","status":"fail"}`)
+	}
+
+	return f
+}
+
+func rateLimitFixture(limits string) engineFixture {
+	req, err := http.NewRequest("POST", "/", nil)
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = limits + `
+action: |
+  uri: 'null://tenants/{{ .data.tenant }}'
+  param1: 'foo'
+
+postCondition: true
+`
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+
+	return f
+}
+
+func rateLimitRejectFixture() engineFixture {
+	tenant := rand.Int63()
+	calls := 0
+
+	f := rateLimitFixture(`
+rateLimit:
+  rate: 0.001
+  burst: 2
+  key: data.tenant
+limitMode: reject
+`)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant),
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant),
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(rr.Body.String(), ShouldEqual, fmt.Sprintf(`{"data":{"stage":"rate-limited","outcomes":[{"uri":"null://tenants/%d","stage":"rate-limited","status":"fail","message":"rate limit exceeded (0.001/s)"}]},"message":"rate limit exceeded (0.001/s)","status":"fail"}`, tenant))
+	}
+
+	return f
+}
+
+func rateLimitPerKeyFixture() engineFixture {
+	calls := 0
+
+	f := rateLimitFixture(`
+rateLimit:
+  rate: 0.001
+  burst: 1
+  key: data.tenant
+limitMode: reject
+`)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "tenant": "%d" }`, rand.Int63()),
+			fmt.Sprintf(`{ "tenant": "%d" }`, rand.Int63()))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	}
+
+	return f
+}
+
+func rateLimitPerFunctionFixture() engineFixture {
+	tenant := rand.Int63()
+	calls := 0
+
+	f := rateLimitFixture(`
+rateLimit:
+  rate: 0.001
+  burst: 1
+  key: data.tenant
+limitMode: reject
+`)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		var rr *httptest.ResponseRecorder
+
+		// the functions of a same namespace don't share their limits
+		for _, name := range []string{"orders", "invoices"} {
+			req, err := http.NewRequest("POST", "/", strings.NewReader(fmt.Sprintf(`{ "tenant": "%d" }`, tenant)))
+			So(err, ShouldBeNil)
+
+			req.Header = f.fnReq.Header.Clone()
+			req.Header.Set("X-Fission-Function-Name", name)
+			f.fnReq = req
+
+			rr = actDefault(f)
+		}
+
+		return rr
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	}
+
+	return f
+}
+
+func rateLimitWaitFixture() engineFixture {
+	tenant := rand.Int63()
+	calls := 0
+
+	var elapsed time.Duration
+
+	f := rateLimitFixture(`
+rateLimit:
+  rate: 20
+  burst: 1
+  key: data.tenant
+timeout: 5000
+`)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		start := time.Now()
+		defer func() { elapsed = time.Since(start) }()
+
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant),
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant),
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 3)
+		So(elapsed, ShouldBeGreaterThanOrEqualTo, 90*time.Millisecond)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	}
+
+	return f
+}
+
+func rateLimitWaitExceedingTimeoutFixture() engineFixture {
+	tenant := rand.Int63()
+	calls := 0
+
+	f := rateLimitFixture(`
+rateLimit:
+  rate: 0.001
+  key: data.tenant
+timeout: 100
+`)
+	f.act = func(f engineFixture) *httptest.ResponseRecorder {
+		return invokeWithPayloads(f,
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant),
+			fmt.Sprintf(`{ "tenant": "%d" }`, tenant))
+	}
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		calls++
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(calls, ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(rr.Body.String(), ShouldContainSubstring, `"stage":"rate-limited"`)
+	}
+
+	return f
+}
+
+func maxConcurrencyRejectFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+parallelism: 3
+maxConcurrency: 1
+limitMode: reject
+action: |
+  {{- range $i, $e := until 3 }}
+  - uri: 'null://endpoint/{{ $i }}'
+    param1: 'foo'
+  {{- end }}
+
+postCondition: response == "ok"
+`
+	var calls int32
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		So(rr.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(strings.Count(rr.Body.String(), `"stage":"rate-limited","status":"fail","message":"concurrency limit exceeded (1)"`), ShouldEqual, 2)
+	}
+
+	return f
+}
+
+func maxConcurrencyWaitFixture() engineFixture {
+	req, err := http.NewRequest("GET", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = `
+parallelism: 3
+maxConcurrency: 2
+action: |
+  {{- range $i, $e := until 4 }}
+  - uri: 'null://endpoint/{{ $i }}'
+    param1: 'foo'
+  {{- end }}
+
+postCondition: response == "ok"
+`
+	var running, maxRunning int32
+
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(atomic.LoadInt32(&maxRunning), ShouldEqual, 2)
+		So(rr.Code, ShouldEqual, http.StatusOK)
+	}
+
+	return f
+}
+
+func dryRunFixture(config string, dryRun string) engineFixture {
+	return dryRunWithSecretFixture(config, `
+token: s3cr3t-t0k3n
+`, dryRun)
+}
+
+func dryRunWithSecretFixture(config string, secret string, dryRun string) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42, "type": "order" }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = config
+	f.secret = secret
+	f.arrange = arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig, arrangeSecret,
+		func(f engineFixture) func() {
+			f.fnReq.Header.Set(HeaderDryRun, dryRun)
+
+			return noop
+		})
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		So("action shall not be invoked", ShouldBeEmpty)
+
+		return nil, nil
+	}
+
+	return f
+}
+
+func dryRunSuccessFixture() engineFixture {
+	f := dryRunFixture(`
+dryRun: true
+idempotency:
+  key: data.id
+preCondition: data.type == "order"
+action: |
+  uri: 'null://orders/{{ .data.id }}?token={{ .secret.token }}'
+  param1: 'Bearer {{ .secret.token }}'
+  param2: {{ .data.id }}
+`, "true")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"dry-run","dryRun":{"preConditions":[{"expression":"data.type == \"order\"","result":true}],"actions":[{"action":{"param1":"Bearer *****","param2":42,"paramjson3":"","uri":"null://orders/42?token=*****"}}],"environment":["auth","config","data","event","request","secret"]}},"message":"dry run, no action performed","status":"success"}`)
+	}
+
+	return f
+}
+
+func dryRunDerivedSecretsFixture() engineFixture {
+	f := dryRunWithSecretFixture(`
+dryRun: true
+action: |
+  uri: 'null://{{ .secret.user }}:{{ .secret.password | urlquery }}@orders/{{ .data.id }}'
+  param1: 'Basic {{ printf "%s:%s" .secret.user .secret.password | b64enc }}'
+  param2: {{ .secret.pin }}
+  paramjson3: '{"key":"{{ .secret.password | b64enc }}"}'
+`, `
+user: alice
+password: p@ss/w0rd?
+pin: 1234
+`, "true")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"dry-run","dryRun":{"preConditions":[{"expression":"true","result":true}],"actions":[{"action":{"param1":"Basic *****","param2":"*****","paramjson3":"{\"key\":\"*****\"}","uri":"null://*****@orders/42"}}],"environment":["auth","config","data","event","request","secret"]}},"message":"dry run, no action performed","status":"success"}`)
+	}
+
+	return f
+}
+
+func dryRunRulesFixture() engineFixture {
+	f := dryRunFixture(`
+dryRun: true
+matchMode: all-match
+rules:
+  - name: orders
+    preCondition: data.type == "order"
+    action: |
+      - uri: 'null://orders/{{ .data.id }}'
+        param1: 'foo'
+      - uri: 'null://audit/{{ .data.id }}'
+        param1: 'bar'
+  - name: users
+    preCondition: data.type == "user"
+    action: |
+      uri: 'null://users/{{ .data.id }}'
+      param1: 'foo'
+`, "true")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"dry-run","rules":["orders"],"dryRun":{"preConditions":[{"rule":"orders","expression":"data.type == \"order\"","result":true},{"rule":"users","expression":"data.type == \"user\"","result":false}],"actions":[{"rule":"orders","action":{"param1":"foo","param2":0,"paramjson3":"","uri":"null://orders/42"}},{"rule":"orders","action":{"param1":"bar","param2":0,"paramjson3":"","uri":"null://audit/42"}}],"environment":["auth","config","data","event","request","secret"]}},"message":"dry run, no action performed","status":"success"}`)
+	}
+
+	return f
+}
+
+func dryRunUnsatisfiedPreConditionFixture() engineFixture {
+	f := dryRunFixture(`
+dryRun: true
+preCondition: data.type == "user"
+action: |
+  uri: 'null://users/{{ .data.id }}'
+  param1: 'foo'
+`, "true")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-pre-condition","dryRun":{"preConditions":[{"expression":"data.type == \"user\"","result":false}],"environment":["auth","config","data","event","request","secret"]}},"message":"unsatisfied condition","status":"success"}`)
+	}
+
+	return f
+}
+
+func dryRunInvalidActionFixture() engineFixture {
+	f := dryRunFixture(`
+dryRun: true
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'f'
+`, "true")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"build-action"},"message":"[2:9] Key: 'protoAction.Param1' Error:Field validation for 'Param1' failed on the 'min' tag\n   1 | uri: 'null://orders/42'\n\u003e  2 | param1: 'f'\n               ^\n","status":"error"}`)
+	}
+
+	return f
+}
+
+func dryRunNotEnabledFixture() engineFixture {
+	f := dryRunFixture(`
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+`, "true")
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusForbidden)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"dry-run"},"message":"dry run not enabled","status":"fail"}`)
+	}
+
+	return f
+}
+
+func dryRunNotRequestedFixture() engineFixture {
+	f := dryRunFixture(`
+dryRun: true
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+postCondition: true
+`, "false")
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return "ok", nil
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"}]},"message":"1 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+// echoAction is an action replying with its message, for testing the dispatching of actions.
+type echoAction struct {
+	URI     string `yaml:"uri" validate:"required,uri,scheme=echo"`
+	Message string `yaml:"message" validate:"required"`
+}
+
+func (a echoAction) DoAction(ctx context.Context) (interface{}, error) { return a.Message, nil }
+func (a echoAction) MarshalZerologObject(e *zerolog.Event)             { e.Str("uri", a.URI) }
+func (a echoAction) GetURI() string                                    { return a.URI }
+
+// arrangeActionKinds installs a registry of action kinds containing the proto (null scheme) and echo kinds.
+func arrangeActionKinds(f engineFixture) func() {
+	previous := actionKinds
+
+	actionKinds = newActionKindRegistry()
+	actionKinds.register(ActionKind{
+		Name:          "proto",
+		Schemes:       []string{"null"},
+		ConfigFactory: func() Config { return Config{PostCondition: `response == "ok"`} },
+		ActionFactory: func() Action { return &protoAction{doAction: f.actionBehaviour} },
+	})
+	actionKinds.register(ActionKind{
+		Name:          "echo",
+		Schemes:       []string{"echo"},
+		ConfigFactory: func() Config { return Config{PostCondition: `response != ""`} },
+		ActionFactory: func() Action { return &echoAction{} },
+	})
+
+	return func() {
+		actionKinds = previous
+	}
+}
+
+func actAny(f engineFixture) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+
+	http.
+		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			InvokeAnyλ(w, r, f.appFS)
+		}).
+		ServeHTTP(rr, f.fnReq)
+
+	return rr
+}
+
+func actionKindsFixture(config string) engineFixture {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{ "id": 42 }`))
+	So(err, ShouldBeNil)
+
+	f := engineFixture{}
+
+	f.appFS = afero.NewMemMapFs()
+	f.fnReq = req
+	f.config = config
+	f.act = actAny
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return "ok", nil
+	}
+	f.arrange = func() func() {
+		return arrangeWith(f, arrangeTime, arrangeReqNamespaceHeaders, arrangeReqContentTypeHeaders(util.MediaTypeApplicationJSON), arrangeConfig, arrangeActionKinds)()
+	}
+
+	return f
+}
+
+func actionKindsDispatchedFixture() engineFixture {
+	f := actionKindsFixture(`
+action: |
+  - uri: 'null://orders/{{ .data.id }}'
+    param1: 'foo'
+  - uri: 'echo://orders/{{ .data.id }}'
+    message: 'hello'
+`)
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusOK)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"success"},{"uri":"echo://orders/42","stage":"match-post-condition","status":"success"}]},"message":"2 action(s) succeeded","status":"success"}`)
+	}
+
+	return f
+}
+
+func actionKindsPostConditionFixture() engineFixture {
+	f := actionKindsFixture(`
+postCondition: response == "hello"
+action: |
+  - uri: 'null://orders/{{ .data.id }}'
+    param1: 'foo'
+  - uri: 'echo://orders/{{ .data.id }}'
+    message: 'hello'
+`)
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"match-post-condition","outcomes":[{"uri":"null://orders/42","stage":"match-post-condition","status":"error","message":"endpoint 'null://orders/42' call didn't satisfy postCondition: response == \"hello\""},{"uri":"echo://orders/42","stage":"match-post-condition","status":"success"}]},"message":"endpoint 'null://orders/42' call didn't satisfy postCondition: response == \"hello\"","status":"error"}`)
+	}
+
+	return f
+}
+
+func actionKindsUnknownSchemeFixture() engineFixture {
+	f := actionKindsFixture(`
+action: |
+  uri: 'ftp://orders/{{ .data.id }}'
+  param1: 'foo'
+`)
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"build-action"},"message":"no kind of action registered for scheme 'ftp' (uri 'ftp://orders/42')","status":"error"}`)
+	}
+
+	return f
+}
+
+func actionKindsInvalidActionFixture() engineFixture {
+	f := actionKindsFixture(`
+action: |
+  uri: 'echo://orders/{{ .data.id }}'
+  message: ''
+`)
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"build-action"},"message":"[2:10] Key: 'echoAction.Message' Error:Field validation for 'Message' failed on the 'required' tag\n   1 | uri: 'echo://orders/42'\n\u003e  2 | message: ''\n                ^\n","status":"error"}`)
+	}
+
+	return f
+}
+
+func deadLetterAnyKindFixture() engineFixture {
+	f := actionKindsFixture(`
+deadLetter:
+  action: |
+    uri: 'echo://dead-letter/{{ .data.id }}'
+    message: '{{ .error }}'
+action: |
+  uri: 'null://orders/{{ .data.id }}'
+  param1: 'foo'
+postCondition: response == "ok"
+`)
+	f.act = actDefault
+	f.actionBehaviour = func(action protoAction, ctx context.Context) (i interface{}, e error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	f.assert = func(rr *httptest.ResponseRecorder) {
+		So(rr.Code, ShouldEqual, http.StatusBadGateway)
+		So(rr.Body.String(), ShouldEqual, `{"data":{"stage":"do-action","outcomes":[{"uri":"null://orders/42","stage":"do-action","status":"error","message":"connection refused","deadLetter":"success"}]},"message":"connection refused","status":"error"}`)
 	}
 
 	return f
@@ -956,6 +3450,88 @@ func TestEngine(t *testing.T) {
 			successfulInvocationWithSecretFixture,
 			invocationWithTimeoutFixture,
 			crappyCallerFixture,
+			rulesFirstMatchFixture,
+			rulesAllMatchFixture,
+			rulesUnsatisfiedPostConditionFixture,
+			rulesUnparsablePreConditionFixture,
+			rulesInvalidMatchModeFixture,
+			rulesEmptyActionFixture,
+			fanOutSequentialFixture,
+			fanOutParallelFixture,
+			fanOutEmptyListFixture,
+			retrySucceededFixture,
+			retryExhaustedFixture,
+			retryOnResponseFixture,
+			retryWithTimeoutFixture,
+			unparsableRetryOnFixture,
+			circuitOpenFixture,
+			circuitOpenOnUnsatisfiedPostConditionFixture,
+			circuitOpenFromPreviousInvocationsFixture,
+			hmacAuthenticatedFixture,
+			hmacInvalidSignatureFixture,
+			hmacMissingSignatureFixture,
+			hmacNoKeyInSecretFixture,
+			hmacInvalidSchemeFixture,
+			jwtAuthorizedFixture,
+			jwtNotAuthorizedFixture,
+			jwtNotAuthenticatedFixture,
+			jsonArrayPayloadFixture,
+			formPayloadFixture,
+			xmlPayloadFixture,
+			yamlPayloadFixture,
+			textPayloadFixture,
+			notAcceptedMediaTypeFixture,
+			invalidXMLPayloadFixture,
+			undecodableAcceptFixture,
+			cloudEventBinaryModeFixture,
+			cloudEventStructuredModeFixture,
+			cloudEventInvalidFixture,
+			requestMetadataFixture,
+			replySuccessFixture,
+			replyStatusCodeAndHeadersFixture,
+			replyOnFailureFixture,
+			replyNotOnFailureFixture,
+			replyIncorrectStatusCodeFixture,
+			replyUnparsableStatusCodeFixture,
+			replyBadTemplateFixture,
+			asyncFixture,
+			deadLetterOnActionErrorFixture,
+			deadLetterOnPostConditionFixture,
+			deadLetterFailedFixture,
+			deadLetterNotOnSuccessFixture,
+			idempotencyMemoryStoreFixture,
+			idempotencyFileStoreFixture,
+			idempotencyNotOnErrorFixture,
+			idempotencyUnparsableKeyFixture,
+			idempotencyConcurrentDuplicateFixture,
+			idempotencyAsyncFixture,
+			idempotencyPerFunctionFixture,
+			idempotencyNotOnRateLimitedFixture,
+			orderingSerializedFixture,
+			orderingStaleEventFixture,
+			orderingNotStaleEventFixture,
+			orderingIncomparableSequencesFixture,
+			orderingPerFunctionFixture,
+			orderingWaitTimeoutFixture,
+			rateLimitRejectFixture,
+			rateLimitPerKeyFixture,
+			rateLimitPerFunctionFixture,
+			rateLimitWaitFixture,
+			rateLimitWaitExceedingTimeoutFixture,
+			maxConcurrencyRejectFixture,
+			maxConcurrencyWaitFixture,
+			dryRunSuccessFixture,
+			dryRunDerivedSecretsFixture,
+			dryRunRulesFixture,
+			dryRunUnsatisfiedPreConditionFixture,
+			dryRunInvalidActionFixture,
+			dryRunNotEnabledFixture,
+			dryRunNotRequestedFixture,
+			actionKindsDispatchedFixture,
+			actionKindsPostConditionFixture,
+			actionKindsUnknownSchemeFixture,
+			actionKindsInvalidActionFixture,
+			deadLetterAnyKindFixture,
 		}
 
 		for _, fixtureSupplier := range fixtures {
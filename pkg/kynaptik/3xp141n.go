@@ -0,0 +1,352 @@
+package kynaptik
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/gamegos/jsend"
+	"github.com/goccy/go-yaml"
+	"github.com/justinas/alice"
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	// HeaderDryRun is the header requesting a dry run of the invocation: the actions are built but not performed.
+	HeaderDryRun = "X-Kynaptik-Dry-Run"
+	// maskedSecret is the replacement of the secret values in the dry run reports.
+	maskedSecret = "*****"
+	// minMaskedSubstringLength is the minimum length of the secret values masked wherever they occur, the shorter ones
+	// being masked only when they are the whole value.
+	minMaskedSubstringLength = 4
+)
+
+// sensitiveFields are the (lower case) names of the fields whose values are masked whatever they are, e.g. the
+// credentials headers, the authentication options or the TLS private keys of the actions.
+var sensitiveFields = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+	"auth":                true,
+	"password":            true,
+	"token":               true,
+	"credentials":         true,
+	"nkeyseed":            true,
+	"clientkeydata":       true,
+}
+
+// userInfoPattern matches the user information (e.g. user:password) of the URIs.
+var userInfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s]+@`)
+
+// DryRunReport reports what an invocation would do, without performing the actions.
+type DryRunReport struct {
+	// PreConditions specifies the result of the evaluation of the preConditions.
+	PreConditions []DryRunPreCondition `json:"preConditions"`
+	// Actions specifies the actions which would be performed, as rendered (the secret values being masked).
+	Actions []DryRunAction `json:"actions,omitempty"`
+	// Environment specifies the names of the entries of the environment available to the expressions and templates.
+	Environment []string `json:"environment"`
+}
+
+// DryRunPreCondition specifies the result of the evaluation of a preCondition.
+type DryRunPreCondition struct {
+	// Rule specifies the name of the rule the preCondition comes from (if rules are configured).
+	Rule string `json:"rule,omitempty"`
+	// Expression is the expression of the preCondition.
+	Expression string `json:"expression"`
+	// Result is the result of the evaluation.
+	Result bool `json:"result"`
+}
+
+// DryRunAction specifies an action which would be performed.
+type DryRunAction struct {
+	// Rule specifies the name of the rule the action comes from (if rules are configured).
+	Rule string `json:"rule,omitempty"`
+	// Action is the action, as rendered.
+	Action interface{} `json:"action"`
+}
+
+// addPreCondition records the result of the evaluation of the preCondition of the given rule.
+func (d *DryRunReport) addPreCondition(rule *rule, result bool) {
+	p := DryRunPreCondition{Expression: rule.PreCondition, Result: result}
+	if rule.explicit {
+		p.Rule = rule.Name
+	}
+
+	d.PreConditions = append(d.PreConditions, p)
+}
+
+// dryRunHandler handles the dry run requests (if enabled): the preConditions are evaluated and the actions are built
+// (but not performed), then reported along with the environment.
+func dryRunHandler(actionFactory ActionFactory) alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.EqualFold(r.Header.Get(HeaderDryRun), "true") {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			if !r.Context().Value(ctxKeyConfig).(Config).DryRun {
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusForbidden).
+					Message("dry run not enabled").
+					Data(&ResponseData{Stage: "dry-run"}).
+					Send()
+				return
+			}
+
+			env := r.Context().Value(ctxKeyEnv).(environment)
+
+			report := &DryRunReport{Environment: make([]string, 0, len(env))}
+			for k := range env {
+				report.Environment = append(report.Environment, k)
+			}
+
+			sort.Strings(report.Environment)
+
+			hlog.
+				FromRequest(r).
+				Info().
+				Msg("🧪 dry run")
+
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyDryRun, report))
+
+			// the actions are built the usual way, the rest of the chain (idempotency, ordering, actions...) being
+			// skipped.
+			alice.
+				New(
+					matchPreConditionHandler(),
+					buildActionHandler(actionFactory),
+				).
+				Then(dryRunReportHandler()).
+				ServeHTTP(w, r)
+		})
+	}
+}
+
+// dryRunReportHandler reports the actions built, with the secret values masked.
+func dryRunReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := r.Context().Value(ctxKeyDryRun).(*DryRunReport)
+		tasks := r.Context().Value(ctxKeyTasks).([]*task)
+		secret, _ := r.Context().Value(ctxKeySecret).(map[string]interface{})
+		masker := newSecretMasker(secret)
+
+		for _, t := range tasks {
+			action, err := renderDryRunAction(t.action, masker)
+			if err != nil {
+				_, _ = jsend.
+					Wrap(w).
+					Status(http.StatusInternalServerError).
+					Message(t.rule.wrapError(err).Error()).
+					Data(&ResponseData{Stage: "dry-run"}).
+					Send()
+				return
+			}
+
+			a := DryRunAction{Action: action}
+			if t.rule.explicit {
+				a.Rule = t.rule.Name
+			}
+
+			report.Actions = append(report.Actions, a)
+		}
+
+		_, _ = jsend.
+			Wrap(w).
+			Status(http.StatusOK).
+			Message("dry run, no action performed").
+			Data(&ResponseData{Stage: "dry-run", Rules: firedRuleNames(r.Context().Value(ctxKeyMatchedRules).([]*rule)), DryRun: report}).
+			Send()
+	})
+}
+
+// renderDryRunAction returns the (generic) representation of the given action, with the secret values masked.
+func renderDryRunAction(action Action, masker *secretMasker) (interface{}, error) {
+	spec, err := yaml.Marshal(action)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+
+	return masker.mask(doc), nil
+}
+
+// secretMasker masks the secret values, along with the values derived from them (e.g. encoded), and the values of the
+// sensitive fields whatever they are.
+type secretMasker struct {
+	// substrings are masked wherever they occur, the longest first.
+	substrings []string
+	// values are masked only when they are the whole value, i.e. the secret values too short to be masked wherever
+	// they occur without masking unrelated parts.
+	values map[string]bool
+}
+
+// newSecretMasker returns the masker of the values of the given secret.
+func newSecretMasker(secret interface{}) *secretMasker {
+	m := &secretMasker{values: map[string]bool{}}
+
+	values := secretValues(secret)
+	forms := map[string]bool{}
+
+	for _, v := range values {
+		for _, form := range derivedForms(v) {
+			forms[form] = true
+		}
+
+		// e.g. the credentials of a basic authentication: printf "%s:%s" .secret.user .secret.password | b64enc
+		for _, other := range values {
+			forms[base64.StdEncoding.EncodeToString([]byte(v+":"+other))] = true
+		}
+	}
+
+	for form := range forms {
+		if len(form) < minMaskedSubstringLength {
+			m.values[form] = true
+		} else {
+			m.substrings = append(m.substrings, form)
+		}
+	}
+
+	sort.Slice(m.substrings, func(i, j int) bool {
+		if len(m.substrings[i]) != len(m.substrings[j]) {
+			return len(m.substrings[i]) > len(m.substrings[j])
+		}
+
+		return m.substrings[i] < m.substrings[j]
+	})
+
+	return m
+}
+
+// secretValues returns the (scalar) values of the given secret, as strings, e.g. the numeric PIN codes.
+func secretValues(secret interface{}) []string {
+	var values []string
+
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch t := v.(type) {
+		case nil, bool:
+		case string:
+			if t != "" {
+				values = append(values, t)
+			}
+		case map[string]interface{}:
+			for _, e := range t {
+				collect(e)
+			}
+		case []interface{}:
+			for _, e := range t {
+				collect(e)
+			}
+		default:
+			values = append(values, fmt.Sprint(t))
+		}
+	}
+
+	collect(secret)
+
+	return values
+}
+
+// derivedForms returns the given secret value along with the forms it is commonly rendered with by the templates, i.e.
+// encoded in base64 (b64enc), url encoded (urlquery) or escaped in JSON (toJson).
+func derivedForms(v string) []string {
+	forms := []string{
+		v,
+		base64.StdEncoding.EncodeToString([]byte(v)),
+		base64.URLEncoding.EncodeToString([]byte(v)),
+		base64.RawStdEncoding.EncodeToString([]byte(v)),
+		base64.RawURLEncoding.EncodeToString([]byte(v)),
+		url.QueryEscape(v),
+		url.PathEscape(v),
+		template.URLQueryEscaper(v),
+	}
+
+	if escaped, err := json.Marshal(v); err == nil {
+		forms = append(forms, strings.Trim(string(escaped), `"`))
+	}
+
+	return forms
+}
+
+// mask returns the given (generic) value with the secret values masked.
+func (m *secretMasker) mask(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool:
+		return v
+	case string:
+		return m.maskString(t)
+	case map[string]interface{}:
+		for k, e := range t {
+			if sensitiveFields[strings.ToLower(k)] {
+				t[k] = maskAll(e)
+			} else {
+				t[k] = m.mask(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = m.mask(e)
+		}
+	default:
+		// e.g. a numeric secret rendered as a number
+		if s := fmt.Sprint(t); m.maskString(s) != s {
+			return m.maskString(s)
+		}
+	}
+
+	return v
+}
+
+// maskString returns the given string with the secret values masked, along with the credentials of the URIs.
+func (m *secretMasker) maskString(s string) string {
+	if m.values[s] {
+		return maskedSecret
+	}
+
+	for _, secret := range m.substrings {
+		s = strings.ReplaceAll(s, secret, maskedSecret)
+	}
+
+	return userInfoPattern.ReplaceAllString(s, "${1}"+maskedSecret+"@")
+}
+
+// maskAll returns the given (generic) value with all its (non empty) values masked.
+func maskAll(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool:
+		return v
+	case string:
+		if t == "" {
+			return t
+		}
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = maskAll(e)
+		}
+
+		return t
+	case []interface{}:
+		for i, e := range t {
+			t[i] = maskAll(e)
+		}
+
+		return t
+	}
+
+	return maskedSecret
+}
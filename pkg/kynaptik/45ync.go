@@ -0,0 +1,121 @@
+package kynaptik
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gamegos/jsend"
+	"github.com/justinas/alice"
+	"github.com/rs/zerolog/hlog"
+)
+
+// inFlight keeps track of the invocations running in the background (asynchronous mode).
+var inFlight sync.WaitGroup
+
+// Drain waits for the invocations running in the background (asynchronous mode) to complete, or for the context to be
+// done, whichever comes first. It is intended to be called on shutdown, once no more request is accepted.
+func Drain(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// detachedContext is a context carrying the values of its parent, but neither its deadline nor its cancellation, so
+// that the work started during a request can outlive it.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key interface{}) interface{}     { return c.parent.Value(key) }
+
+// backgroundResponseWriter is the http.ResponseWriter the invocations running in the background report to, keeping
+// track of the response for logging purpose.
+type backgroundResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newBackgroundResponseWriter() *backgroundResponseWriter {
+	return &backgroundResponseWriter{header: http.Header{}, statusCode: http.StatusOK}
+}
+
+func (w *backgroundResponseWriter) Header() http.Header         { return w.header }
+func (w *backgroundResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *backgroundResponseWriter) WriteHeader(statusCode int)  { w.statusCode = statusCode }
+
+// asyncHandler acknowledges the request (202) and runs the remaining handlers in the background, when the
+// asynchronous mode is enabled. The reply remembered for the event (see idempotencyHandler) is the one of the handlers
+// run in the background.
+func asyncHandler() alice.Constructor {
+	return func(Ͱ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config := r.Context().Value(ctxKeyConfig).(Config)
+
+			if !config.Async {
+				Ͱ.ServeHTTP(w, r)
+				return
+			}
+
+			var id string
+			if v, ok := hlog.IDFromRequest(r); ok {
+				id = v.String()
+			}
+
+			br := r.Clone(detachedContext{parent: r.Context()})
+			bw := newBackgroundResponseWriter()
+			remember := deferIdempotentOutcome(r)
+
+			inFlight.Add(1)
+
+			go func() {
+				defer inFlight.Done()
+
+				Ͱ.ServeHTTP(bw, br)
+				remember(bw.statusCode, bw.header, bw.body.Bytes())
+
+				e := hlog.
+					FromRequest(br).
+					Info().
+					Int("status", bw.statusCode)
+
+				if json.Valid(bw.body.Bytes()) {
+					e = e.RawJSON("outcome", bw.body.Bytes())
+				} else {
+					e = e.Str("outcome", bw.body.String())
+				}
+
+				e.Msg("🏁️ background invocation done")
+			}()
+
+			hlog.
+				FromRequest(r).
+				Info().
+				Msg("☑️️ invocation accepted")
+
+			_, _ = jsend.
+				Wrap(w).
+				Status(http.StatusAccepted).
+				Message("invocation accepted").
+				Data(&ResponseData{Stage: "accepted", RequestID: id}).
+				Send()
+		})
+	}
+}
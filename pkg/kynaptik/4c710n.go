@@ -1,8 +1,14 @@
 package kynaptik
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"io/ioutil"
 
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-yaml"
 	"github.com/rs/zerolog"
 )
 
@@ -14,4 +20,67 @@ type Action interface {
 }
 
 // ActionFactory denotes functions able to return new instances of actions.
+// A nil ActionFactory denotes the kinds of action registered (see RegisterActionKind), selected according to the
+// scheme of the uri of the actions.
 type ActionFactory func() Action
+
+// decodeActions decodes the actions from the given (yaml) specification, which can be either a single action or a
+// list of actions, each action being decoded with the ActionFactory given by the resolver.
+func decodeActions(in io.Reader, resolve actionResolver, validate *validator.Validate) ([]Action, error) {
+	spec, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err = yaml.Unmarshal(spec, &doc); err == nil {
+		if items, ok := doc.([]interface{}); ok {
+			return decodeActionList(items, resolve, validate)
+		}
+	}
+
+	actionFactory, rerr := resolve(doc)
+	if rerr != nil {
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, rerr
+	}
+
+	action := actionFactory()
+	if err := yaml.NewDecoder(bytes.NewReader(spec), yaml.Validator(validate)).Decode(action); err != nil {
+		return nil, err
+	}
+
+	return []Action{action}, nil
+}
+
+func decodeActionList(items []interface{}, resolve actionResolver, validate *validator.Validate) ([]Action, error) {
+	if len(items) == 0 {
+		return nil, errors.New("no action specified")
+	}
+
+	actions := make([]Action, 0, len(items))
+
+	for _, item := range items {
+		spec, err := yaml.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		actionFactory, err := resolve(item)
+		if err != nil {
+			return nil, err
+		}
+
+		action := actionFactory()
+		if err := yaml.NewDecoder(bytes.NewReader(spec), yaml.Validator(validate)).Decode(action); err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
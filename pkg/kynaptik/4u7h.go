@@ -0,0 +1,76 @@
+package kynaptik
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Authentication specifies how the incoming requests are authenticated. No authentication by default.
+type Authentication struct {
+	// HMAC specifies the verification of the signature of the incoming requests.
+	HMAC HMAC `yaml:"hmac"`
+	// JWT specifies the verification of the bearer token of the incoming requests.
+	JWT JWT `yaml:"jwt"`
+}
+
+// enabled returns true if an authentication is required.
+func (a Authentication) enabled() bool {
+	return a.HMAC.enabled() || a.JWT.enabled()
+}
+
+// MarshalZerologObject produces logs related to the authentication.
+func (a Authentication) MarshalZerologObject(e *zerolog.Event) {
+	if a.HMAC.enabled() {
+		e.Object("hmac", a.HMAC)
+	}
+
+	if a.JWT.enabled() {
+		e.Object("jwt", a.JWT)
+	}
+}
+
+// authenticationError is the error returned when an incoming request fails to be authenticated.
+type authenticationError struct {
+	reason string
+}
+
+func (e authenticationError) Error() string {
+	return e.reason
+}
+
+func unauthenticated(format string, a ...interface{}) error {
+	return authenticationError{reason: fmt.Sprintf(format, a...)}
+}
+
+// authenticate authenticates the incoming request, given its raw payload and the secret loaded, returning the
+// authentication details exposed in the environment (i.e. the claims of the token). The returned error is an
+// authenticationError if the request is not authenticated.
+func authenticate(
+	a Authentication,
+	r *http.Request,
+	payload []byte,
+	secret map[string]interface{},
+	now time.Time,
+) (map[string]interface{}, error) {
+	auth := map[string]interface{}{}
+
+	if a.HMAC.enabled() {
+		if err := a.HMAC.verify(r.Header, payload, secret, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.JWT.enabled() {
+		claims, err := a.JWT.verify(r.Header, secret, now)
+		if err != nil {
+			return nil, err
+		}
+
+		auth["claims"] = claims
+	}
+
+	return auth, nil
+}
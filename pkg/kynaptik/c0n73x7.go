@@ -3,12 +3,21 @@ package kynaptik
 type ctxKey string
 
 var (
-	ctxKeyValidate             = ctxKey("validate")
-	ctxKeyConfig               = ctxKey("config")
-	ctxKeySecret               = ctxKey("secret")
-	ctxKeyPreConditionProgram  = ctxKey("pre-condition-program")
-	ctxKeyPostConditionProgram = ctxKey("post-condition-program")
-	ctxKeyData                 = ctxKey("data")
-	ctxKeyEnv                  = ctxKey("environment")
-	ctxKeyAction               = ctxKey("action")
+	ctxKeyValidate            = ctxKey("validate")
+	ctxKeyConfig              = ctxKey("config")
+	ctxKeyPrograms            = ctxKey("programs")
+	ctxKeySecret              = ctxKey("secret")
+	ctxKeyAuth                = ctxKey("auth")
+	ctxKeyRules               = ctxKey("rules")
+	ctxKeyRetryOnProgram      = ctxKey("retry-on-program")
+	ctxKeyReplyProgram        = ctxKey("reply-program")
+	ctxKeyRateLimitKeyProgram = ctxKey("rate-limit-key-program")
+	ctxKeyMediaType           = ctxKey("media-type")
+	ctxKeyData                = ctxKey("data")
+	ctxKeyEvent               = ctxKey("event")
+	ctxKeyEnv                 = ctxKey("environment")
+	ctxKeyMatchedRules        = ctxKey("matched-rules")
+	ctxKeyDryRun              = ctxKey("dry-run")
+	ctxKeyTasks               = ctxKey("tasks")
+	ctxKeyIdempotentOutcome   = ctxKey("idempotent-outcome")
 )
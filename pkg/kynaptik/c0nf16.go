@@ -3,26 +3,101 @@ package kynaptik
 import (
 	"time"
 
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/go-playground/validator/v10"
 	"github.com/rs/zerolog"
 )
 
+const (
+	// MatchModeFirst specifies that only the first rule whose preCondition is satisfied is fired.
+	MatchModeFirst = "first-match"
+	// MatchModeAll specifies that all the rules whose preCondition is satisfied are fired.
+	MatchModeAll = "all-match"
+)
+
 // Config specifies the configuration elements.
 type Config struct {
 	// PreCondition specifies the condition (textual) to be satisfied for the function to be triggered.
 	PreCondition string `yaml:"preCondition"`
-	// Action specifies the action to execute.
-	Action string `yaml:"action" validate:"min=5"`
+	// Action specifies the action to execute, or the list of actions to execute.
+	Action string `yaml:"action"`
 	// PostCondition specifies the condition (textual) to be satisfied for the response of the call be considered
 	// successful.
 	PostCondition string `yaml:"postCondition"`
+	// Rules specifies a list of rules, each one carrying its own preCondition, action and postCondition.
+	// When provided, the rules supersede the top-level action, and the top-level conditions are used as default
+	// values for the rules which don't specify them.
+	Rules []Rule `yaml:"rules" validate:"dive"`
+	// MatchMode specifies how the rules are fired: either only the first one matching (first-match), or all the
+	// ones matching (all-match). first-match by default.
+	MatchMode string `yaml:"matchMode" validate:"omitempty,oneof=first-match all-match"`
+	// Parallelism specifies the maximum number of actions executed concurrently when several actions are to be
+	// performed. A Parallelism of zero (or one) means the actions are executed sequentially.
+	Parallelism int `yaml:"parallelism" validate:"gte=0"`
+	// Retry specifies the policy for retrying the actions which failed. No retry by default.
+	Retry Retry `yaml:"retry"`
+	// CircuitBreaker specifies the circuit breaker applied to the actions, on a per-host basis.
+	// No circuit breaker by default.
+	CircuitBreaker CircuitBreaker `yaml:"circuitBreaker"`
+	// RateLimit specifies the rate limit applied to the actions. No rate limit by default.
+	RateLimit RateLimit `yaml:"rateLimit"`
+	// MaxConcurrency specifies the maximum number of actions performed concurrently (across invocations).
+	// A MaxConcurrency of zero means no limit.
+	MaxConcurrency int `yaml:"maxConcurrency" validate:"gte=0"`
+	// LimitMode specifies what happens to the actions exceeding the limits (rate limit or maximum concurrency): either
+	// they wait for their turn (bounded by the timeout), or they are rejected. wait by default.
+	LimitMode string `yaml:"limitMode" validate:"omitempty,oneof=wait reject"`
+	// Authentication specifies how the incoming requests are authenticated. No authentication by default.
+	Authentication Authentication `yaml:"authentication"`
+	// Idempotency specifies how the duplicated events are detected, so that they are not processed twice.
+	// No idempotency by default.
+	Idempotency Idempotency `yaml:"idempotency"`
+	// Ordering specifies how the events of a same partition are ordered. No ordering by default.
+	Ordering Ordering `yaml:"ordering"`
+	// DeadLetter specifies the action performed when an action failed (at the do-action or match-post-condition
+	// stages). No dead letter by default.
+	DeadLetter DeadLetter `yaml:"deadLetter"`
+	// Reply specifies the reply sent back to the caller in place of the JSend envelope. JSend envelope by default.
+	Reply Reply `yaml:"reply"`
+	// Accept specifies the media types accepted for the incoming requests, each one being decoded by the payload
+	// decoder registered for it (or for its structured syntax suffix, e.g. +json). application/json by default.
+	Accept []string `yaml:"accept"`
 	// MaxBodySize defines the maximum acceptable size (in bytes) of the incoming request body.
 	// A MaxBodySize of -1 means no limit.
 	MaxBodySize int64 `yaml:"maxBodySize" validate:"gte=-1"`
+	// Async specifies if the actions are performed in the background, the request being acknowledged (202) as soon as
+	// the actions are built. The outcome is then only logged.
+	Async bool `yaml:"async"`
+	// DryRun specifies if the dry runs are allowed, i.e. the requests carrying the X-Kynaptik-Dry-Run header, for which
+	// the actions are built and reported, but not performed.
+	DryRun bool `yaml:"dryRun"`
 	// Timeout specifies a time limit (in ms) for the action to proceed.
 	// A Timeout of zero means no timeout.
 	Timeout time.Duration `yaml:"timeout" validate:"gte=0"`
 }
 
+// Rule specifies a preCondition, an action and a postCondition working together.
+type Rule struct {
+	// Name specifies the name of the rule, as reported in the response.
+	Name string `yaml:"name"`
+	// PreCondition specifies the condition (textual) to be satisfied for the rule to be fired.
+	PreCondition string `yaml:"preCondition"`
+	// Action specifies the action to execute, or the list of actions to execute.
+	Action string `yaml:"action" validate:"min=5"`
+	// PostCondition specifies the condition (textual) to be satisfied for the response of the call be considered
+	// successful.
+	PostCondition string `yaml:"postCondition"`
+}
+
+// accept returns the media types accepted for the incoming requests.
+func (c Config) accept() []string {
+	if len(c.Accept) == 0 {
+		return []string{util.MediaTypeApplicationJSON}
+	}
+
+	return c.Accept
+}
+
 // ConfigFactory denotes functions able to return new instances of configurations.
 type ConfigFactory func() Config
 
@@ -30,5 +105,70 @@ type ConfigFactory func() Config
 func (c Config) MarshalZerologObject(e *zerolog.Event) {
 	e.
 		Str("preCondition", c.PreCondition).
-		Str("postCondition", c.PostCondition)
+		Str("postCondition", c.PostCondition).
+		Strs("accept", c.accept()).
+		Bool("async", c.Async).
+		Bool("dryRun", c.DryRun)
+
+	if c.Retry.enabled() {
+		e.Object("retry", c.Retry)
+	}
+
+	if c.CircuitBreaker.enabled() {
+		e.Object("circuitBreaker", c.CircuitBreaker)
+	}
+
+	if c.RateLimit.enabled() {
+		e.Object("rateLimit", c.RateLimit)
+	}
+
+	if c.MaxConcurrency > 0 {
+		e.Int("maxConcurrency", c.MaxConcurrency)
+	}
+
+	if c.RateLimit.enabled() || c.MaxConcurrency > 0 {
+		e.Str("limitMode", c.LimitMode)
+	}
+
+	if c.Authentication.enabled() {
+		e.Object("authentication", c.Authentication)
+	}
+
+	if c.Idempotency.enabled() {
+		e.Object("idempotency", c.Idempotency)
+	}
+
+	if c.Ordering.enabled() {
+		e.Object("ordering", c.Ordering)
+	}
+
+	if c.DeadLetter.enabled() {
+		e.Str("deadLetter", c.DeadLetter.Action)
+	}
+
+	if c.Reply.enabled() {
+		e.Object("reply", c.Reply)
+	}
+
+	if len(c.Rules) > 0 {
+		e.
+			Int("rules", len(c.Rules)).
+			Str("matchMode", c.MatchMode)
+	}
+}
+
+// validateConfig performs the validations of the configuration which can't be expressed with tags, i.e. the action
+// is mandatory unless rules are specified, and the media types accepted shall be decodable.
+func validateConfig(sl validator.StructLevel) {
+	c := sl.Current().Interface().(Config)
+
+	if len(c.Rules) == 0 && len(c.Action) < 5 {
+		sl.ReportError(c.Action, "Action", "Action", "min", "5")
+	}
+
+	for _, mediaType := range c.Accept {
+		if !decodable(mediaType) {
+			sl.ReportError(c.Accept, "Accept", "Accept", "decodable", mediaType)
+		}
+	}
 }
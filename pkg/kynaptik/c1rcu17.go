@@ -0,0 +1,175 @@
+package kynaptik
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultCoolDown specifies the default time (in ms) a circuit stays open before letting a trial call through.
+const DefaultCoolDown = 30000
+
+// CircuitBreaker specifies the circuit breaker applied to the actions, on a per-host basis.
+type CircuitBreaker struct {
+	// FailureThreshold specifies the number of consecutive failures opening the circuit.
+	// A FailureThreshold of zero means no circuit breaker.
+	FailureThreshold int `yaml:"failureThreshold" validate:"gte=0"`
+	// Window specifies the time window (in ms) within which the failures are counted.
+	// A Window of zero means no time window.
+	Window time.Duration `yaml:"window" validate:"gte=0"`
+	// CoolDown specifies the time (in ms) the circuit stays open before letting a trial call through.
+	CoolDown time.Duration `yaml:"coolDown" validate:"gte=0"`
+}
+
+// enabled returns true if the circuit breaker is enabled.
+func (p CircuitBreaker) enabled() bool {
+	return p.FailureThreshold > 0
+}
+
+// coolDown returns the cool-down duration to consider.
+func (p CircuitBreaker) coolDown() time.Duration {
+	if p.CoolDown == 0 {
+		return DefaultCoolDown * time.Millisecond
+	}
+
+	return p.CoolDown * time.Millisecond
+}
+
+// MarshalZerologObject produces logs related to the circuit breaker.
+func (p CircuitBreaker) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Int("failureThreshold", p.FailureThreshold).
+		Dur("window", p.Window*time.Millisecond).
+		Dur("coolDown", p.coolDown())
+}
+
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half-open"
+)
+
+// circuitOpenError is the error returned when a call is short-circuited.
+type circuitOpenError struct {
+	host string
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for host '%s'", e.host)
+}
+
+// circuitBreaker maintains the state of a circuit.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       circuitState
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	// trial tells if a trial call is in progress (half-open state)
+	trial bool
+}
+
+// allow tells whether a call can proceed or not, considering the state of the circuit. It returns the state of the
+// circuit as well.
+func (b *circuitBreaker) allow(p CircuitBreaker, now time.Time) (bool, circuitState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && now.Sub(b.openedAt) >= p.coolDown() {
+		b.state = circuitHalfOpen
+		b.trial = false
+	}
+
+	switch b.state {
+	case circuitOpen:
+		return false, b.state
+	case circuitHalfOpen:
+		if b.trial {
+			return false, b.state
+		}
+
+		b.trial = true
+
+		return true, b.state
+	default:
+		return true, b.state
+	}
+}
+
+// record records the result of a call, updating the state of the circuit accordingly. It returns the new state of
+// the circuit.
+func (b *circuitBreaker) record(p CircuitBreaker, now time.Time, success bool) circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = circuitClosed
+		b.failures = 0
+		b.trial = false
+
+		return b.state
+	}
+
+	if b.state == circuitHalfOpen {
+		b.open(now)
+
+		return b.state
+	}
+
+	if b.failures == 0 || (p.Window > 0 && now.Sub(b.windowStart) > p.Window*time.Millisecond) {
+		b.failures = 0
+		b.windowStart = now
+	}
+
+	b.failures++
+
+	if b.failures >= p.FailureThreshold {
+		b.open(now)
+	}
+
+	return b.state
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = circuitOpen
+	b.openedAt = now
+	b.failures = 0
+	b.trial = false
+}
+
+// circuitBreakerRegistry maintains the circuits, keyed by host. The registry lives in-process, across invocations.
+type circuitBreakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var circuitBreakers = &circuitBreakerRegistry{breakers: map[string]*circuitBreaker{}}
+
+// get returns the circuit for the given host, creating it if needed.
+func (r *circuitBreakerRegistry) get(host string) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[host]
+	if !ok {
+		b = &circuitBreaker{state: circuitClosed}
+		r.breakers[host] = b
+	}
+
+	return b
+}
+
+// circuitKey returns the key identifying the circuit for the given URI, i.e. its host.
+func circuitKey(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	return u.Host
+}
@@ -0,0 +1,98 @@
+package kynaptik
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	Convey("Considering a circuit breaker with a failure threshold of 2, a window of 1s and a cool-down of 10s", t, func(c C) {
+		p := CircuitBreaker{FailureThreshold: 2, Window: 1000, CoolDown: 10000}
+		b := &circuitBreaker{state: circuitClosed}
+		now := time.Now()
+
+		Convey("When a single failure occurs", func() {
+			state := b.record(p, now, false)
+
+			Convey("Then the circuit shall remain closed", func() {
+				So(state, ShouldEqual, circuitClosed)
+
+				ok, _ := b.allow(p, now)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When two failures occur outside the window", func() {
+			b.record(p, now, false)
+			state := b.record(p, now.Add(2*time.Second), false)
+
+			Convey("Then the circuit shall remain closed", func() {
+				So(state, ShouldEqual, circuitClosed)
+			})
+		})
+
+		Convey("When two failures occur separated by a success", func() {
+			b.record(p, now, false)
+			b.record(p, now, true)
+			state := b.record(p, now, false)
+
+			Convey("Then the circuit shall remain closed", func() {
+				So(state, ShouldEqual, circuitClosed)
+			})
+		})
+
+		Convey("When two failures occur within the window", func() {
+			b.record(p, now, false)
+			state := b.record(p, now.Add(500*time.Millisecond), false)
+
+			Convey("Then the circuit shall be open", func() {
+				So(state, ShouldEqual, circuitOpen)
+
+				Convey("And calls shall be rejected during the cool-down", func() {
+					ok, state := b.allow(p, now.Add(5*time.Second))
+
+					So(ok, ShouldBeFalse)
+					So(state, ShouldEqual, circuitOpen)
+				})
+
+				Convey("And a single trial call shall be let through after the cool-down", func() {
+					later := now.Add(11 * time.Second)
+
+					ok, state := b.allow(p, later)
+					So(ok, ShouldBeTrue)
+					So(state, ShouldEqual, circuitHalfOpen)
+
+					ok, state = b.allow(p, later)
+					So(ok, ShouldBeFalse)
+					So(state, ShouldEqual, circuitHalfOpen)
+
+					Convey("And the circuit shall be closed if the trial call succeeds", func() {
+						So(b.record(p, later, true), ShouldEqual, circuitClosed)
+
+						ok, _ := b.allow(p, later)
+						So(ok, ShouldBeTrue)
+					})
+
+					Convey("And the circuit shall be open again if the trial call fails", func() {
+						So(b.record(p, later, false), ShouldEqual, circuitOpen)
+
+						ok, _ := b.allow(p, later.Add(5*time.Second))
+						So(ok, ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestCircuitKey(t *testing.T) {
+	Convey("When computing the circuit key of a URI", t, func(c C) {
+		key := circuitKey("https://foo.bar:8443/path?query=1")
+
+		Convey("Then the key shall be the host of the URI", func() {
+			So(key, ShouldEqual, "foo.bar:8443")
+		})
+	})
+}
@@ -0,0 +1,215 @@
+package kynaptik
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/spf13/afero"
+)
+
+// resourceKey identifies a resource in the cache.
+type resourceKey struct {
+	fs        afero.Fs
+	folder    string
+	namespace string
+	name      string
+	// variant distinguishes the different decoded forms of a same resource (e.g. the default values of a
+	// configuration).
+	variant string
+}
+
+// resourceEntry is a cached resource, along with the information allowing to detect its changes.
+type resourceEntry struct {
+	path    string
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	value   interface{}
+}
+
+// resourceCache caches the resources (e.g. configuration, secret) decoded from the filesystem, across invocations.
+// An entry is invalidated when the modification time and the content of the underlying file change, which covers the
+// way Kubernetes updates ConfigMaps and Secrets (i.e. by swapping symlinks).
+type resourceCache struct {
+	mu      sync.Mutex
+	entries map[resourceKey]*resourceEntry
+}
+
+var resources = newResourceCache()
+
+func newResourceCache() *resourceCache {
+	return &resourceCache{entries: map[resourceKey]*resourceEntry{}}
+}
+
+// decoder denotes functions able to decode a resource.
+type decoder func(in io.Reader) (interface{}, error)
+
+// load returns the resource identified by the given key, decoding it with the given decoder if not cached (or
+// outdated). The returned boolean tells if the resource has been found, and the second one if it comes from the cache.
+func (c *resourceCache) load(key resourceKey, decode decoder) (interface{}, bool, bool, error) {
+	if !reflect.TypeOf(key.fs).Comparable() {
+		// not cacheable
+		return c.read(key, decode)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok {
+		info, err := key.fs.Stat(entry.path)
+		if err == nil && info.ModTime().Equal(entry.modTime) && info.Size() == entry.size {
+			return entry.value, true, true, nil
+		}
+
+		if err == nil {
+			if value, ok := c.refresh(key, entry, info.ModTime(), info.Size(), decode); ok {
+				return value, true, true, nil
+			}
+		}
+	}
+
+	path := util.FindResource(key.fs, key.folder, key.namespace, key.name)
+	if path == "" {
+		c.evict(key)
+
+		return nil, false, false, nil
+	}
+
+	entry, err := c.decode(key, path, decode)
+	if err != nil {
+		c.evict(key)
+
+		return nil, true, false, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	return entry.value, true, false, nil
+}
+
+// read reads and decodes the resource, bypassing the cache.
+func (c *resourceCache) read(key resourceKey, decode decoder) (interface{}, bool, bool, error) {
+	path := util.FindResource(key.fs, key.folder, key.namespace, key.name)
+	if path == "" {
+		return nil, false, false, nil
+	}
+
+	entry, err := c.decode(key, path, decode)
+	if err != nil {
+		return nil, true, false, err
+	}
+
+	return entry.value, true, false, nil
+}
+
+// refresh checks if the content of a modified resource has actually changed. If not, the entry is updated and its
+// value returned.
+func (c *resourceCache) refresh(
+	key resourceKey, entry *resourceEntry, modTime time.Time, size int64, decode decoder) (interface{}, bool) {
+	content, err := afero.ReadFile(key.fs, entry.path)
+	if err != nil || sha256.Sum256(content) != entry.hash {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.entries[key] = &resourceEntry{
+		path:    entry.path,
+		modTime: modTime,
+		size:    size,
+		hash:    entry.hash,
+		value:   entry.value,
+	}
+	c.mu.Unlock()
+
+	return entry.value, true
+}
+
+func (c *resourceCache) decode(key resourceKey, path string, decode decoder) (*resourceEntry, error) {
+	info, err := key.fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := afero.ReadFile(key.fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	return &resourceEntry{
+		path:    path,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    sha256.Sum256(content),
+		value:   value,
+	}, nil
+}
+
+func (c *resourceCache) evict(key resourceKey) {
+	if !reflect.TypeOf(key.fs).Comparable() {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// reset clears the cache.
+func (c *resourceCache) reset() {
+	c.mu.Lock()
+	c.entries = map[resourceKey]*resourceEntry{}
+	c.mu.Unlock()
+}
+
+// programCache caches the compiled programs, keyed by their source.
+type programCache struct {
+	mu       sync.RWMutex
+	programs map[string]*vm.Program
+}
+
+func newProgramCache() *programCache {
+	return &programCache{programs: map[string]*vm.Program{}}
+}
+
+// compile returns the compiled program for the given source, compiling it if not cached.
+func (c *programCache) compile(source string) (*vm.Program, error) {
+	c.mu.RLock()
+	program, ok := c.programs[source]
+	c.mu.RUnlock()
+
+	if ok {
+		return program, nil
+	}
+
+	program, err := expr.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.programs[source] = program
+	c.mu.Unlock()
+
+	return program, nil
+}
+
+// cachedConfig is the configuration as cached, along with the programs compiled from it.
+type cachedConfig struct {
+	config   Config
+	programs *programCache
+}
@@ -0,0 +1,183 @@
+package kynaptik
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/rs/zerolog"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/afero"
+)
+
+func TestResourceCache(t *testing.T) {
+	Convey("Considering a resource cache and a virtual filesystem containing a resource", t, func(c C) {
+		cache := newResourceCache()
+		fs := afero.NewMemMapFs()
+		path := "/configs/my-namespace/my-function/resource.txt"
+		key := resourceKey{fs: fs, folder: "configs", namespace: "my-namespace", name: "resource.txt"}
+
+		So(afero.WriteFile(fs, path, []byte("foo"), 0644), ShouldBeNil)
+
+		decoded := 0
+		decode := func(in io.Reader) (interface{}, error) {
+			decoded++
+			content, err := ioutil.ReadAll(in)
+
+			return string(content), err
+		}
+
+		Convey("When loading the resource", func() {
+			value, found, cached, err := cache.load(key, decode)
+
+			Convey("Then resource shall be decoded", func() {
+				So(err, ShouldBeNil)
+				So(found, ShouldBeTrue)
+				So(cached, ShouldBeFalse)
+				So(value, ShouldEqual, "foo")
+				So(decoded, ShouldEqual, 1)
+			})
+
+			Convey("And when loading the resource again", func() {
+				value, found, cached, err := cache.load(key, decode)
+
+				Convey("Then resource shall come from the cache", func() {
+					So(err, ShouldBeNil)
+					So(found, ShouldBeTrue)
+					So(cached, ShouldBeTrue)
+					So(value, ShouldEqual, "foo")
+					So(decoded, ShouldEqual, 1)
+				})
+			})
+
+			Convey("And when the resource is modified and loaded again", func() {
+				So(afero.WriteFile(fs, path, []byte("bar"), 0644), ShouldBeNil)
+				So(fs.Chtimes(path, time.Now(), time.Now().Add(time.Minute)), ShouldBeNil)
+
+				value, found, cached, err := cache.load(key, decode)
+
+				Convey("Then resource shall be decoded again", func() {
+					So(err, ShouldBeNil)
+					So(found, ShouldBeTrue)
+					So(cached, ShouldBeFalse)
+					So(value, ShouldEqual, "bar")
+					So(decoded, ShouldEqual, 2)
+				})
+			})
+
+			Convey("And when the resource is touched (same content) and loaded again", func() {
+				So(fs.Chtimes(path, time.Now(), time.Now().Add(time.Minute)), ShouldBeNil)
+
+				value, found, cached, err := cache.load(key, decode)
+
+				Convey("Then resource shall come from the cache", func() {
+					So(err, ShouldBeNil)
+					So(found, ShouldBeTrue)
+					So(cached, ShouldBeTrue)
+					So(value, ShouldEqual, "foo")
+					So(decoded, ShouldEqual, 1)
+				})
+			})
+
+			Convey("And when the resource is removed and loaded again", func() {
+				So(fs.Remove(path), ShouldBeNil)
+
+				value, found, _, err := cache.load(key, decode)
+
+				Convey("Then resource shall not be found", func() {
+					So(err, ShouldBeNil)
+					So(found, ShouldBeFalse)
+					So(value, ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestProgramCache(t *testing.T) {
+	Convey("Considering a program cache", t, func(c C) {
+		cache := newProgramCache()
+
+		Convey("When compiling the same source twice", func() {
+			p1, err1 := cache.compile("data.foo == 'bar'")
+			p2, err2 := cache.compile("data.foo == 'bar'")
+
+			Convey("Then the same program shall be returned", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(p1, ShouldEqual, p2)
+			})
+		})
+
+		Convey("When compiling an invalid source", func() {
+			_, err := cache.compile("!=")
+
+			Convey("Then an error shall be returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func BenchmarkInvokeλ(b *testing.B) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/configs/my-namespace/my-function/function-spec.yml", []byte(`
+preCondition: data.lastName == "Doe" and data.firstName in ["John", "Jane"]
+action: |
+  uri: 'null://127.0.0.1/{{ .data.firstName }}'
+  param1: '{{ .secret.username }}'
+postCondition: response == "ok"
+`), 0644)
+	_ = afero.WriteFile(fs, "/secrets/my-namespace/my-function/function-secret.yml", []byte(`
+username: 'YWRtaW4='
+password: 'c+KCrGNy4oKsdA=='
+`), 0644)
+
+	invoke := func() {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{ "firstName": "John", "lastName": "Doe" }`))
+		req.Header.Set("X-Fission-Function-Namespace", "my-namespace")
+		req.Header.Set(util.HeaderContentType, util.MediaTypeApplicationJSON)
+
+		rr := httptest.NewRecorder()
+
+		Invokeλ(rr, req, fs,
+			func() Config {
+				return Config{PreCondition: "true"}
+			},
+			func() Action {
+				return &protoAction{
+					doAction: func(action protoAction, ctx context.Context) (interface{}, error) {
+						return "ok", nil
+					},
+				}
+			})
+
+		if rr.Code != http.StatusOK {
+			b.Fatalf("unexpected status code %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resources.reset()
+			invoke()
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		resources.reset()
+
+		for i := 0; i < b.N; i++ {
+			invoke()
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package kynaptik
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// MediaTypeApplicationCloudEventsJSON is the media type of the CloudEvents in structured mode (JSON format).
+	MediaTypeApplicationCloudEventsJSON = "application/cloudevents+json"
+	// cloudEventHeaderPrefix is the prefix of the headers carrying the attributes of CloudEvents in binary mode.
+	cloudEventHeaderPrefix = "Ce-"
+)
+
+// cloudEventRequiredAttributes are the attributes a CloudEvent shall specify.
+// See: https://github.com/cloudevents/spec/blob/v1.0.1/spec.md#required-attributes
+var cloudEventRequiredAttributes = []string{"id", "source", "specversion", "type"}
+
+// extractCloudEvent extracts the CloudEvent carried by the incoming request, if any, either in binary mode (ce-*
+// headers) or in structured mode (application/cloudevents+json media type). It returns the attributes of the event
+// (nil if the request is not a CloudEvent) and the data of the event, i.e. the given data in binary mode, and the
+// data member of the event in structured mode.
+func extractCloudEvent(
+	r *http.Request,
+	negotiated *negotiatedMediaType,
+	data interface{},
+) (map[string]interface{}, interface{}, error) {
+	var attributes map[string]interface{}
+
+	switch {
+	case negotiated.mediaType == MediaTypeApplicationCloudEventsJSON:
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid CloudEvent: expected an object, got %T", data)
+		}
+
+		attributes = make(map[string]interface{}, len(m))
+
+		for k, v := range m {
+			if k != "data" && k != "data_base64" {
+				attributes[k] = v
+			}
+		}
+
+		var err error
+		if data, err = structuredCloudEventData(m); err != nil {
+			return nil, nil, err
+		}
+	case r.Header.Get(cloudEventHeaderPrefix+"Specversion") != "":
+		attributes = map[string]interface{}{}
+
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, cloudEventHeaderPrefix) && len(v) > 0 {
+				// header values are percent-encoded (see https://github.com/cloudevents/spec/blob/v1.0.1/http-protocol-binding.md#3132-http-header-values)
+				value, err := url.PathUnescape(v[0])
+				if err != nil {
+					value = v[0]
+				}
+
+				attributes[strings.ToLower(strings.TrimPrefix(k, cloudEventHeaderPrefix))] = value
+			}
+		}
+
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			attributes["datacontenttype"] = contentType
+		}
+	default:
+		return nil, data, nil
+	}
+
+	for _, name := range cloudEventRequiredAttributes {
+		if v, ok := attributes[name]; !ok || v == "" {
+			return nil, nil, fmt.Errorf("invalid CloudEvent: missing attribute '%s'", name)
+		}
+	}
+
+	// the optional attributes are always exposed, so that they can be tested against nil
+	for _, name := range []string{"subject", "time", "datacontenttype", "dataschema"} {
+		if _, ok := attributes[name]; !ok {
+			attributes[name] = nil
+		}
+	}
+
+	return attributes, data, nil
+}
+
+// structuredCloudEventData returns the data of a CloudEvent in structured mode, decoding the binary data
+// (data_base64) according to its content type when a payload decoder is registered for it.
+func structuredCloudEventData(event map[string]interface{}) (interface{}, error) {
+	encoded, ok := event["data_base64"].(string)
+	if !ok {
+		return event["data"], nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CloudEvent: incorrect data_base64: %w", err)
+	}
+
+	if contentType, ok := event["datacontenttype"].(string); ok {
+		if t, params, err := mime.ParseMediaType(contentType); err == nil {
+			if decoder := payloadDecoders.get(acceptMediaType(payloadDecoders.mediaTypes(), t)); decoder != nil {
+				return decoder(raw, params)
+			}
+		}
+	}
+
+	return string(raw), nil
+}
@@ -0,0 +1,166 @@
+package kynaptik
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtractCloudEvent(t *testing.T) {
+	Convey("Considering the extraction of CloudEvents", t, func(c C) {
+		cases := []struct {
+			name          string
+			contentType   string
+			headers       map[string]string
+			payload       string
+			expectedEvent map[string]interface{}
+			expectedData  interface{}
+			err           string
+		}{
+			{
+				name:         "not a CloudEvent",
+				contentType:  "application/json",
+				payload:      `{"id":"42"}`,
+				expectedData: map[string]interface{}{"id": "42"},
+			},
+			{
+				name:        "binary mode",
+				contentType: "application/json",
+				headers: map[string]string{
+					"ce-specversion": "1.0",
+					"ce-id":          "A234-1234-1234",
+					"ce-type":        "com.example.order.created",
+					"ce-source":      "/orders",
+					"ce-time":        "2018-04-05T17:31:00Z",
+					"ce-subject":     "order%2042",
+					"ce-tenant":      "acme",
+				},
+				payload: `{"id":"42"}`,
+				expectedEvent: map[string]interface{}{
+					"specversion":     "1.0",
+					"id":              "A234-1234-1234",
+					"type":            "com.example.order.created",
+					"source":          "/orders",
+					"time":            "2018-04-05T17:31:00Z",
+					"subject":         "order 42",
+					"tenant":          "acme",
+					"datacontenttype": "application/json",
+					"dataschema":      nil,
+				},
+				expectedData: map[string]interface{}{"id": "42"},
+			},
+			{
+				name:        "binary mode - missing attribute",
+				contentType: "application/json",
+				headers: map[string]string{
+					"ce-specversion": "1.0",
+					"ce-id":          "A234-1234-1234",
+					"ce-source":      "/orders",
+				},
+				payload: `{"id":"42"}`,
+				err:     "invalid CloudEvent: missing attribute 'type'",
+			},
+			{
+				name:        "structured mode",
+				contentType: "application/cloudevents+json; charset=utf-8",
+				payload:     `{"specversion":"1.0","id":"A234","type":"com.example.order.created","source":"/orders","datacontenttype":"application/json","data":{"id":"42"}}`,
+				expectedEvent: map[string]interface{}{
+					"specversion":     "1.0",
+					"id":              "A234",
+					"type":            "com.example.order.created",
+					"source":          "/orders",
+					"datacontenttype": "application/json",
+					"subject":         nil,
+					"time":            nil,
+					"dataschema":      nil,
+				},
+				expectedData: map[string]interface{}{"id": "42"},
+			},
+			{
+				name:        "structured mode - base64 data",
+				contentType: "application/cloudevents+json",
+				payload:     `{"specversion":"1.0","id":"A234","type":"t","source":"/s","datacontenttype":"application/xml","data_base64":"PG9yZGVyPjQyPC9vcmRlcj4="}`,
+				expectedEvent: map[string]interface{}{
+					"specversion":     "1.0",
+					"id":              "A234",
+					"type":            "t",
+					"source":          "/s",
+					"datacontenttype": "application/xml",
+					"subject":         nil,
+					"time":            nil,
+					"dataschema":      nil,
+				},
+				expectedData: map[string]interface{}{"order": "42"},
+			},
+			{
+				name:        "structured mode - base64 data of unknown content type",
+				contentType: "application/cloudevents+json",
+				payload:     `{"specversion":"1.0","id":"A234","type":"t","source":"/s","datacontenttype":"application/octet-stream","data_base64":"aGVsbG8="}`,
+				expectedEvent: map[string]interface{}{
+					"specversion":     "1.0",
+					"id":              "A234",
+					"type":            "t",
+					"source":          "/s",
+					"datacontenttype": "application/octet-stream",
+					"subject":         nil,
+					"time":            nil,
+					"dataschema":      nil,
+				},
+				expectedData: "hello",
+			},
+			{
+				name:        "structured mode - incorrect base64 data",
+				contentType: "application/cloudevents+json",
+				payload:     `{"specversion":"1.0","id":"A234","type":"t","source":"/s","data_base64":"!"}`,
+				err:         "invalid CloudEvent: incorrect data_base64: illegal base64 data at input byte 0",
+			},
+			{
+				name:        "structured mode - not an object",
+				contentType: "application/cloudevents+json",
+				payload:     `[]`,
+				err:         "invalid CloudEvent: expected an object, got []interface {}",
+			},
+			{
+				name:        "structured mode - missing attribute",
+				contentType: "application/cloudevents+json",
+				payload:     `{"specversion":"1.0","type":"t","source":"/s"}`,
+				err:         "invalid CloudEvent: missing attribute 'id'",
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When extracting the case '%s'", tc.name), func() {
+				r, err := http.NewRequest("POST", "/", strings.NewReader(tc.payload))
+				So(err, ShouldBeNil)
+
+				r.Header.Set("Content-Type", tc.contentType)
+				for k, v := range tc.headers {
+					r.Header.Set(k, v)
+				}
+
+				negotiated, ok := negotiateMediaType([]string{"application/json"}, tc.contentType)
+				So(ok, ShouldBeTrue)
+
+				data, err := negotiated.decoder([]byte(tc.payload), negotiated.params)
+				So(err, ShouldBeNil)
+
+				event, data, err := extractCloudEvent(r, negotiated, data)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.err == "" {
+						So(err, ShouldBeNil)
+						So(event, ShouldResemble, tc.expectedEvent)
+						So(data, ShouldResemble, tc.expectedData)
+					} else {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					}
+				})
+			})
+		}
+	})
+}
@@ -6,4 +6,57 @@ type ResponseData struct {
 	// State species the name of the stage reaches by the function after execution.
 	// May help to determine the location of the error.
 	Stage string `json:"stage"`
+	// RequestID specifies the id of the request, reported when the request is processed in the background.
+	RequestID string `json:"requestId,omitempty"`
+	// Rules specifies the names of the rules fired (if rules are configured).
+	Rules []string `json:"rules,omitempty"`
+	// Outcomes specifies the outcome of each action performed.
+	Outcomes []Outcome `json:"outcomes,omitempty"`
+	// DryRun specifies what the invocation would do, for a dry run.
+	DryRun *DryRunReport `json:"dryRun,omitempty"`
+}
+
+// Outcome specifies the outcome of an action performed by the function.
+type Outcome struct {
+	// Rule specifies the name of the rule the action comes from (if rules are configured).
+	Rule string `json:"rule,omitempty"`
+	// URI is the URI of the action.
+	URI string `json:"uri"`
+	// Stage species the name of the stage reaches by the action.
+	Stage string `json:"stage"`
+	// Status is the status of the action, following the JSend semantics: success, fail or error.
+	Status string `json:"status"`
+	// Message is the message describing the outcome (typically the cause of the failure).
+	Message string `json:"message,omitempty"`
+	// DeadLetter is the status of the dead letter action performed when the action failed (if a dead letter is
+	// configured), following the JSend semantics: success or error.
+	DeadLetter string `json:"deadLetter,omitempty"`
+
+	code int
+}
+
+// newOutcome returns a new outcome for the given stage and HTTP status code.
+func newOutcome(t *task, stage string, code int, message string) Outcome {
+	status := "success"
+
+	switch {
+	case code >= 500:
+		status = "error"
+	case code >= 400:
+		status = "fail"
+	}
+
+	o := Outcome{
+		URI:     t.action.GetURI(),
+		Stage:   stage,
+		Status:  status,
+		Message: message,
+		code:    code,
+	}
+
+	if t.rule.explicit {
+		o.Rule = t.rule.Name
+	}
+
+	return o
 }
@@ -0,0 +1,95 @@
+package kynaptik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/rs/zerolog/hlog"
+)
+
+// deadLetterStages are the stages at which a failed action is sent to the dead letter.
+var deadLetterStages = map[string]bool{
+	"do-action":            true,
+	"match-post-condition": true,
+}
+
+// DeadLetter specifies the (secondary) action performed when an action failed, typically to park the incoming event
+// for later processing.
+type DeadLetter struct {
+	// Action specifies the action to execute, of any registered kind, templated with the environment along with the
+	// `error` (message), the `response` and the `stage` of the failed action.
+	Action string `yaml:"action"`
+}
+
+// enabled returns true if a dead letter is specified.
+func (d DeadLetter) enabled() bool {
+	return d.Action != ""
+}
+
+// sendToDeadLetter performs the dead letter action for the given failed task, returning the status of the operation
+// (following the JSend semantics: success or error).
+func sendToDeadLetter(r *http.Request, actionFactory ActionFactory, t *task, outcome Outcome) string {
+	config := r.Context().Value(ctxKeyConfig).(Config)
+	validate := r.Context().Value(ctxKeyValidate).(*validator.Validate)
+
+	env := make(environment, len(t.env)+3)
+	for k, v := range t.env {
+		env[k] = v
+	}
+
+	env["response"] = t.response
+	env["stage"] = outcome.Stage
+	env["error"] = outcome.Message
+
+	err := func() error {
+		in, err := util.RenderTemplatedString("deadLetter", config.DeadLetter.Action, env)
+		if err != nil {
+			return err
+		}
+
+		// the dead letter is not bound to the kind of the actions of the function
+		actions, err := decodeActions(in, anyResolverOf(actionFactory), validate)
+		if err != nil {
+			return err
+		}
+
+		if len(actions) != 1 {
+			return errors.New("a single dead letter action is expected")
+		}
+
+		ctx := r.Context()
+		cancel := func() { /* noop by default */ }
+
+		if config.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, config.Timeout*time.Millisecond)
+		}
+		defer cancel()
+
+		_, err = actions[0].DoAction(ctx)
+
+		return err
+	}()
+	if err != nil {
+		hlog.
+			FromRequest(r).
+			Error().
+			Str("endpoint", t.action.GetURI()).
+			Err(err).
+			Msg("❌ dead letter failed")
+
+		return "error"
+	}
+
+	hlog.
+		FromRequest(r).
+		Warn().
+		Str("endpoint", t.action.GetURI()).
+		Str("stage", outcome.Stage).
+		Msg("📮 sent to dead letter")
+
+	return "success"
+}
@@ -0,0 +1,247 @@
+package kynaptik
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // sha1 is still used by some webhook providers
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// HMACSchemeGitHub specifies the signature scheme of GitHub webhooks (X-Hub-Signature-256 header).
+	HMACSchemeGitHub = "github"
+	// HMACSchemeStripe specifies the signature scheme of Stripe webhooks (Stripe-Signature header).
+	HMACSchemeStripe = "stripe"
+	// HMACSchemeSlack specifies the signature scheme of Slack requests (X-Slack-Signature header).
+	HMACSchemeSlack = "slack"
+	// HMACSchemeGeneric specifies a signature scheme configured by the header, the algorithm and the encoding.
+	HMACSchemeGeneric = "generic"
+)
+
+// DefaultHMACTolerance specifies the default tolerance (in ms) between the timestamp of a signature and the current
+// time, for the schemes including a timestamp (stripe, slack).
+const DefaultHMACTolerance = 300000
+
+// HMAC specifies the verification of the HMAC signature of the raw body of the incoming requests.
+type HMAC struct {
+	// Scheme specifies the signature scheme: github, stripe, slack or generic.
+	Scheme string `yaml:"scheme" validate:"omitempty,oneof=github stripe slack generic"`
+	// Secret specifies the name of the entry of the secret holding the shared key.
+	Secret string `yaml:"secret" validate:"required_with=Scheme"`
+	// Header specifies the header carrying the signature (generic scheme only).
+	Header string `yaml:"header" validate:"required_if=Scheme generic"`
+	// Algorithm specifies the hash function: sha1, sha256 or sha512 (generic scheme only). sha256 by default.
+	Algorithm string `yaml:"algorithm" validate:"omitempty,oneof=sha1 sha256 sha512"`
+	// Encoding specifies the encoding of the signature: hex or base64 (generic scheme only). hex by default.
+	Encoding string `yaml:"encoding" validate:"omitempty,oneof=hex base64"`
+	// Prefix specifies the prefix preceding the signature in the header, e.g. "sha256=" (generic scheme only).
+	Prefix string `yaml:"prefix"`
+	// Tolerance specifies the maximum difference (in ms) between the timestamp of the signature and the current
+	// time (stripe and slack schemes only).
+	Tolerance time.Duration `yaml:"tolerance" validate:"gte=0"`
+}
+
+// enabled returns true if the signature shall be verified.
+func (h HMAC) enabled() bool {
+	return h.Scheme != ""
+}
+
+// tolerance returns the maximum difference allowed between the timestamp of the signature and the current time.
+func (h HMAC) tolerance() time.Duration {
+	if h.Tolerance == 0 {
+		return DefaultHMACTolerance * time.Millisecond
+	}
+
+	return h.Tolerance * time.Millisecond
+}
+
+// MarshalZerologObject produces logs related to the HMAC verification.
+func (h HMAC) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("scheme", h.Scheme).
+		Str("secret", h.Secret)
+
+	if h.Scheme == HMACSchemeGeneric {
+		e.
+			Str("header", h.Header).
+			Str("algorithm", h.Algorithm).
+			Str("encoding", h.Encoding)
+	}
+}
+
+// verify verifies the signature of the given payload.
+func (h HMAC) verify(header http.Header, payload []byte, secret map[string]interface{}, now time.Time) error {
+	key, ok := secret[h.Secret].(string)
+	if !ok || key == "" {
+		return fmt.Errorf("no key '%s' found in secret", h.Secret)
+	}
+
+	switch h.Scheme {
+	case HMACSchemeGitHub:
+		return verifyGitHubSignature(header, payload, []byte(key))
+	case HMACSchemeStripe:
+		return verifyStripeSignature(header, payload, []byte(key), now, h.tolerance())
+	case HMACSchemeSlack:
+		return verifySlackSignature(header, payload, []byte(key), now, h.tolerance())
+	default:
+		return h.verifyGenericSignature(header, payload, []byte(key))
+	}
+}
+
+func verifyGitHubSignature(header http.Header, payload, key []byte) error {
+	signature, err := signatureHeader(header, "X-Hub-Signature-256")
+	if err != nil {
+		return err
+	}
+
+	return checkHexSignature(strings.TrimPrefix(signature, "sha256="), computeHMAC(sha256.New, key, payload))
+}
+
+// verifyStripeSignature verifies a signature of the form `t=<timestamp>,v1=<signature>[,v1=<signature>...]`.
+// See: https://stripe.com/docs/webhooks/signatures
+func verifyStripeSignature(header http.Header, payload, key []byte, now time.Time, tolerance time.Duration) error {
+	signature, err := signatureHeader(header, "Stripe-Signature")
+	if err != nil {
+		return err
+	}
+
+	var timestamp string
+
+	var signatures []string
+
+	for _, elem := range strings.Split(signature, ",") {
+		kv := strings.SplitN(strings.TrimSpace(elem), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	if timestamp == "" || len(signatures) == 0 {
+		return unauthenticated("malformed signature header Stripe-Signature")
+	}
+
+	if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+		return err
+	}
+
+	expected := computeHMAC(sha256.New, key, []byte(timestamp), []byte("."), payload)
+	for _, s := range signatures {
+		if checkHexSignature(s, expected) == nil {
+			return nil
+		}
+	}
+
+	return unauthenticated("invalid signature")
+}
+
+// verifySlackSignature verifies a signature of the form `v0=<signature>`.
+// See: https://api.slack.com/authentication/verifying-requests-from-slack
+func verifySlackSignature(header http.Header, payload, key []byte, now time.Time, tolerance time.Duration) error {
+	signature, err := signatureHeader(header, "X-Slack-Signature")
+	if err != nil {
+		return err
+	}
+
+	timestamp, err := signatureHeader(header, "X-Slack-Request-Timestamp")
+	if err != nil {
+		return err
+	}
+
+	if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+		return err
+	}
+
+	expected := computeHMAC(sha256.New, key, []byte("v0:"), []byte(timestamp), []byte(":"), payload)
+
+	return checkHexSignature(strings.TrimPrefix(signature, "v0="), expected)
+}
+
+func (h HMAC) verifyGenericSignature(header http.Header, payload, key []byte) error {
+	signature, err := signatureHeader(header, h.Header)
+	if err != nil {
+		return err
+	}
+
+	var fn func() hash.Hash
+
+	switch h.Algorithm {
+	case "sha1":
+		fn = sha1.New
+	case "sha512":
+		fn = sha512.New
+	default:
+		fn = sha256.New
+	}
+
+	expected := computeHMAC(fn, key, payload)
+	signature = strings.TrimPrefix(signature, h.Prefix)
+
+	if h.Encoding == "base64" {
+		actual, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil || !hmac.Equal(actual, expected) {
+			return unauthenticated("invalid signature")
+		}
+
+		return nil
+	}
+
+	return checkHexSignature(signature, expected)
+}
+
+func signatureHeader(header http.Header, name string) (string, error) {
+	v := header.Get(name)
+	if v == "" {
+		return "", unauthenticated("missing signature header %s", name)
+	}
+
+	return v, nil
+}
+
+func computeHMAC(fn func() hash.Hash, key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(fn, key)
+	for _, p := range parts {
+		_, _ = mac.Write(p)
+	}
+
+	return mac.Sum(nil)
+}
+
+func checkHexSignature(signature string, expected []byte) error {
+	actual, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(actual, expected) {
+		return unauthenticated("invalid signature")
+	}
+
+	return nil
+}
+
+func checkTimestamp(timestamp string, now time.Time, tolerance time.Duration) error {
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return unauthenticated("malformed signature timestamp '%s'", timestamp)
+	}
+
+	if math.Abs(float64(now.Unix()-t)) > tolerance.Seconds() {
+		return unauthenticated("signature timestamp out of tolerance")
+	}
+
+	return nil
+}
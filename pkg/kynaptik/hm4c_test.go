@@ -0,0 +1,182 @@
+package kynaptik
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // sha1 is still used by some webhook providers
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func sign(fn func() hash.Hash, key string, parts ...string) []byte {
+	mac := hmac.New(fn, []byte(key))
+	for _, p := range parts {
+		_, _ = mac.Write([]byte(p))
+	}
+
+	return mac.Sum(nil)
+}
+
+func TestHMAC(t *testing.T) {
+	Convey("Considering the HMAC verification", t, func(c C) {
+		now := time.Unix(1600000000, 0)
+		payload := `{"foo":"bar"}`
+		secret := map[string]interface{}{"key": "s3cr3t"}
+		ts := fmt.Sprintf("%d", now.Unix())
+		old := fmt.Sprintf("%d", now.Add(-10*time.Minute).Unix())
+
+		cases := []struct {
+			name    string
+			hmac    HMAC
+			headers map[string]string
+			secret  map[string]interface{}
+			err     string
+		}{
+			{
+				name:    "github - valid",
+				hmac:    HMAC{Scheme: HMACSchemeGitHub, Secret: "key"},
+				headers: map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(sign(sha256.New, "s3cr3t", payload))},
+			},
+			{
+				name:    "github - invalid",
+				hmac:    HMAC{Scheme: HMACSchemeGitHub, Secret: "key"},
+				headers: map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(sign(sha256.New, "wrong", payload))},
+				err:     "invalid signature",
+			},
+			{
+				name:    "github - not hex",
+				hmac:    HMAC{Scheme: HMACSchemeGitHub, Secret: "key"},
+				headers: map[string]string{"X-Hub-Signature-256": "sha256=zz"},
+				err:     "invalid signature",
+			},
+			{
+				name: "github - missing header",
+				hmac: HMAC{Scheme: HMACSchemeGitHub, Secret: "key"},
+				err:  "missing signature header X-Hub-Signature-256",
+			},
+			{
+				name:   "github - no key in secret",
+				hmac:   HMAC{Scheme: HMACSchemeGitHub, Secret: "key"},
+				secret: map[string]interface{}{},
+				err:    "no key 'key' found in secret",
+			},
+			{
+				name: "stripe - valid",
+				hmac: HMAC{Scheme: HMACSchemeStripe, Secret: "key"},
+				headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s,v1=%s,v0=foo",
+					ts,
+					hex.EncodeToString(sign(sha256.New, "old-key", ts, ".", payload)),
+					hex.EncodeToString(sign(sha256.New, "s3cr3t", ts, ".", payload)))},
+			},
+			{
+				name: "stripe - invalid",
+				hmac: HMAC{Scheme: HMACSchemeStripe, Secret: "key"},
+				headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s",
+					ts,
+					hex.EncodeToString(sign(sha256.New, "s3cr3t", payload)))},
+				err: "invalid signature",
+			},
+			{
+				name: "stripe - out of tolerance",
+				hmac: HMAC{Scheme: HMACSchemeStripe, Secret: "key"},
+				headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s",
+					old,
+					hex.EncodeToString(sign(sha256.New, "s3cr3t", old, ".", payload)))},
+				err: "signature timestamp out of tolerance",
+			},
+			{
+				name: "stripe - within custom tolerance",
+				hmac: HMAC{Scheme: HMACSchemeStripe, Secret: "key", Tolerance: 3600000},
+				headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s",
+					old,
+					hex.EncodeToString(sign(sha256.New, "s3cr3t", old, ".", payload)))},
+			},
+			{
+				name:    "stripe - malformed",
+				hmac:    HMAC{Scheme: HMACSchemeStripe, Secret: "key"},
+				headers: map[string]string{"Stripe-Signature": "v1=abc"},
+				err:     "malformed signature header Stripe-Signature",
+			},
+			{
+				name: "slack - valid",
+				hmac: HMAC{Scheme: HMACSchemeSlack, Secret: "key"},
+				headers: map[string]string{
+					"X-Slack-Request-Timestamp": ts,
+					"X-Slack-Signature":         "v0=" + hex.EncodeToString(sign(sha256.New, "s3cr3t", "v0:", ts, ":", payload)),
+				},
+			},
+			{
+				name: "slack - malformed timestamp",
+				hmac: HMAC{Scheme: HMACSchemeSlack, Secret: "key"},
+				headers: map[string]string{
+					"X-Slack-Request-Timestamp": "yesterday",
+					"X-Slack-Signature":         "v0=" + hex.EncodeToString(sign(sha256.New, "s3cr3t", "v0:yesterday:", payload)),
+				},
+				err: "malformed signature timestamp 'yesterday'",
+			},
+			{
+				name: "slack - missing timestamp",
+				hmac: HMAC{Scheme: HMACSchemeSlack, Secret: "key"},
+				headers: map[string]string{
+					"X-Slack-Signature": "v0=" + hex.EncodeToString(sign(sha256.New, "s3cr3t", "v0:", ts, ":", payload)),
+				},
+				err: "missing signature header X-Slack-Request-Timestamp",
+			},
+			{
+				name:    "generic - valid (default algorithm & encoding)",
+				hmac:    HMAC{Scheme: HMACSchemeGeneric, Secret: "key", Header: "X-Signature"},
+				headers: map[string]string{"X-Signature": hex.EncodeToString(sign(sha256.New, "s3cr3t", payload))},
+			},
+			{
+				name:    "generic - valid (sha1, hex, prefix)",
+				hmac:    HMAC{Scheme: HMACSchemeGeneric, Secret: "key", Header: "X-Signature", Algorithm: "sha1", Prefix: "sha1="},
+				headers: map[string]string{"X-Signature": "sha1=" + hex.EncodeToString(sign(sha1.New, "s3cr3t", payload))},
+			},
+			{
+				name:    "generic - valid (sha512, base64)",
+				hmac:    HMAC{Scheme: HMACSchemeGeneric, Secret: "key", Header: "X-Signature", Algorithm: "sha512", Encoding: "base64"},
+				headers: map[string]string{"X-Signature": base64.StdEncoding.EncodeToString(sign(sha512.New, "s3cr3t", payload))},
+			},
+			{
+				name:    "generic - invalid (sha512, base64)",
+				hmac:    HMAC{Scheme: HMACSchemeGeneric, Secret: "key", Header: "X-Signature", Algorithm: "sha512", Encoding: "base64"},
+				headers: map[string]string{"X-Signature": base64.StdEncoding.EncodeToString(sign(sha256.New, "s3cr3t", payload))},
+				err:     "invalid signature",
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When verifying the case '%s'", tc.name), func() {
+				header := http.Header{}
+				for k, v := range tc.headers {
+					header.Set(k, v)
+				}
+
+				s := secret
+				if tc.secret != nil {
+					s = tc.secret
+				}
+
+				err := tc.hmac.verify(header, []byte(payload), s, now)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.err == "" {
+						So(err, ShouldBeNil)
+					} else {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					}
+				})
+			})
+		}
+	})
+}
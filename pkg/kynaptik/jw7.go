@@ -0,0 +1,276 @@
+package kynaptik
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog"
+)
+
+// JWT specifies the verification of the JSON Web Token (bearer token) carried by the incoming requests.
+type JWT struct {
+	// JWKS specifies the name of the entry of the secret holding the JSON Web Key Set used to verify the signature.
+	JWKS string `yaml:"jwks" validate:"excluded_with=PublicKey"`
+	// PublicKey specifies the name of the entry of the secret holding the PEM encoded key (or certificate) used to
+	// verify the signature.
+	PublicKey string `yaml:"publicKey"`
+	// Issuer specifies the expected issuer (iss claim). Not checked if empty.
+	Issuer string `yaml:"issuer"`
+	// Audience specifies the expected audience (aud claim). Not checked if empty.
+	Audience string `yaml:"audience"`
+	// Algorithms specifies the signing algorithms accepted, e.g. RS256, ES256. All the asymmetric algorithms by
+	// default.
+	Algorithms []string `yaml:"algorithms"`
+	// Leeway specifies the clock skew (in ms) tolerated when checking the exp and nbf claims.
+	Leeway time.Duration `yaml:"leeway" validate:"gte=0"`
+}
+
+// enabled returns true if the token shall be verified.
+func (j JWT) enabled() bool {
+	return j.JWKS != "" || j.PublicKey != ""
+}
+
+// algorithms returns the signing algorithms accepted.
+func (j JWT) algorithms() []string {
+	if len(j.Algorithms) == 0 {
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	}
+
+	return j.Algorithms
+}
+
+// MarshalZerologObject produces logs related to the JWT verification.
+func (j JWT) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("jwks", j.JWKS).
+		Str("publicKey", j.PublicKey).
+		Str("issuer", j.Issuer).
+		Str("audience", j.Audience).
+		Strs("algorithms", j.algorithms())
+}
+
+// verify verifies the bearer token of the request, returning its claims.
+func (j JWT) verify(header http.Header, secret map[string]interface{}, now time.Time) (map[string]interface{}, error) {
+	keys, err := j.keys(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	authorization := header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "bearer ") {
+		return nil, unauthenticated("missing bearer token")
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(j.algorithms()), jwt.WithoutClaimsValidation())
+	claims := jwt.MapClaims{}
+
+	_, err = parser.ParseWithClaims(strings.TrimSpace(authorization[7:]), claims, func(token *jwt.Token) (interface{}, error) {
+		// a single public key (PEM) is used whatever the kid of the token
+		if j.PublicKey != "" {
+			return keys[""], nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+
+		if kid == "" && len(keys) == 1 {
+			for _, key := range keys {
+				return key, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no key found for kid '%s'", kid)
+	})
+	if err != nil {
+		return nil, unauthenticated("invalid token: %s", err)
+	}
+
+	leeway := int64((j.Leeway * time.Millisecond).Seconds())
+
+	switch {
+	case !claims.VerifyExpiresAt(now.Unix()-leeway, true):
+		return nil, unauthenticated("invalid token: token is expired")
+	case !claims.VerifyNotBefore(now.Unix()+leeway, false):
+		return nil, unauthenticated("invalid token: token is not valid yet")
+	case j.Issuer != "" && !claims.VerifyIssuer(j.Issuer, true):
+		return nil, unauthenticated("invalid token: unexpected issuer")
+	case j.Audience != "" && !claims.VerifyAudience(j.Audience, true):
+		return nil, unauthenticated("invalid token: unexpected audience")
+	}
+
+	return claims, nil
+}
+
+// keys returns the keys, by kid, to verify the signature with.
+func (j JWT) keys(secret map[string]interface{}) (map[string]interface{}, error) {
+	if j.PublicKey != "" {
+		data, ok := secret[j.PublicKey].(string)
+		if !ok || data == "" {
+			return nil, fmt.Errorf("no key '%s' found in secret", j.PublicKey)
+		}
+
+		key, err := parsePEMPublicKey([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("incorrect public key '%s': %w", j.PublicKey, err)
+		}
+
+		return map[string]interface{}{"": key}, nil
+	}
+
+	var data []byte
+
+	switch v := secret[j.JWKS].(type) {
+	case string:
+		data = []byte(v)
+	case map[string]interface{}:
+		data, _ = json.Marshal(v)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no key '%s' found in secret", j.JWKS)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect JWKS '%s': %w", j.JWKS, err)
+	}
+
+	return keys, nil
+}
+
+// parsePEMPublicKey parses a PEM encoded public key, either PKIX, PKCS1 (RSA) or embedded in a certificate.
+func parsePEMPublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// jwk is a JSON Web Key, restricted to the public keys.
+// See: https://datatracker.ietf.org/doc/html/rfc7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses a JSON Web Key Set, returning the keys by kid.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	if len(set.Keys) == 0 {
+		return nil, errors.New("no key found")
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64URL(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBase64URL(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+
+		x, err := decodeBase64URL(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBase64URL(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+
+		x, err := decodeBase64URL(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,278 @@
+package kynaptik
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func rsaPublicKeyPEM(key *rsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	So(err, ShouldBeNil)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func signToken(method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	s, err := token.SignedString(key)
+	So(err, ShouldBeNil)
+
+	return s
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestJWT(t *testing.T) {
+	// keys are generated once, as goconvey runs the setup for each leaf
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Considering the JWT verification", t, func(c C) {
+		now := time.Unix(1600000000, 0)
+
+		jwks, err := json.Marshal(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa-1", "n": b64url(rsaKey.N.Bytes()), "e": b64url(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64url(ecKey.X.Bytes()), "y": b64url(ecKey.Y.Bytes())},
+				{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": b64url(edPublicKey)},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		secret := map[string]interface{}{
+			"pem":  rsaPublicKeyPEM(rsaKey),
+			"jwks": string(jwks),
+		}
+
+		claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+			c := jwt.MapClaims{
+				"iss":   "https://issuer.example.com",
+				"aud":   "kynaptik",
+				"sub":   "producer",
+				"scope": "orders:read orders:write",
+				"exp":   now.Add(time.Minute).Unix(),
+			}
+			for k, v := range overrides {
+				if v == nil {
+					delete(c, k)
+				} else {
+					c[k] = v
+				}
+			}
+
+			return c
+		}
+
+		pemJWT := JWT{PublicKey: "pem", Issuer: "https://issuer.example.com", Audience: "kynaptik"}
+		jwksJWT := JWT{JWKS: "jwks"}
+
+		cases := []struct {
+			name          string
+			jwt           JWT
+			authorization string
+			secret        map[string]interface{}
+			err           string
+		}{
+			{
+				name:          "pem - valid",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(nil)),
+			},
+			{
+				name:          "pem - valid (lower case scheme)",
+				jwt:           pemJWT,
+				authorization: "bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(nil)),
+			},
+			{
+				name:          "pem - valid with kid",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "2022-01-rotation", claims(nil)),
+			},
+			{
+				name: "pem - missing token",
+				jwt:  pemJWT,
+				err:  "missing bearer token",
+			},
+			{
+				name:          "pem - basic authorization",
+				jwt:           pemJWT,
+				authorization: "Basic Zm9vOmJhcg==",
+				err:           "missing bearer token",
+			},
+			{
+				name:          "pem - expired",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})),
+				err:           "invalid token: token is expired",
+			},
+			{
+				name:          "pem - expired within leeway",
+				jwt:           JWT{PublicKey: "pem", Leeway: 120000},
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})),
+			},
+			{
+				name:          "pem - no expiration",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(jwt.MapClaims{"exp": nil})),
+				err:           "invalid token: token is expired",
+			},
+			{
+				name:          "pem - not valid yet",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()})),
+				err:           "invalid token: token is not valid yet",
+			},
+			{
+				name:          "pem - unexpected issuer",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+				err:           "invalid token: unexpected issuer",
+			},
+			{
+				name:          "pem - unexpected audience",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(jwt.MapClaims{"aud": []string{"other"}})),
+				err:           "invalid token: unexpected audience",
+			},
+			{
+				name:          "pem - symmetric algorithm rejected",
+				jwt:           pemJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodHS256, []byte(rsaPublicKeyPEM(rsaKey)), "", claims(nil)),
+				err:           "invalid token: signing method HS256 is invalid",
+			},
+			{
+				name:          "pem - algorithm not allowed",
+				jwt:           JWT{PublicKey: "pem", Algorithms: []string{"RS512"}},
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "", claims(nil)),
+				err:           "invalid token: signing method RS256 is invalid",
+			},
+			{
+				name:          "pem - no key in secret",
+				jwt:           pemJWT,
+				authorization: "Bearer foo",
+				secret:        map[string]interface{}{},
+				err:           "no key 'pem' found in secret",
+			},
+			{
+				name:          "pem - incorrect key",
+				jwt:           pemJWT,
+				authorization: "Bearer foo",
+				secret:        map[string]interface{}{"pem": "foo"},
+				err:           "incorrect public key 'pem': no PEM block found",
+			},
+			{
+				name:          "jwks - valid (RSA)",
+				jwt:           jwksJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "rsa-1", claims(nil)),
+			},
+			{
+				name:          "jwks - valid (EC)",
+				jwt:           jwksJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodES256, ecKey, "ec-1", claims(nil)),
+			},
+			{
+				name:          "jwks - valid (Ed25519)",
+				jwt:           jwksJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodEdDSA, edKey, "ed-1", claims(nil)),
+			},
+			{
+				name:          "jwks - wrong key",
+				jwt:           jwksJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodES256, ecKey, "rsa-1", claims(nil)),
+				err:           "invalid token: key is of invalid type",
+			},
+			{
+				name:          "jwks - unknown kid",
+				jwt:           jwksJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "rsa-2", claims(nil)),
+				err:           "invalid token: no key found for kid 'rsa-2'",
+			},
+			{
+				name:          "jwks - as yaml",
+				jwt:           jwksJWT,
+				authorization: "Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "rsa-1", claims(nil)),
+				secret: map[string]interface{}{
+					"jwks": map[string]interface{}{
+						"keys": []interface{}{
+							map[string]interface{}{"kty": "RSA", "kid": "rsa-1", "n": b64url(rsaKey.N.Bytes()), "e": "AQAB"},
+						},
+					},
+				},
+			},
+			{
+				name:          "jwks - unsupported key type",
+				jwt:           jwksJWT,
+				authorization: "Bearer foo",
+				secret:        map[string]interface{}{"jwks": `{"keys":[{"kty":"oct","kid":"k"}]}`},
+				err:           "incorrect JWKS 'jwks': key 'k': unsupported key type 'oct'",
+			},
+			{
+				name:          "jwks - empty",
+				jwt:           jwksJWT,
+				authorization: "Bearer foo",
+				secret:        map[string]interface{}{"jwks": `{"keys":[]}`},
+				err:           "incorrect JWKS 'jwks': no key found",
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When verifying the case '%s'", tc.name), func() {
+				header := http.Header{}
+				if tc.authorization != "" {
+					header.Set("Authorization", tc.authorization)
+				}
+
+				s := secret
+				if tc.secret != nil {
+					s = tc.secret
+				}
+
+				claims, err := tc.jwt.verify(header, s, now)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.err == "" {
+						So(err, ShouldBeNil)
+						So(claims["sub"], ShouldEqual, "producer")
+					} else {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					}
+				})
+			})
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package kynaptik
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// LimitModeWait specifies that the actions exceeding the limits wait for their turn (bounded by the timeout).
+	LimitModeWait = "wait"
+	// LimitModeReject specifies that the actions exceeding the limits are rejected.
+	LimitModeReject = "reject"
+	// bucketSweepInterval specifies the minimum interval between two evictions of the idle token buckets.
+	bucketSweepInterval = time.Minute
+)
+
+// RateLimit specifies the rate limit applied to the actions, following the token bucket algorithm.
+type RateLimit struct {
+	// Rate specifies the number of actions allowed per second.
+	// A Rate of zero means no rate limit.
+	Rate float64 `yaml:"rate" validate:"gte=0"`
+	// Burst specifies the maximum number of actions allowed at once, i.e. the size of the bucket.
+	// The rate (rounded up) by default.
+	Burst int `yaml:"burst" validate:"gte=0"`
+	// Key specifies the expression giving the key of the bucket the action is accounted for (e.g. per tenant),
+	// evaluated against the environment. A single bucket by default.
+	Key string `yaml:"key"`
+}
+
+// enabled returns true if the rate limit is enabled.
+func (p RateLimit) enabled() bool {
+	return p.Rate > 0
+}
+
+// burst returns the size of the bucket.
+func (p RateLimit) burst() float64 {
+	if p.Burst == 0 {
+		return math.Max(1, math.Ceil(p.Rate))
+	}
+
+	return float64(p.Burst)
+}
+
+// MarshalZerologObject produces logs related to the rate limit.
+func (p RateLimit) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Float64("rate", p.Rate).
+		Float64("burst", p.burst()).
+		Str("key", p.Key)
+}
+
+// rateLimitedError is the error returned when an action exceeds the limits.
+type rateLimitedError struct {
+	reason string
+}
+
+func (e rateLimitedError) Error() string {
+	return e.reason
+}
+
+// tokenBucket maintains the tokens available for a bucket.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// full is the time the bucket is refilled up to the burst, and can therefore be forgotten without consequence.
+	full time.Time
+}
+
+// take takes a token from the bucket, returning the time to wait for the token to be actually available. No token
+// is taken (and false is returned) if that time exceeds the given maximum.
+func (b *tokenBucket) take(p RateLimit, now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last.IsZero() {
+		b.tokens = p.burst()
+	} else if now.After(b.last) {
+		b.tokens = math.Min(p.burst(), b.tokens+now.Sub(b.last).Seconds()*p.Rate)
+	}
+
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.full = now.Add(time.Duration((p.burst() - b.tokens) / p.Rate * float64(time.Second)))
+
+		return 0, true
+	}
+
+	wait := time.Duration((1 - b.tokens) / p.Rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+
+	// the token is borrowed from the future
+	b.tokens--
+	b.full = now.Add(time.Duration((p.burst() - b.tokens) / p.Rate * float64(time.Second)))
+
+	return wait, true
+}
+
+// idle returns true if the bucket is refilled up to the burst at the given time.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !now.Before(b.full)
+}
+
+// functionKey returns the key identifying the function invoked by the given request, i.e. its namespace and its name
+// (as set by the Fission router, or by the kynaptik server after the route).
+func functionKey(r *http.Request) string {
+	return fmt.Sprintf("%s/%s", r.Header.Get("X-Fission-Function-Namespace"), r.Header.Get("X-Fission-Function-Name"))
+}
+
+// limiterRegistry maintains the token buckets and the concurrency slots, keyed by function (and bucket key). The
+// registry lives in-process, across invocations, the idle token buckets being evicted.
+type limiterRegistry struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	slots     map[string]chan struct{}
+	nextSweep time.Time
+}
+
+func newLimiterRegistry() *limiterRegistry {
+	return &limiterRegistry{
+		buckets: map[string]*tokenBucket{},
+		slots:   map[string]chan struct{}{},
+	}
+}
+
+var limiters = newLimiterRegistry()
+
+// take takes a token from the bucket of the given key (see tokenBucket.take), creating the bucket if needed.
+func (r *limiterRegistry) take(key string, p RateLimit, now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(now)
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		r.buckets[key] = b
+	}
+
+	// the token is taken while holding the registry, so that the bucket is not evicted meanwhile
+	return b.take(p, now, maxWait)
+}
+
+// sweep evicts the idle token buckets, at most once per bucketSweepInterval. The caller shall hold the registry.
+func (r *limiterRegistry) sweep(now time.Time) {
+	if now.Before(r.nextSweep) {
+		return
+	}
+
+	for key, b := range r.buckets {
+		if b.idle(now) {
+			delete(r.buckets, key)
+		}
+	}
+
+	r.nextSweep = now.Add(bucketSweepInterval)
+}
+
+// slotsFor returns the concurrency slots for the given key and maximum concurrency, creating them if needed.
+func (r *limiterRegistry) slotsFor(key string, maxConcurrency int) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the maximum concurrency is part of the key, so that a change of the configuration is taken into account
+	key = fmt.Sprintf("%s#%d", key, maxConcurrency)
+
+	s, ok := r.slots[key]
+	if !ok {
+		s = make(chan struct{}, maxConcurrency)
+		r.slots[key] = s
+	}
+
+	return s
+}
+
+// acquireSlot acquires a concurrency slot, either waiting for it (bounded by the context) or not, according to the
+// mode. It returns the function releasing the slot.
+func acquireSlot(ctx context.Context, slots chan struct{}, mode string) (func(), error) {
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if mode == LimitModeReject {
+		return nil, rateLimitedError{reason: fmt.Sprintf("concurrency limit exceeded (%d)", cap(slots))}
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, rateLimitedError{
+			reason: fmt.Sprintf("concurrency limit exceeded (%d): %s", cap(slots), ctx.Err()),
+		}
+	}
+}
+
+// takeToken takes a token from the bucket of the given key, either waiting for it (bounded by the context) or not,
+// according to the mode.
+func takeToken(ctx context.Context, key string, p RateLimit, mode string) error {
+	now := time.Now()
+	maxWait := time.Duration(math.MaxInt64)
+
+	if mode == LimitModeReject {
+		maxWait = 0
+	} else if deadline, ok := ctx.Deadline(); ok {
+		maxWait = deadline.Sub(now)
+	}
+
+	wait, ok := limiters.take(key, p, now, maxWait)
+	if !ok {
+		return rateLimitedError{reason: fmt.Sprintf("rate limit exceeded (%g/s)", p.Rate)}
+	}
+
+	if wait > 0 {
+		if err := sleep(ctx, wait); err != nil {
+			return rateLimitedError{reason: fmt.Sprintf("rate limit exceeded (%g/s): %s", p.Rate, err)}
+		}
+	}
+
+	return nil
+}
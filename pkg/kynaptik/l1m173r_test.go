@@ -0,0 +1,148 @@
+package kynaptik
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenBucket(t *testing.T) {
+	Convey("Considering a token bucket with a rate of 10/s and a burst of 2", t, func(c C) {
+		now := time.Unix(1600000000, 0)
+		p := RateLimit{Rate: 10, Burst: 2}
+		b := &tokenBucket{}
+
+		Convey("When taking the tokens of the burst", func() {
+			wait1, ok1 := b.take(p, now, 0)
+			wait2, ok2 := b.take(p, now, 0)
+
+			Convey("Then they shall be available at once", func() {
+				So(ok1, ShouldBeTrue)
+				So(wait1, ShouldEqual, 0)
+				So(ok2, ShouldBeTrue)
+				So(wait2, ShouldEqual, 0)
+			})
+
+			Convey("Then the next token shall not be available at once", func() {
+				wait, ok := b.take(p, now, 0)
+				So(ok, ShouldBeFalse)
+				So(wait, ShouldEqual, 100*time.Millisecond)
+			})
+
+			Convey("Then the next token shall be borrowed when waiting is allowed", func() {
+				wait, ok := b.take(p, now, time.Second)
+				So(ok, ShouldBeTrue)
+				So(wait, ShouldEqual, 100*time.Millisecond)
+
+				wait, ok = b.take(p, now, time.Second)
+				So(ok, ShouldBeTrue)
+				So(wait, ShouldEqual, 200*time.Millisecond)
+			})
+
+			Convey("Then the bucket shall be refilled over time, up to the burst", func() {
+				wait, ok := b.take(p, now.Add(100*time.Millisecond), 0)
+				So(ok, ShouldBeTrue)
+				So(wait, ShouldEqual, 0)
+
+				_, ok = b.take(p, now.Add(10*time.Second), 0)
+				So(ok, ShouldBeTrue)
+				_, ok = b.take(p, now.Add(10*time.Second), 0)
+				So(ok, ShouldBeTrue)
+				_, ok = b.take(p, now.Add(10*time.Second), 0)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestLimiterRegistry(t *testing.T) {
+	Convey("Considering a registry of limiters", t, func(c C) {
+		now := time.Unix(1600000000, 0)
+		p := RateLimit{Rate: 10, Burst: 2}
+		registry := newLimiterRegistry()
+
+		Convey("When taking tokens from the buckets of several keys", func() {
+			_, ok1 := registry.take("a", p, now, 0)
+			_, ok2 := registry.take("b", p, now, 0)
+			_, ok3 := registry.take("b", p, now, 0)
+
+			So(ok1, ShouldBeTrue)
+			So(ok2, ShouldBeTrue)
+			So(ok3, ShouldBeTrue)
+			So(registry.buckets, ShouldHaveLength, 2)
+
+			Convey("Then the buckets not refilled yet shall be kept", func() {
+				slow := RateLimit{Rate: 0.001, Burst: 1}
+
+				_, ok := registry.take("slow", slow, now, 0)
+				So(ok, ShouldBeTrue)
+
+				_, ok = registry.take("c", p, now.Add(bucketSweepInterval), 0)
+				So(ok, ShouldBeTrue)
+
+				So(registry.buckets, ShouldHaveLength, 2)
+				So(registry.buckets, ShouldContainKey, "slow")
+
+				_, ok = registry.take("slow", slow, now.Add(bucketSweepInterval), 0)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Then the refilled buckets shall be evicted", func() {
+				_, ok := registry.take("c", p, now.Add(bucketSweepInterval), 0)
+				So(ok, ShouldBeTrue)
+
+				So(registry.buckets, ShouldHaveLength, 1)
+				So(registry.buckets, ShouldContainKey, "c")
+			})
+
+			Convey("Then the buckets shall be evicted at most once per sweep interval", func() {
+				_, _ = registry.take("c", p, now.Add(bucketSweepInterval), 0)
+				_, _ = registry.take("d", p, now.Add(bucketSweepInterval), 0)
+				_, _ = registry.take("e", p, now.Add(bucketSweepInterval+time.Second), 0)
+
+				So(registry.buckets, ShouldHaveLength, 3)
+			})
+		})
+	})
+}
+
+func TestAcquireSlot(t *testing.T) {
+	Convey("Considering concurrency slots with a capacity of 1", t, func(c C) {
+		slots := make(chan struct{}, 1)
+
+		release, err := acquireSlot(context.Background(), slots, LimitModeWait)
+		So(err, ShouldBeNil)
+
+		Convey("When acquiring another slot in reject mode", func() {
+			_, err := acquireSlot(context.Background(), slots, LimitModeReject)
+
+			Convey("Then it shall be rejected", func() {
+				So(err, ShouldResemble, rateLimitedError{reason: "concurrency limit exceeded (1)"})
+			})
+		})
+
+		Convey("When acquiring another slot in wait mode, until a deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := acquireSlot(ctx, slots, LimitModeWait)
+
+			Convey("Then it shall be rejected once the deadline is exceeded", func() {
+				So(err, ShouldResemble, rateLimitedError{reason: "concurrency limit exceeded (1): context deadline exceeded"})
+			})
+		})
+
+		Convey("When acquiring another slot once released", func() {
+			release()
+
+			release, err := acquireSlot(context.Background(), slots, LimitModeReject)
+
+			Convey("Then it shall be acquired", func() {
+				So(err, ShouldBeNil)
+				release()
+			})
+		})
+	})
+}
@@ -0,0 +1,273 @@
+package kynaptik
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	// MediaTypeApplicationFormURLEncoded is the media type of HTML forms.
+	MediaTypeApplicationFormURLEncoded = "application/x-www-form-urlencoded"
+	// MediaTypeApplicationXML is the media type of XML documents.
+	MediaTypeApplicationXML = "application/xml"
+	// MediaTypeTextXML is the (legacy) media type of XML documents.
+	MediaTypeTextXML = "text/xml"
+	// MediaTypeApplicationYAML is the media type of YAML documents.
+	MediaTypeApplicationYAML = "application/yaml"
+	// MediaTypeApplicationXYAML is the (legacy) media type of YAML documents.
+	MediaTypeApplicationXYAML = "application/x-yaml"
+	// MediaTypeTextYAML is the (legacy) media type of YAML documents.
+	MediaTypeTextYAML = "text/yaml"
+)
+
+// PayloadDecoder decodes the (raw) payload of an incoming request into the data exposed in the environment,
+// given the parameters of the media type (e.g. charset).
+type PayloadDecoder func(payload []byte, params map[string]string) (interface{}, error)
+
+type payloadDecoderRegistry struct {
+	sync.RWMutex
+	decoders map[string]PayloadDecoder
+}
+
+// payloadDecoders contains the payload decoders, by media type.
+var payloadDecoders = &payloadDecoderRegistry{
+	decoders: map[string]PayloadDecoder{
+		util.MediaTypeApplicationJSON:      decodeJSONPayload,
+		MediaTypeApplicationFormURLEncoded: decodeFormPayload,
+		MediaTypeApplicationXML:            decodeXMLPayload,
+		MediaTypeTextXML:                   decodeXMLPayload,
+		MediaTypeApplicationYAML:           decodeYAMLPayload,
+		MediaTypeApplicationXYAML:          decodeYAMLPayload,
+		MediaTypeTextYAML:                  decodeYAMLPayload,
+		util.MediaTypeTextPlain:            decodeTextPayload,
+	},
+}
+
+// RegisterPayloadDecoder registers the decoder for the given media type, replacing the existing one if any.
+func RegisterPayloadDecoder(mediaType string, decoder PayloadDecoder) {
+	payloadDecoders.Lock()
+	defer payloadDecoders.Unlock()
+
+	payloadDecoders.decoders[strings.ToLower(mediaType)] = decoder
+}
+
+// get returns the decoder registered for the given media type, or nil if none.
+func (r *payloadDecoderRegistry) get(mediaType string) PayloadDecoder {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.decoders[mediaType]
+}
+
+// mediaTypes returns the media types supported, sorted.
+func (r *payloadDecoderRegistry) mediaTypes() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	types := make([]string, 0, len(r.decoders))
+	for t := range r.decoders {
+		types = append(types, t)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// structuredSyntaxSuffixes maps the structured syntax suffixes (rfc6839) to the media type whose decoder applies.
+var structuredSyntaxSuffixes = map[string]string{
+	"+json": util.MediaTypeApplicationJSON,
+	"+xml":  MediaTypeApplicationXML,
+	"+yaml": MediaTypeApplicationYAML,
+}
+
+// acceptMediaType returns the accepted media type matching the given one (or its structured syntax suffix, e.g.
+// application/json for application/vnd.api+json), or the empty string if not accepted.
+func acceptMediaType(accept []string, mediaType string) string {
+	candidates := []string{mediaType}
+
+	for suffix, base := range structuredSyntaxSuffixes {
+		if strings.HasSuffix(mediaType, suffix) {
+			candidates = append(candidates, base)
+		}
+	}
+
+	for _, c := range candidates {
+		for _, a := range accept {
+			if strings.EqualFold(a, c) {
+				return c
+			}
+		}
+	}
+
+	return ""
+}
+
+// negotiatedMediaType is the media type of the incoming request, as negotiated against the accepted ones.
+type negotiatedMediaType struct {
+	// mediaType is the media type of the request.
+	mediaType string
+	// params are the parameters of the media type of the request.
+	params map[string]string
+	// decoder is the decoder to use.
+	decoder PayloadDecoder
+}
+
+// negotiateMediaType returns the media type of the given content type which is accepted, if any.
+func negotiateMediaType(accept []string, contentType string) (*negotiatedMediaType, bool) {
+	for _, v := range strings.Split(contentType, ",") {
+		t, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			return nil, false
+		}
+
+		if accepted := acceptMediaType(accept, t); accepted != "" {
+			if decoder := payloadDecoders.get(accepted); decoder != nil {
+				return &negotiatedMediaType{mediaType: t, params: params, decoder: decoder}, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// decodable returns true if a payload decoder is registered for the media type (or its structured syntax suffix).
+func decodable(mediaType string) bool {
+	return acceptMediaType(payloadDecoders.mediaTypes(), strings.ToLower(mediaType)) != ""
+}
+
+func decodeJSONPayload(payload []byte, _ map[string]string) (interface{}, error) {
+	var data interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// decodeFormPayload decodes a form into a map, each field being either a string, or a list of strings if the field
+// is repeated.
+func decodeFormPayload(payload []byte, _ map[string]string) (interface{}, error) {
+	values, err := url.ParseQuery(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{}, len(values))
+
+	for k, v := range values {
+		if len(v) == 1 {
+			data[k] = v[0]
+		} else {
+			data[k] = v
+		}
+	}
+
+	return data, nil
+}
+
+func decodeYAMLPayload(payload []byte, _ map[string]string) (interface{}, error) {
+	var data interface{}
+	if err := yaml.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func decodeTextPayload(payload []byte, _ map[string]string) (interface{}, error) {
+	return string(payload), nil
+}
+
+// decodeXMLPayload decodes an XML document into a map holding the root element. An element is represented by its
+// text if it has neither attributes nor children, by a map otherwise, with the attributes prefixed by '-', the
+// children by their name (a list if repeated) and the text, if any, by '#text'.
+//
+// For instance, <order id="42"><item>foo</item><item>bar</item></order> is decoded as:
+//
+//	{"order": {"-id": "42", "item": ["foo", "bar"]}}
+func decodeXMLPayload(payload []byte, _ map[string]string) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(payload))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			root, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]interface{}{start.Name.Local: root}, nil
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	node := map[string]interface{}{}
+
+	for _, attr := range start.Attr {
+		node["-"+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+
+	hasChildren := false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+
+			hasChildren = true
+			name := t.Name.Local
+
+			switch existing := node[name].(type) {
+			case nil:
+				node[name] = child
+			case []interface{}:
+				node[name] = append(existing, child)
+			default:
+				node[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+
+			if !hasChildren && len(start.Attr) == 0 {
+				return s, nil
+			}
+
+			if s != "" {
+				node["#text"] = s
+			}
+
+			return node, nil
+		}
+	}
+}
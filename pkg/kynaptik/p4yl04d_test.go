@@ -0,0 +1,153 @@
+package kynaptik
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPayloadDecoders(t *testing.T) {
+	Convey("Considering the payload decoders", t, func(c C) {
+		cases := []struct {
+			mediaType string
+			payload   string
+			expected  interface{}
+			err       string
+		}{
+			{
+				mediaType: "application/json",
+				payload:   `{"name":"john","age":42}`,
+				expected:  map[string]interface{}{"name": "john", "age": 42.0},
+			},
+			{
+				mediaType: "application/json",
+				payload:   `[{"id":1},{"id":2}]`,
+				expected:  []interface{}{map[string]interface{}{"id": 1.0}, map[string]interface{}{"id": 2.0}},
+			},
+			{
+				mediaType: "application/json",
+				payload:   `{"name":`,
+				err:       "unexpected end of JSON input",
+			},
+			{
+				mediaType: "application/x-www-form-urlencoded",
+				payload:   `name=john&tags=a&tags=b&empty=`,
+				expected:  map[string]interface{}{"name": "john", "tags": []string{"a", "b"}, "empty": ""},
+			},
+			{
+				mediaType: "application/x-www-form-urlencoded",
+				payload:   `name=%zz`,
+				err:       `invalid URL escape "%zz"`,
+			},
+			{
+				mediaType: "application/xml",
+				payload:   `<?xml version="1.0" encoding="UTF-8"?><order id="42"><item>foo</item><item sku="b">bar</item><note/></order>`,
+				expected: map[string]interface{}{
+					"order": map[string]interface{}{
+						"-id": "42",
+						"item": []interface{}{
+							"foo",
+							map[string]interface{}{"-sku": "b", "#text": "bar"},
+						},
+						"note": "",
+					},
+				},
+			},
+			{
+				mediaType: "text/xml",
+				payload:   `<message>hello</message>`,
+				expected:  map[string]interface{}{"message": "hello"},
+			},
+			{
+				mediaType: "application/xml",
+				payload:   `<order><item>foo</order>`,
+				err:       "XML syntax error on line 1: element <item> closed by </order>",
+			},
+			{
+				mediaType: "application/yaml",
+				payload:   "name: john\ntags:\n  - a\n  - b\n",
+				expected:  map[string]interface{}{"name": "john", "tags": []interface{}{"a", "b"}},
+			},
+			{
+				mediaType: "text/plain",
+				payload:   "Hello world",
+				expected:  "Hello world",
+			},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When decoding '%s' as '%s'", tc.payload, tc.mediaType), func() {
+				data, err := payloadDecoders.get(tc.mediaType)([]byte(tc.payload), nil)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.err == "" {
+						So(err, ShouldBeNil)
+						So(data, ShouldResemble, tc.expected)
+					} else {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, tc.err)
+					}
+				})
+			})
+		}
+	})
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	Convey("Considering the negotiation of the media type", t, func(c C) {
+		cases := []struct {
+			accept      []string
+			contentType string
+			expected    string
+		}{
+			{accept: []string{"application/json"}, contentType: "application/json", expected: "application/json"},
+			{accept: []string{"application/json"}, contentType: "application/json; charset=utf-8", expected: "application/json"},
+			{accept: []string{"application/json"}, contentType: "application/vnd.api+json", expected: "application/vnd.api+json"},
+			{accept: []string{"application/json"}, contentType: "text/plain", expected: ""},
+			{accept: []string{"application/json"}, contentType: "application/json;;", expected: ""},
+			{accept: []string{"text/plain", "application/xml"}, contentType: "application/atom+xml", expected: "application/atom+xml"},
+			{accept: []string{"text/plain", "application/xml"}, contentType: "application/json, text/plain", expected: "text/plain"},
+			{accept: []string{"application/vnd.unknown"}, contentType: "application/vnd.unknown", expected: ""},
+		}
+
+		for _, tc := range cases {
+			tc := tc
+			Convey(fmt.Sprintf("When negotiating '%s' against %v", tc.contentType, tc.accept), func() {
+				negotiated, ok := negotiateMediaType(tc.accept, tc.contentType)
+
+				Convey("Then result shall be the expected one", func() {
+					if tc.expected == "" {
+						So(ok, ShouldBeFalse)
+					} else {
+						So(ok, ShouldBeTrue)
+						So(negotiated.mediaType, ShouldEqual, tc.expected)
+					}
+				})
+			})
+		}
+	})
+
+	Convey("Given a payload decoder registered for a custom media type", t, func(c C) {
+		RegisterPayloadDecoder("application/vnd.custom", decodeTextPayload)
+
+		Reset(func() {
+			payloadDecoders.Lock()
+			delete(payloadDecoders.decoders, "application/vnd.custom")
+			payloadDecoders.Unlock()
+		})
+
+		Convey("When negotiating that media type", func() {
+			negotiated, ok := negotiateMediaType([]string{"application/vnd.custom"}, "application/vnd.custom")
+
+			Convey("Then the custom decoder shall be used", func() {
+				So(ok, ShouldBeTrue)
+
+				data, err := negotiated.decoder([]byte("foo"), negotiated.params)
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "foo")
+			})
+		})
+	})
+}
@@ -0,0 +1,201 @@
+package kynaptik
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/antonmedv/expr/vm"
+	"github.com/spf13/afero"
+)
+
+// ActionKind describes a kind of action, the actions of a kind being identified by the scheme of their uri.
+type ActionKind struct {
+	// Name is the name of the kind, e.g. http.
+	Name string
+	// Schemes specifies the schemes of the uri of the actions of the kind, e.g. http and https.
+	Schemes []string
+	// ConfigFactory returns the configuration with the default values for the kind, e.g. the postCondition.
+	ConfigFactory ConfigFactory
+	// ActionFactory returns new instances of actions of the kind.
+	ActionFactory ActionFactory
+}
+
+// actionKindRegistry contains the kinds of action registered, by name and by scheme.
+type actionKindRegistry struct {
+	mu       sync.RWMutex
+	byName   map[string]ActionKind
+	byScheme map[string]ActionKind
+}
+
+func newActionKindRegistry() *actionKindRegistry {
+	return &actionKindRegistry{
+		byName:   map[string]ActionKind{},
+		byScheme: map[string]ActionKind{},
+	}
+}
+
+var actionKinds = newActionKindRegistry()
+
+// RegisterActionKind makes the given kind of action available to the functions dispatching the actions according to
+// the scheme of their uri (see InvokeAnyλ). It is intended to be called from the init function of the packages
+// implementing the actions, and panics if the kind is incomplete, or if its name or one of its schemes is already
+// registered.
+func RegisterActionKind(kind ActionKind) {
+	actionKinds.register(kind)
+}
+
+// ActionKinds returns the kinds of action registered, sorted by name.
+func ActionKinds() []ActionKind {
+	return actionKinds.list()
+}
+
+func (r *actionKindRegistry) register(kind ActionKind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if kind.Name == "" || len(kind.Schemes) == 0 || kind.ActionFactory == nil {
+		panic(fmt.Sprintf("kynaptik: incomplete action kind '%s'", kind.Name))
+	}
+
+	if _, ok := r.byName[kind.Name]; ok {
+		panic(fmt.Sprintf("kynaptik: action kind '%s' registered twice", kind.Name))
+	}
+
+	for _, scheme := range kind.Schemes {
+		if other, ok := r.byScheme[strings.ToLower(scheme)]; ok {
+			panic(fmt.Sprintf("kynaptik: scheme '%s' of action kind '%s' already registered by '%s'", scheme, kind.Name, other.Name))
+		}
+	}
+
+	r.byName[kind.Name] = kind
+	for _, scheme := range kind.Schemes {
+		r.byScheme[strings.ToLower(scheme)] = kind
+	}
+}
+
+func (r *actionKindRegistry) list() []ActionKind {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kinds := make([]ActionKind, 0, len(r.byName))
+	for _, kind := range r.byName {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Name < kinds[j].Name })
+
+	return kinds
+}
+
+// kindOf returns the kind of action registered for the scheme of the given uri.
+func (r *actionKindRegistry) kindOf(uri string) (ActionKind, error) {
+	if uri == "" {
+		return ActionKind{}, fmt.Errorf("no uri specified, the kind of action cannot be determined")
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ActionKind{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kind, ok := r.byScheme[strings.ToLower(u.Scheme)]
+	if !ok {
+		return ActionKind{}, fmt.Errorf("no kind of action registered for scheme '%s' (uri '%s')", u.Scheme, uri)
+	}
+
+	return kind, nil
+}
+
+// uriOf returns the uri of the given (generic) action specification, if any.
+func uriOf(doc interface{}) string {
+	if spec, ok := doc.(map[string]interface{}); ok {
+		if uri, ok := spec["uri"].(string); ok {
+			return uri
+		}
+	}
+
+	return ""
+}
+
+// actionResolver returns the ActionFactory to use for decoding the given (generic) action specification.
+type actionResolver func(doc interface{}) (ActionFactory, error)
+
+// resolverOf returns the resolver always using the given ActionFactory or, if nil, the one of the kind of action
+// registered for the scheme of the uri.
+func resolverOf(actionFactory ActionFactory) actionResolver {
+	if actionFactory != nil {
+		return func(interface{}) (ActionFactory, error) {
+			return actionFactory, nil
+		}
+	}
+
+	return func(doc interface{}) (ActionFactory, error) {
+		kind, err := actionKinds.kindOf(uriOf(doc))
+		if err != nil {
+			return nil, err
+		}
+
+		return kind.ActionFactory, nil
+	}
+}
+
+// anyResolverOf returns the resolver using the ActionFactory of the kind of action registered for the scheme of the
+// uri, falling back to the given ActionFactory (if any) for the schemes not registered.
+func anyResolverOf(actionFactory ActionFactory) actionResolver {
+	return func(doc interface{}) (ActionFactory, error) {
+		kind, err := actionKinds.kindOf(uriOf(doc))
+		if err == nil {
+			return kind.ActionFactory, nil
+		}
+
+		if actionFactory != nil {
+			return actionFactory, nil
+		}
+
+		return nil, err
+	}
+}
+
+// defaultPostCondition returns the postCondition (and its program) of the kind of the given action, used when no
+// postCondition is specified.
+func defaultPostCondition(programs *programCache, action Action) (string, *vm.Program, error) {
+	kind, err := actionKinds.kindOf(action.GetURI())
+	if err != nil {
+		return "", nil, fmt.Errorf("no postCondition specified: %w", err)
+	}
+
+	var postCondition string
+	if kind.ConfigFactory != nil {
+		postCondition = kind.ConfigFactory().PostCondition
+	}
+
+	if postCondition == "" {
+		return "", nil, fmt.Errorf("no postCondition specified, and none by default for action kind '%s'", kind.Name)
+	}
+
+	program, err := programs.compile(postCondition)
+
+	return postCondition, program, err
+}
+
+// DefaultConfig returns the configuration with the default values common to all the kinds of action, the
+// postCondition defaulting to the one of the kind of each action.
+func DefaultConfig() Config {
+	return Config{
+		// PreCondition specifies the default pre-condition value. Here, we accept everything.
+		PreCondition: "true",
+	}
+}
+
+// InvokeAnyλ performs the invocation of the λ function, the kind of each action being selected among the registered
+// ones (see RegisterActionKind) according to the scheme of its uri.
+func InvokeAnyλ(w http.ResponseWriter, r *http.Request, fs afero.Fs) {
+	Invokeλ(w, r, fs, DefaultConfig, nil)
+}
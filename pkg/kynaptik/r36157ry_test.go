@@ -0,0 +1,78 @@
+package kynaptik
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestActionKindRegistry(t *testing.T) {
+	Convey("Considering a registry of action kinds", t, func(c C) {
+		registry := newActionKindRegistry()
+		actionFactory := func() Action { return &protoAction{} }
+
+		registry.register(ActionKind{Name: "proto", Schemes: []string{"null", "Proto"}, ActionFactory: actionFactory})
+		registry.register(ActionKind{Name: "echo", Schemes: []string{"echo"}, ActionFactory: actionFactory})
+
+		Convey("When listing the kinds", func() {
+			kinds := registry.list()
+
+			Convey("Then they shall be sorted by name", func() {
+				So(kinds, ShouldHaveLength, 2)
+				So(kinds[0].Name, ShouldEqual, "echo")
+				So(kinds[1].Name, ShouldEqual, "proto")
+			})
+		})
+
+		Convey("When looking up the kind of a uri whose scheme is registered", func() {
+			kind, err := registry.kindOf("PROTO://foo")
+
+			Convey("Then the kind shall be found (regardless of the case)", func() {
+				So(err, ShouldBeNil)
+				So(kind.Name, ShouldEqual, "proto")
+			})
+		})
+
+		Convey("When looking up the kind of a uri whose scheme is not registered", func() {
+			_, err := registry.kindOf("ftp://foo")
+
+			Convey("Then an error shall be returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "no kind of action registered for scheme 'ftp' (uri 'ftp://foo')")
+			})
+		})
+
+		Convey("When looking up the kind of an empty uri", func() {
+			_, err := registry.kindOf("")
+
+			Convey("Then an error shall be returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "no uri specified, the kind of action cannot be determined")
+			})
+		})
+
+		Convey("When registering a kind twice", func() {
+			Convey("Then it shall panic", func() {
+				So(func() {
+					registry.register(ActionKind{Name: "echo", Schemes: []string{"echos"}, ActionFactory: actionFactory})
+				}, ShouldPanicWith, "kynaptik: action kind 'echo' registered twice")
+			})
+		})
+
+		Convey("When registering a scheme twice", func() {
+			Convey("Then it shall panic", func() {
+				So(func() {
+					registry.register(ActionKind{Name: "other", Schemes: []string{"proto"}, ActionFactory: actionFactory})
+				}, ShouldPanicWith, "kynaptik: scheme 'proto' of action kind 'other' already registered by 'proto'")
+			})
+		})
+
+		Convey("When registering an incomplete kind", func() {
+			Convey("Then it shall panic", func() {
+				So(func() {
+					registry.register(ActionKind{Name: "other", Schemes: []string{"other"}})
+				}, ShouldPanicWith, "kynaptik: incomplete action kind 'other'")
+			})
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package kynaptik
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultRetryOn specifies the default condition for an action to be retried: retry on errors only.
+const DefaultRetryOn = "error != nil"
+
+// Retry specifies the policy for retrying the actions which failed.
+type Retry struct {
+	// MaxAttempts specifies the maximum number of attempts (including the first one).
+	// A MaxAttempts of zero (or one) means no retry.
+	MaxAttempts int `yaml:"maxAttempts" validate:"gte=0"`
+	// InitialInterval specifies the time (in ms) to wait before the first retry.
+	InitialInterval time.Duration `yaml:"initialInterval" validate:"gte=0"`
+	// Multiplier specifies the factor by which the interval is multiplied after each attempt.
+	// A Multiplier lower than 1 means a constant interval.
+	Multiplier float64 `yaml:"multiplier" validate:"gte=0"`
+	// MaxInterval specifies the upper bound (in ms) of the interval between two attempts.
+	// A MaxInterval of zero means no upper bound.
+	MaxInterval time.Duration `yaml:"maxInterval" validate:"gte=0"`
+	// Jitter specifies the randomization factor applied to the interval, in the range [0, 1].
+	// For instance, a jitter of 0.2 with an interval of 1s gives an actual interval between 0.8s and 1.2s.
+	Jitter float64 `yaml:"jitter" validate:"gte=0,lte=1"`
+	// RetryOn specifies the condition (textual) to be satisfied for the action to be retried. The condition is
+	// evaluated against the environment, along with the `response`, the `error` (message) and the `attempt` number.
+	RetryOn string `yaml:"retryOn"`
+}
+
+// enabled returns true if the policy allows at least one retry.
+func (p Retry) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// retryOn returns the condition to consider for retrying.
+func (p Retry) retryOn() string {
+	if p.RetryOn == "" {
+		return DefaultRetryOn
+	}
+
+	return p.RetryOn
+}
+
+// interval returns the interval to wait after the given (failed) attempt, starting from 1.
+func (p Retry) interval(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	interval := float64(p.InitialInterval*time.Millisecond) * math.Pow(multiplier, float64(attempt-1))
+
+	if p.MaxInterval > 0 {
+		interval = math.Min(interval, float64(p.MaxInterval*time.Millisecond))
+	}
+
+	if p.Jitter > 0 {
+		interval += interval * p.Jitter * (2*rand.Float64() - 1) //nolint:gosec // no need for a strong random here
+	}
+
+	// the exponential growth may exceed the range of a duration (or even be infinite), the interval is then clamped
+	if interval >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(interval)
+}
+
+// MarshalZerologObject produces logs related to the retry policy.
+func (p Retry) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Int("maxAttempts", p.MaxAttempts).
+		Dur("initialInterval", p.InitialInterval*time.Millisecond).
+		Float64("multiplier", p.Multiplier).
+		Dur("maxInterval", p.MaxInterval*time.Millisecond).
+		Float64("jitter", p.Jitter).
+		Str("retryOn", p.retryOn())
+}
+
+// sleep pauses the current goroutine for the given duration, unless the context is done before, in which case the
+// context error is returned.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
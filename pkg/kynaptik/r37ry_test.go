@@ -0,0 +1,84 @@
+package kynaptik
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryInterval(t *testing.T) {
+	Convey("Considering the interval computation of the retry policy", t, func(c C) {
+		cases := []struct {
+			retry    Retry
+			attempt  int
+			expected time.Duration
+		}{
+			{
+				retry:    Retry{InitialInterval: 100},
+				attempt:  1,
+				expected: 100 * time.Millisecond,
+			},
+			{
+				retry:    Retry{InitialInterval: 100},
+				attempt:  3,
+				expected: 100 * time.Millisecond,
+			},
+			{
+				retry:    Retry{InitialInterval: 100, Multiplier: 2},
+				attempt:  3,
+				expected: 400 * time.Millisecond,
+			},
+			{
+				retry:    Retry{InitialInterval: 100, Multiplier: 2, MaxInterval: 300},
+				attempt:  3,
+				expected: 300 * time.Millisecond,
+			},
+			{
+				retry:    Retry{InitialInterval: 100, Multiplier: 2},
+				attempt:  100,
+				expected: math.MaxInt64,
+			},
+			{
+				retry:    Retry{InitialInterval: 100, Multiplier: 10},
+				attempt:  1000,
+				expected: math.MaxInt64,
+			},
+		}
+
+		for n, c := range cases {
+			Convey(fmt.Sprintf("When computing the interval for attempt %d (case %d)", c.attempt, n), func() {
+				interval := c.retry.interval(c.attempt)
+
+				Convey(fmt.Sprintf("Then interval shall be %s", c.expected), func() {
+					So(interval, ShouldEqual, c.expected)
+					So(interval, ShouldBeGreaterThan, 0)
+				})
+			})
+		}
+
+		Convey("When computing the interval with a jitter for a large attempt", func() {
+			retry := Retry{InitialInterval: 1000, Multiplier: 2, Jitter: 0.2}
+
+			Convey("Then interval shall remain positive", func() {
+				for i := 0; i < 100; i++ {
+					So(retry.interval(200), ShouldBeGreaterThan, 0)
+				}
+			})
+		})
+
+		Convey("When computing the interval with a jitter", func() {
+			retry := Retry{InitialInterval: 1000, Jitter: 0.2}
+
+			Convey("Then interval shall be randomized within the expected range", func() {
+				for i := 0; i < 100; i++ {
+					interval := retry.interval(1)
+
+					So(interval, ShouldBeBetweenOrEqual, 800*time.Millisecond, 1200*time.Millisecond)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,119 @@
+package kynaptik
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/antonmedv/expr/vm"
+	"github.com/ccamel/kynaptik/internal/util"
+	"github.com/rs/zerolog"
+)
+
+// Reply specifies the reply sent back to the caller in place of the JSend envelope, once the actions have been
+// performed.
+type Reply struct {
+	// StatusCode specifies the expression giving the status code of the reply. The status code of the outcome by
+	// default, i.e. 200 on success.
+	StatusCode string `yaml:"statusCode"`
+	// Headers specifies the headers of the reply, the values being templates.
+	Headers map[string]string `yaml:"headers"`
+	// Body specifies the template of the body of the reply.
+	Body string `yaml:"body"`
+	// OnFailure specifies if the reply is also sent when the actions failed. If not, the JSend envelope is sent on
+	// failure.
+	OnFailure bool `yaml:"onFailure"`
+}
+
+// enabled returns true if a reply is specified.
+func (p Reply) enabled() bool {
+	return p.StatusCode != "" || p.Body != "" || len(p.Headers) > 0
+}
+
+// MarshalZerologObject produces logs related to the reply.
+func (p Reply) MarshalZerologObject(e *zerolog.Event) {
+	e.
+		Str("statusCode", p.StatusCode).
+		Object("headers", util.MapToLogObjectMarshaller(p.Headers)).
+		Bool("onFailure", p.OnFailure)
+}
+
+// replyEnvironment returns the environment the reply is rendered with, i.e. the given environment enriched with the
+// response of the (last or failed) action, the stage reached, the message and the outcomes.
+func replyEnvironment(env environment, response interface{}, code int, message string, data *ResponseData) environment {
+	e := make(environment, len(env)+5)
+	for k, v := range env {
+		e[k] = v
+	}
+
+	e["response"] = response
+	e["statusCode"] = code
+	e["stage"] = data.Stage
+	e["message"] = message
+	e["outcomes"] = data.Outcomes
+
+	return e
+}
+
+// send renders the reply according to the environment and sends it, the status code of the outcome being used unless
+// the statusCode expression is specified.
+func (p Reply) send(w http.ResponseWriter, program *vm.Program, env environment, code int) error {
+	if program != nil {
+		var err error
+		if code, err = util.EvaluateIntegerExpression(program, env); err != nil {
+			return err
+		}
+
+		if code < 100 || code > 599 {
+			return fmt.Errorf("incorrect status code %d returned when evaluating expression '%s'", code, p.StatusCode)
+		}
+	}
+
+	header := http.Header{}
+
+	for k, v := range p.Headers {
+		value, err := renderReplyTemplate(k, v, env)
+		if err != nil {
+			return err
+		}
+
+		header.Set(k, value)
+	}
+
+	body, err := renderReplyTemplate("body", p.Body, env)
+	if err != nil {
+		return err
+	}
+
+	if header.Get(util.HeaderContentType) == "" {
+		if json.Valid([]byte(body)) {
+			header.Set(util.HeaderContentType, util.MediaTypeApplicationJSON)
+		} else {
+			header.Set(util.HeaderContentType, "text/plain; charset=utf-8")
+		}
+	}
+
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+
+	w.WriteHeader(code)
+	_, err = w.Write([]byte(body))
+
+	return err
+}
+
+func renderReplyTemplate(name, s string, env environment) (string, error) {
+	out, err := util.RenderTemplatedString(name, s, env)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadAll(out)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,102 @@
+package kynaptik
+
+import (
+	"fmt"
+
+	"github.com/antonmedv/expr/vm"
+)
+
+// rule is the runtime counterpart of a Rule, i.e. with its conditions compiled.
+type rule struct {
+	Rule
+	// explicit tells whether the rule comes from the rules list, or has been derived from the top-level
+	// configuration.
+	explicit             bool
+	preConditionProgram  *vm.Program
+	postConditionProgram *vm.Program
+}
+
+// newRules returns the rules to consider for the given configuration, applying the default values.
+// Without rules specified, a single (implicit) rule is derived from the top-level configuration.
+func newRules(c Config) []*rule {
+	if len(c.Rules) == 0 {
+		return []*rule{{
+			Rule: Rule{
+				PreCondition:  c.PreCondition,
+				Action:        c.Action,
+				PostCondition: c.PostCondition,
+			},
+		}}
+	}
+
+	rules := make([]*rule, len(c.Rules))
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if r.PreCondition == "" {
+			r.PreCondition = c.PreCondition
+		}
+
+		if r.PostCondition == "" {
+			r.PostCondition = c.PostCondition
+		}
+
+		rules[i] = &rule{Rule: r, explicit: true}
+	}
+
+	return rules
+}
+
+// wrapError decorates the given error with the name of the rule (if explicit), so that the faulty rule can be
+// identified.
+func (r *rule) wrapError(err error) error {
+	if !r.explicit {
+		return err
+	}
+
+	return fmt.Errorf("rule '%s': %w", r.Name, err)
+}
+
+// firedRuleNames returns the names of the (explicit) rules fired.
+func firedRuleNames(rules []*rule) []string {
+	var names []string
+
+	for _, r := range rules {
+		if r.explicit {
+			names = append(names, r.Name)
+		}
+	}
+
+	return names
+}
+
+// task is the unit of work performed by the function: the action built from a fired rule along with the
+// environment the action is evaluated against, and the result of its execution.
+type task struct {
+	rule   *rule
+	action Action
+	env    environment
+	// postCondition is the postCondition of the rule or, if none, the one by default of the kind of the action.
+	postCondition        string
+	postConditionProgram *vm.Program
+	response             interface{}
+	err                  error
+}
+
+// newTask returns a new task for the given rule and action, with its own copy of the environment.
+func newTask(r *rule, action Action, env environment) *task {
+	e := make(environment, len(env))
+	for k, v := range env {
+		e[k] = v
+	}
+
+	return &task{
+		rule:                 r,
+		action:               action,
+		env:                  e,
+		postCondition:        r.PostCondition,
+		postConditionProgram: r.postConditionProgram,
+	}
+}